@@ -0,0 +1,51 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package cmd
+
+import (
+	"log/slog"
+
+	"github.com/spf13/viper"
+	"github.com/tschaefer/conntrackd/internal/admin"
+	"github.com/tschaefer/conntrackd/internal/logger"
+	"github.com/tschaefer/conntrackd/internal/service"
+)
+
+// daemon exposes the running service and its reloader to the admin API.
+type daemon struct {
+	*service.Service
+	reloader *reloader
+}
+
+func (d *daemon) SetLogLevel(level string) error {
+	return logger.SetLevel(level)
+}
+
+func (d *daemon) Reload() error {
+	return d.reloader.reload()
+}
+
+// startAdmin serves the admin API on the configured socket, if enabled.
+// Returns nil if disabled.
+func startAdmin(service *service.Service, reloader *reloader) (*admin.Server, error) {
+	if !viper.GetBool("admin.enable") {
+		return nil, nil
+	}
+
+	path := viper.GetString("admin.socket")
+	server, err := admin.Listen(path, &daemon{Service: service, reloader: reloader})
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		if err := server.Serve(); err != nil {
+			slog.Error("Admin API failed.", "error", err)
+		}
+	}()
+	slog.Info("Serving admin API.", "socket", path)
+
+	return server, nil
+}
@@ -0,0 +1,193 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tschaefer/conntrackd/internal/capture"
+	"github.com/tschaefer/conntrackd/internal/filter"
+	"github.com/tschaefer/conntrackd/internal/listener"
+	"golang.org/x/sys/unix"
+)
+
+var captureCmd = &cobra.Command{
+	Use:   "capture FILE",
+	Short: "Capture raw conntrack events to a file",
+	Long: `Capture the raw conntrack events of all network namespaces to a file, e.g.
+to reproduce a filter issue by 'conntrackd replay'. Each event is written as
+received from the kernel with its receive time and network namespace id, the
+file header carries host name, kernel release and filter rules. FILE - writes
+to stdout.
+
+Capturing ends after --duration, after --count events or on interrupt. Filter
+rules given by --filter, --filter.file or the configuration file given by
+--config pre-filter the events, events dropped by the rules are not captured.
+GeoIP variables are not available to the rules. Unlike 'conntrackd run',
+events of all protocols are captured, not only TCP and UDP.
+
+The network namespace id is the id assigned to the namespace of the event in
+the namespace of conntrackd. The kernel omits it for events of the own
+namespace and of namespaces without assigned id, these are recorded as -1.`,
+	Example: `  conntrackd capture events.cap --duration 10m
+  conntrackd capture events.cap --count 1000 --filter 'drop destination.port != 53'`,
+	Args: cobra.ExactArgs(1),
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindFilterFlags(cmd)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		duration, _ := cmd.Flags().GetDuration("duration")
+		count, _ := cmd.Flags().GetUint64("count")
+		buffer, _ := cmd.Flags().GetInt("netlink.buffer")
+
+		f, err := newFilter()
+		if err != nil {
+			cobra.CheckErr(err.Error())
+		}
+
+		l, err := listener.Dial(listener.Config{ReadBuffer: buffer, Workers: 1, ReportLoss: true, AllProtocols: true})
+		if err != nil {
+			cobra.CheckErr(fmt.Sprintf("Failed to open netlink socket: %v", err))
+		}
+		report, err := l.Apply(f.Prefilter())
+		if err != nil {
+			_ = l.Close()
+			cobra.CheckErr(fmt.Sprintf("Failed to subscribe to conntrack events: %v", err))
+		}
+
+		var out io.WriteCloser = os.Stdout
+		if args[0] != "-" {
+			if out, err = os.Create(args[0]); err != nil {
+				_ = l.Close()
+				cobra.CheckErr(fmt.Sprintf("Failed to create capture file: %v", err))
+			}
+		}
+		w, err := capture.NewWriter(out, newCaptureHeader(f))
+		if err != nil {
+			_ = l.Close()
+			cobra.CheckErr(fmt.Sprintf("Failed to write capture file: %v", err))
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		if duration > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, duration)
+			defer cancel()
+		}
+
+		slog.Info("Capturing conntrack events.",
+			"file", args[0], "events", report.Events, "userspace", !report.Complete,
+			"duration", duration, "count", count,
+		)
+
+		msgCh := make(chan listener.Message, 1024)
+		errCh := l.ListenMessages(msgCh)
+		captured, err := captureEvents(ctx, w, f, report.Complete, count, msgCh, errCh)
+		closeCapture(l, msgCh)
+
+		if flushErr := w.Flush(); err == nil {
+			err = flushErr
+		}
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+
+		if loss := l.TakeLoss(); loss.Overruns > 0 {
+			slog.Warn("Lost conntrack events, netlink receive buffer overrun.",
+				"overruns", loss.Overruns, "first", loss.First, "last", loss.Last,
+			)
+		}
+		slog.Info("Captured conntrack events.", "file", args[0], "count", captured)
+
+		if err != nil {
+			cobra.CheckErr(fmt.Sprintf("Failed to capture conntrack events: %v", err))
+		}
+	},
+}
+
+// newCaptureHeader returns the header of a capture pre-filtered by the
+// filter.
+func newCaptureHeader(f *filter.Filter) capture.Header {
+	header := capture.Header{Started: time.Now()}
+
+	header.Host, _ = os.Hostname()
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err == nil {
+		header.Kernel = unix.ByteSliceToString(uts.Release[:])
+	}
+	for _, rule := range f.Rules() {
+		header.Rules = append(header.Rules, rule.String())
+	}
+
+	return header
+}
+
+// captureEvents writes the received messages until the context is done,
+// count events are captured or the listener fails. Unless the kernel
+// pre-filter is complete, events are decided by the filter. Returns the
+// number of captured events.
+func captureEvents(ctx context.Context, w *capture.Writer, f *filter.Filter, complete bool, count uint64, msgCh <-chan listener.Message, errCh <-chan error) (uint64, error) {
+	var captured uint64
+	for count == 0 || captured < count {
+		select {
+		case <-ctx.Done():
+			return captured, nil
+		case err := <-errCh:
+			return captured, err
+		case message := <-msgCh:
+			record := capture.Record{Received: message.Received, NSID: message.NSID, Message: message.Message}
+			if !complete {
+				event, err := record.Event()
+				if err != nil {
+					return captured, fmt.Errorf("decode conntrack event: %w", err)
+				}
+				if !f.Decide(event, nil).Log {
+					continue
+				}
+			}
+
+			if err := w.Write(record); err != nil {
+				return captured, err
+			}
+			captured++
+		}
+	}
+
+	return captured, nil
+}
+
+// closeCapture closes the listener, discarding messages still received.
+func closeCapture(l *listener.Listener, msgCh <-chan listener.Message) {
+	closed := make(chan struct{})
+	go func() {
+		_ = l.Close()
+		close(closed)
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-msgCh:
+		}
+	}
+}
+
+func init() {
+	addFilterFlags(captureCmd)
+
+	captureCmd.Flags().Duration("duration", 0, "Stop capturing after this duration, 0 captures until interrupted")
+	captureCmd.Flags().Uint64("count", 0, "Stop capturing after this number of events, 0 captures until interrupted")
+	captureCmd.Flags().Int("netlink.buffer", 0, "Netlink socket receive buffer size in bytes (default is the system default)")
+}
@@ -0,0 +1,102 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/tschaefer/conntrackd/internal/admin"
+	"github.com/tschaefer/conntrackd/internal/logger"
+)
+
+var ctlCmd = &cobra.Command{
+	Use:   "ctl",
+	Short: "Control the running conntrackd service",
+	Long: `Control the running conntrackd service through its admin API. The service
+must run with --admin.enable, the socket is only accessible to its owner.`,
+}
+
+var ctlStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the status of the running service",
+	Long: `Show release, uptime, log level, sinks, event queue, GeoIP databases and the
+loaded rules with their number of matched events and evaluation errors.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		status, err := newAdminClient(cmd).Status()
+		cobra.CheckErr(err)
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			encoder.SetIndent("", "  ")
+			cobra.CheckErr(encoder.Encode(status))
+			return
+		}
+		cobra.CheckErr(admin.WriteStatus(cmd.OutOrStdout(), status))
+	},
+}
+
+var ctlLogLevelCmd = &cobra.Command{
+	Use:       "log-level LEVEL",
+	Short:     "Change the log level of the running service",
+	Long:      `Change the log level of the running service until restart.`,
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: logger.Levels,
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(newAdminClient(cmd).SetLogLevel(args[0]))
+	},
+}
+
+var ctlReloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Reload the configuration of the running service",
+	Long: `Reload the configuration of the running service like on SIGHUP. Fails with
+the error if the new configuration fails, the current one is kept.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(newAdminClient(cmd).Reload())
+	},
+}
+
+var ctlSinkCmd = &cobra.Command{
+	Use:   "sink NAME enable|disable",
+	Short: "Enable or disable a sink of the running service",
+	Long: `Enable or disable a configured sink of the running service. Records for a
+disabled sink are dropped. A reload restores the configured sinks.`,
+	Example: `  conntrackd ctl sink loki disable`,
+	Args:    cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		var enabled bool
+		switch args[1] {
+		case "enable":
+			enabled = true
+		case "disable":
+		default:
+			cobra.CheckErr(fmt.Sprintf("Invalid sink state %q, expected enable or disable", args[1]))
+		}
+
+		cobra.CheckErr(newAdminClient(cmd).SetSink(args[0], enabled))
+	},
+}
+
+// newAdminClient returns a client of the admin socket given by --socket.
+func newAdminClient(cmd *cobra.Command) *admin.Client {
+	socket, _ := cmd.Flags().GetString("socket")
+	return admin.NewClient(socket)
+}
+
+func init() {
+	ctlCmd.PersistentFlags().String("socket", admin.DefaultSocket, "Path to the admin socket of the service")
+	_ = ctlCmd.RegisterFlagCompletionFunc("socket", cobra.FixedCompletions(nil, cobra.ShellCompDirectiveDefault))
+
+	ctlStatusCmd.Flags().Bool("json", false, "Print the status as JSON")
+
+	ctlCmd.AddCommand(ctlStatusCmd)
+	ctlCmd.AddCommand(ctlLogLevelCmd)
+	ctlCmd.AddCommand(ctlReloadCmd)
+	ctlCmd.AddCommand(ctlSinkCmd)
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tschaefer/conntrackd/internal/dump"
+	"github.com/tschaefer/conntrackd/internal/listener"
+)
+
+var dumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "List the current conntrack table",
+	Long: `List the flows of the conntrack table of the current network namespace,
+filtered and enriched like the events of the running service. Only TCP and
+UDP flows are listed.
+
+Rules are taken from --filter, --filter.file and the configuration file given
+by --config and decide the flows as UPDATE events, flows dropped by the rules
+are not listed. Tags are shown, GeoIP data is added if a database is given.
+
+Flows are printed as table, as JSON records one per line like the stream sink
+or as CSV. --sort sorts the flows, packets and bytes descending. --group
+summarizes the flows by destination address, destination country or TCP
+state instead.`,
+	Example: `  conntrackd dump --filter 'drop destination.port != 443' --sort bytes
+  conntrackd dump --geoip.database /usr/share/GeoIP/GeoLite2-City.mmdb --group country
+  conntrackd dump --config /etc/conntrackd/conntrackd.yaml --output json`,
+	Args: cobra.NoArgs,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindFilterFlags(cmd)
+		for _, key := range []string{"geoip.database", "geoip.asn_database"} {
+			_ = viper.BindPFlag(key, cmd.Flags().Lookup(key))
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		sortKey, _ := cmd.Flags().GetString("sort")
+		groupKey, _ := cmd.Flags().GetString("group")
+
+		f, err := newFilter()
+		if err != nil {
+			cobra.CheckErr(err.Error())
+		}
+
+		g, err := newGeoIP()
+		if err != nil {
+			cobra.CheckErr(fmt.Sprintf("failed to open geoip database: %v", err))
+		}
+		if g != nil {
+			defer func() {
+				_ = g.Close()
+			}()
+		}
+
+		flows, err := listener.Snapshot()
+		if err != nil {
+			cobra.CheckErr(fmt.Sprintf("failed to dump conntrack table: %v", err))
+		}
+
+		records := dump.Collect(flows, f, g)
+		if sortKey != "" {
+			cobra.CheckErr(dump.Sort(records, sortKey))
+		}
+
+		if groupKey != "" {
+			groups, err := dump.GroupBy(records, groupKey)
+			cobra.CheckErr(err)
+			cobra.CheckErr(dump.WriteGroups(cmd.OutOrStdout(), output, groupKey, groups))
+			return
+		}
+		cobra.CheckErr(dump.WriteRecords(cmd.OutOrStdout(), output, records))
+	},
+}
+
+func init() {
+	addFilterFlags(dumpCmd)
+
+	dumpCmd.Flags().StringP("output", "o", "table", fmt.Sprintf("Output format (%s)", strings.Join(dump.Formats, ", ")))
+	_ = dumpCmd.RegisterFlagCompletionFunc("output", cobra.FixedCompletions(dump.Formats, cobra.ShellCompDirectiveNoFileComp))
+	dumpCmd.Flags().String("sort", "", fmt.Sprintf("Sort flows by key (%s)", strings.Join(dump.SortKeys, ", ")))
+	_ = dumpCmd.RegisterFlagCompletionFunc("sort", cobra.FixedCompletions(dump.SortKeys, cobra.ShellCompDirectiveNoFileComp))
+	dumpCmd.Flags().String("group", "", fmt.Sprintf("Summarize flows grouped by key (%s)", strings.Join(dump.GroupKeys, ", ")))
+	_ = dumpCmd.RegisterFlagCompletionFunc("group", cobra.FixedCompletions(dump.GroupKeys, cobra.ShellCompDirectiveNoFileComp))
+	dumpCmd.Flags().String("geoip.database", "", "Path to GeoIP database for GeoIP variables and data")
+	_ = dumpCmd.RegisterFlagCompletionFunc("geoip.database", cobra.FixedCompletions(nil, cobra.ShellCompDirectiveDefault))
+	dumpCmd.Flags().String("geoip.asn_database", "", "Path to GeoIP ASN database for ASN variables and data")
+	_ = dumpCmd.RegisterFlagCompletionFunc("geoip.asn_database", cobra.FixedCompletions(nil, cobra.ShellCompDirectiveDefault))
+}
@@ -0,0 +1,296 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tschaefer/conntrackd/internal/config"
+	"github.com/tschaefer/conntrackd/internal/filter"
+	"github.com/tschaefer/conntrackd/internal/geoip"
+	"github.com/tschaefer/conntrackd/internal/record"
+)
+
+var filterCmd = &cobra.Command{
+	Use:   "filter",
+	Short: "Develop and check filter rules",
+}
+
+var filterTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Evaluate filter rules against events",
+	Long: `Evaluate filter rules against events and print the matching rule and the
+resulting action for each event.
+
+Rules are taken from --filter, --filter.file and the configuration file given
+by --config. Events are given by flags, as JSON records by --event or as file
+of recorded JSON records, one per line, by --events. Exits non-zero if the
+rules fail to compile.`,
+	Example: `  conntrackd filter test --filter.file /etc/conntrackd/filter.d \
+    --src 10.0.0.1 --dst 8.8.8.8 --dport 53 --proto UDP --type NEW
+  conntrackd filter test --config conntrackd.yaml --events recorded.json`,
+	Args: cobra.NoArgs,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindFilterFlags(cmd)
+		for _, key := range []string{"geoip.database", "geoip.asn_database"} {
+			_ = viper.BindPFlag(key, cmd.Flags().Lookup(key))
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		f, err := newFilter()
+		if err != nil {
+			cobra.CheckErr(err.Error())
+		}
+
+		g, err := newGeoIP()
+		if err != nil {
+			cobra.CheckErr(fmt.Sprintf("failed to open geoip database: %v", err))
+		}
+		if g != nil {
+			defer func() {
+				_ = g.Close()
+			}()
+		}
+
+		events, err := readTestEvents(cmd)
+		if err != nil {
+			cobra.CheckErr(fmt.Sprintf("failed to read events: %v", err))
+		}
+
+		rules := make(map[string]filter.Rule)
+		for _, rule := range f.Rules() {
+			rules[rule.Name] = rule
+		}
+
+		out := cmd.OutOrStdout()
+		for i, e := range events {
+			event, err := e.Conntrack()
+			if err != nil {
+				cobra.CheckErr(fmt.Sprintf("invalid event %d: %v", i+1, err))
+			}
+
+			verdict := f.Decide(event, g.Lookup())
+
+			if i > 0 {
+				_, _ = fmt.Fprintln(out)
+			}
+			_, _ = fmt.Fprintln(out, e.Message())
+			if !verdict.Matched {
+				_, _ = fmt.Fprintf(out, "  rule:   none\n  action: %s (default)\n", verdict.Action)
+			} else {
+				name := verdict.Rule
+				rule := rules[name]
+				if rule.Source != rule.Name {
+					name = fmt.Sprintf("%s (%s)", rule.Name, rule.Source)
+				}
+				action := verdict.Action
+				if strings.HasPrefix(action, "sample ") {
+					if verdict.Log {
+						action += " (log)"
+					} else {
+						action += " (drop)"
+					}
+				}
+				_, _ = fmt.Fprintf(out, "  rule:   %s\n  expr:   %s\n  action: %s\n",
+					name, strings.Join(strings.Fields(rule.Expr), " "), action)
+			}
+			if len(verdict.Tags) > 0 {
+				_, _ = fmt.Fprintf(out, "  tags:   %s\n", strings.Join(verdict.Tags, ", "))
+			}
+		}
+	},
+}
+
+var filterLintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Check filter rules for issues",
+	Long: `Compile filter rules and check them for issues: unknown network categories,
+invalid CIDR literals, expressions not of type bool, rules never matching,
+unreachable after an unconditional rule, shadowed by a rule with the same
+expression or redundant with the default action.
+
+Rules are taken from --filter, --filter.file and the configuration file given
+by --config. Exits non-zero if the rules fail to compile or have issues.`,
+	Example: `  conntrackd filter lint --filter.file /etc/conntrackd/filter.d
+  conntrackd filter lint --config conntrackd.yaml`,
+	Args: cobra.NoArgs,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindFilterFlags(cmd)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		f, err := newFilter()
+		if err != nil {
+			cobra.CheckErr(err.Error())
+		}
+
+		out := cmd.OutOrStdout()
+		issues := f.Issues()
+		for _, issue := range issues {
+			_, _ = fmt.Fprintln(out, issue)
+		}
+		if len(issues) > 0 {
+			os.Exit(1)
+		}
+
+		_, _ = fmt.Fprintf(out, "%d rules checked, no issues found.\n", len(f.Rules()))
+	},
+}
+
+// logFilter reports the filter policies and warns about issues of the
+// filter rules and GeoIP variables used without GeoIP database.
+func logFilter(f *filter.Filter, g *geoip.GeoIP) {
+	slog.Info("Filter rules loaded.",
+		"rules", len(f.Rules()), "sets", len(f.Sets()), "default", f.Default(), "on_error", f.OnError(),
+	)
+	for _, issue := range f.Issues() {
+		slog.Warn("Filter rule issue.", "rule", issue.Rule.Name, "source", issue.Rule.Source, "issue", issue.Message)
+	}
+	if f.UsesGeo() && g == nil {
+		slog.Warn("Filter rules use GeoIP variables, but no GeoIP database is configured.")
+	}
+}
+
+// addFilterFlags adds the flags selecting the filter rules and policies.
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&cfgFile, "config", "", "config file to read filter rules from")
+	cmd.Flags().StringArray("filter", nil, "Filter rules in CEL format (repeatable, first-match wins)")
+	cmd.Flags().StringArray("filter.file", nil, "Filter rule files or directories (repeatable)")
+	_ = cmd.RegisterFlagCompletionFunc("filter.file", cobra.FixedCompletions(nil, cobra.ShellCompDirectiveDefault))
+	cmd.Flags().StringArray("set", nil, "Named set for in_set loaded from a file, as name=file (repeatable)")
+	cmd.Flags().String("filter.default", "log", fmt.Sprintf("Action for events matching no rule (%s)", strings.Join(filter.DefaultActions, ", ")))
+	_ = cmd.RegisterFlagCompletionFunc("filter.default", cobra.FixedCompletions(filter.DefaultActions, cobra.ShellCompDirectiveNoFileComp))
+	cmd.Flags().String("filter.on_error", "skip", fmt.Sprintf("Handling of rule evaluation errors (%s)", strings.Join(filter.ErrorPolicies, ", ")))
+	_ = cmd.RegisterFlagCompletionFunc("filter.on_error", cobra.FixedCompletions(filter.ErrorPolicies, cobra.ShellCompDirectiveNoFileComp))
+}
+
+// bindFilterFlags binds the filter flags of the command and reads the
+// configuration file, if given.
+func bindFilterFlags(cmd *cobra.Command) {
+	for _, key := range []string{"filter", "filter.file", "filter.default", "filter.on_error", "set"} {
+		_ = viper.BindPFlag(key, cmd.Flags().Lookup(key))
+	}
+
+	if cfgFile != "" {
+		if err := config.InitConfig(cfgFile); err != nil {
+			cobra.CheckErr(fmt.Sprintf("Failed to initialize configuration: %v", err))
+		}
+	}
+}
+
+// readTestEvents returns the events given by flags.
+func readTestEvents(cmd *cobra.Command) ([]*record.Event, error) {
+	var events []*record.Event
+
+	jsonEvents, _ := cmd.Flags().GetStringArray("event")
+	for i, data := range jsonEvents {
+		var e record.Event
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return nil, fmt.Errorf("--event %d: %w", i+1, err)
+		}
+		events = append(events, &e)
+	}
+
+	if file, _ := cmd.Flags().GetString("events"); file != "" {
+		fileEvents, err := readEventFile(file)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, fileEvents...)
+	}
+
+	if len(events) > 0 {
+		return events, nil
+	}
+
+	e := &record.Event{}
+	e.Type, _ = cmd.Flags().GetString("type")
+	e.Protocol, _ = cmd.Flags().GetString("proto")
+	e.SrcAddr, _ = cmd.Flags().GetString("src")
+	e.DstAddr, _ = cmd.Flags().GetString("dst")
+	e.SrcPort, _ = cmd.Flags().GetUint16("sport")
+	e.DstPort, _ = cmd.Flags().GetUint16("dport")
+	e.Type, e.Protocol = strings.ToUpper(e.Type), strings.ToUpper(e.Protocol)
+
+	if e.SrcAddr == "" || e.DstAddr == "" {
+		return nil, fmt.Errorf("either --src and --dst, --event or --events required")
+	}
+
+	return []*record.Event{e}, nil
+}
+
+// readEventFile reads recorded JSON records, one per line. The file "-" is
+// read from standard input.
+func readEventFile(file string) ([]*record.Event, error) {
+	var reader io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		reader = f
+	}
+
+	var events []*record.Event
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		data := strings.TrimSpace(scanner.Text())
+		if data == "" {
+			continue
+		}
+
+		var e record.Event
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+		events = append(events, &e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	return events, nil
+}
+
+func init() {
+	filterCmd.CompletionOptions.SetDefaultShellCompDirective(cobra.ShellCompDirectiveNoFileComp)
+	filterCmd.AddCommand(filterTestCmd)
+	filterCmd.AddCommand(filterLintCmd)
+
+	addFilterFlags(filterTestCmd)
+
+	filterTestCmd.Flags().String("type", "NEW", "Event type (NEW, UPDATE, DESTROY)")
+	_ = filterTestCmd.RegisterFlagCompletionFunc("type", cobra.FixedCompletions([]string{"NEW", "UPDATE", "DESTROY"}, cobra.ShellCompDirectiveNoFileComp))
+	filterTestCmd.Flags().String("proto", "TCP", "Protocol (TCP, UDP)")
+	_ = filterTestCmd.RegisterFlagCompletionFunc("proto", cobra.FixedCompletions([]string{"TCP", "UDP"}, cobra.ShellCompDirectiveNoFileComp))
+	filterTestCmd.Flags().String("src", "", "Source address")
+	filterTestCmd.Flags().String("dst", "", "Destination address")
+	filterTestCmd.Flags().Uint16("sport", 0, "Source port")
+	filterTestCmd.Flags().Uint16("dport", 0, "Destination port")
+	filterTestCmd.Flags().StringArray("event", nil, "Event as JSON record (repeatable)")
+	filterTestCmd.Flags().String("events", "", "File of recorded JSON records, one per line (- for stdin)")
+	_ = filterTestCmd.RegisterFlagCompletionFunc("events", cobra.FixedCompletions(nil, cobra.ShellCompDirectiveDefault))
+	filterTestCmd.Flags().String("geoip.database", "", "Path to GeoIP database for GeoIP variables")
+	_ = filterTestCmd.RegisterFlagCompletionFunc("geoip.database", cobra.FixedCompletions(nil, cobra.ShellCompDirectiveDefault))
+	filterTestCmd.Flags().String("geoip.asn_database", "", "Path to GeoIP ASN database for ASN variables")
+	_ = filterTestCmd.RegisterFlagCompletionFunc("geoip.asn_database", cobra.FixedCompletions(nil, cobra.ShellCompDirectiveDefault))
+
+	addFilterFlags(filterLintCmd)
+}
@@ -0,0 +1,170 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"github.com/tschaefer/conntrackd/internal/notify"
+	"github.com/tschaefer/conntrackd/internal/service"
+	"github.com/tschaefer/conntrackd/internal/sink"
+)
+
+// errShutdown is returned for reload requests after the reloader ended.
+var errShutdown = errors.New("service is shutting down")
+
+// reloader rebuilds filter, GeoIP database and sink from the configuration
+// and swaps them into the running service. Once started, its goroutine owns
+// the configuration, viper is not safe for concurrent use.
+type reloader struct {
+	service  *service.Service
+	requests chan chan error
+	done     chan struct{}
+}
+
+// newReloader creates the reloader of the service.
+func newReloader(service *service.Service) *reloader {
+	return &reloader{
+		service:  service,
+		requests: make(chan chan error),
+		done:     make(chan struct{}),
+	}
+}
+
+// run reloads on SIGHUP received on hup, on changes of the configuration
+// file reported by the watcher, if any, and on requests by reload until the
+// context is done.
+func (r *reloader) run(ctx context.Context, hup <-chan os.Signal, watcher *fsnotify.Watcher) {
+	defer close(r.done)
+
+	var changes <-chan fsnotify.Event
+	var failures <-chan error
+	if watcher != nil {
+		changes, failures = watcher.Events, watcher.Errors
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("Received SIGHUP.")
+			_ = r.apply()
+		case event := <-changes:
+			if !configChanged(event) {
+				continue
+			}
+			slog.Info("Configuration file changed.", "file", event.Name)
+			_ = r.apply()
+		case err := <-failures:
+			slog.Warn("Failed to watch configuration file.", "error", err)
+		case result := <-r.requests:
+			result <- r.apply()
+		}
+	}
+}
+
+// reload asks the running reloader to reload and returns the result.
+func (r *reloader) reload() error {
+	result := make(chan error, 1)
+	select {
+	case r.requests <- result:
+	case <-r.done:
+		return errShutdown
+	}
+
+	return <-result
+}
+
+// apply re-reads the configuration and swaps in the new filter, GeoIP
+// database and sink. On failure the current ones are kept. systemd is
+// notified about the reload.
+func (r *reloader) apply() error {
+	notify.Reloading()
+	defer notify.Ready()
+
+	slog.Info("Reloading configuration.")
+
+	err := r.build()
+	if err != nil {
+		slog.Error("Failed to reload configuration, keeping current.", "error", err)
+		return err
+	}
+
+	slog.Info("Reloaded configuration.")
+	return nil
+}
+
+// build creates the new components and swaps them into the service.
+func (r *reloader) build() error {
+	if viper.ConfigFileUsed() != "" {
+		if err := viper.ReadInConfig(); err != nil {
+			return fmt.Errorf("failed to read configuration: %w", err)
+		}
+	}
+
+	f, err := newFilter()
+	if err != nil {
+		return err
+	}
+
+	g, err := newGeoIP()
+	if err != nil {
+		return fmt.Errorf("failed to open geoip database: %w", err)
+	}
+	logFilter(f, g)
+
+	s, err := sink.NewSinkOnReload(getSinkConfig())
+	if err != nil {
+		if g != nil {
+			_ = g.Close()
+		}
+		return fmt.Errorf("failed to initialize sink: %w", err)
+	}
+
+	if old := r.service.Reload(f, g, s); old != nil {
+		_ = old.Close()
+	}
+
+	return nil
+}
+
+// watchConfig watches the directory of the configuration file, the file
+// itself is replaced by most editors. Returns nil without configuration
+// file.
+func watchConfig() (*fsnotify.Watcher, error) {
+	file := viper.ConfigFileUsed()
+	if file == "" {
+		return nil, nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+
+	return watcher, nil
+}
+
+// configChanged reports whether the event writes or creates the
+// configuration file.
+func configChanged(event fsnotify.Event) bool {
+	if filepath.Clean(event.Name) != filepath.Clean(viper.ConfigFileUsed()) {
+		return false
+	}
+
+	return event.Has(fsnotify.Write) || event.Has(fsnotify.Create)
+}
@@ -0,0 +1,73 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tschaefer/conntrackd/internal/config"
+	"github.com/tschaefer/conntrackd/internal/replay"
+	"github.com/tschaefer/conntrackd/internal/service"
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay FILE",
+	Short: "Replay recorded events through the service",
+	Long: `Replay recorded events through filter, deduplication, aggregation and sinks
+like the running service, e.g. to reproduce an incident or check a filter
+change. No privileges are required.
+
+Events are read from a capture file written by 'conntrackd capture' or from
+JSON records, one per line, as written by the stream sink. Flow summaries and gap records are
+skipped. Events are replayed at the recorded pace scaled by --speed, 0
+replays as fast as possible.
+
+Unlike 'conntrackd run' the configuration file is only read if given by
+--config, records are written to the sinks enabled by flags then.`,
+	Example: `  conntrackd replay events.cap --sink.stream.enable --sink.stream.format text
+  conntrackd replay recorded.json --speed 0 --config conntrackd.yaml`,
+	Args: cobra.ExactArgs(1),
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindFlags(cmd)
+		if cfgFile != "" {
+			if err := config.InitConfig(cfgFile); err != nil {
+				cobra.CheckErr(fmt.Sprintf("Failed to initialize configuration: %v", err))
+			}
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		svc := newService()
+		defer func() {
+			if svc.GeoIP != nil {
+				_ = svc.GeoIP.Close()
+			}
+		}()
+
+		replayConfig := replay.Config{Path: args[0], Speed: viper.GetFloat64("speed")}
+		svc.Dial = func() (service.EventSource, error) {
+			return replay.Open(replayConfig)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		if tranquil := svc.Run(ctx); !tranquil {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	replayCmd.Flags().StringVar(&cfgFile, "config", "", "config file to read the service configuration from")
+	replayCmd.Flags().Float64("speed", 1, "Replay speed relative to the recorded one, 0 replays as fast as possible")
+
+	addServiceFlags(replayCmd)
+}
@@ -25,4 +25,11 @@ func Execute() {
 func init() {
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(replayCmd)
+	rootCmd.AddCommand(captureCmd)
+	rootCmd.AddCommand(dumpCmd)
+	rootCmd.AddCommand(topCmd)
+	rootCmd.AddCommand(filterCmd)
+	rootCmd.AddCommand(ctlCmd)
+	rootCmd.AddCommand(tailCmd)
 }
@@ -7,16 +7,27 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"math"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cast"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"github.com/tschaefer/conntrackd/internal/admin"
+	"github.com/tschaefer/conntrackd/internal/aggregator"
+	"github.com/tschaefer/conntrackd/internal/communityid"
 	"github.com/tschaefer/conntrackd/internal/config"
+	"github.com/tschaefer/conntrackd/internal/dedup"
 	"github.com/tschaefer/conntrackd/internal/filter"
 	"github.com/tschaefer/conntrackd/internal/geoip"
+	"github.com/tschaefer/conntrackd/internal/listener"
 	"github.com/tschaefer/conntrackd/internal/logger"
 	"github.com/tschaefer/conntrackd/internal/profiler"
 	"github.com/tschaefer/conntrackd/internal/service"
@@ -29,11 +40,16 @@ var runCmd = &cobra.Command{
 	Use:   "run",
 	Short: "Run the conntrackd service",
 	PreRun: func(cmd *cobra.Command, args []string) {
+		bindFlags(cmd)
 		if err := config.InitConfig(cfgFile); err != nil {
 			cobra.CheckErr(fmt.Sprintf("Failed to initialize configuration: %v", err))
 		}
 	},
 	Run: func(cmd *cobra.Command, args []string) {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+
 		if viper.GetBool("profiler.enable") {
 			profiler := profiler.NewProfiler(viper.GetString("profiler.address"))
 			err := profiler.Start()
@@ -45,49 +61,244 @@ var runCmd = &cobra.Command{
 			}()
 		}
 
-		l, err := logger.NewLogger(viper.GetString("log.level"))
-		if err != nil {
-			cobra.CheckErr(fmt.Sprintf("Failed to create logger: %v", err))
+		service := newService()
+
+		workers := viper.GetInt("netlink.workers")
+		if workers < 1 || workers > math.MaxUint8 {
+			cobra.CheckErr(fmt.Sprintf("Invalid number of netlink workers: %d", workers))
+		}
+		service.Netlink = listener.Config{
+			ReadBuffer: viper.GetInt("netlink.buffer"),
+			Workers:    uint8(workers),
+			ReportLoss: viper.GetBool("netlink.report_loss"),
 		}
 
-		var g *geoip.GeoIP
-		geoipDatabase := viper.GetString("geoip.database")
-		if geoipDatabase != "" {
-			g, err = geoip.NewGeoIP(geoipDatabase)
-			if err != nil {
-				cobra.CheckErr(fmt.Sprintf("Failed to open geoip database: %v", err))
+		service.Reconnect.MaxFailures = viper.GetInt("reconnect.max_failures")
+		service.Reconnect.Backoff = viper.GetDuration("reconnect.backoff")
+		service.Reconnect.MaxBackoff = viper.GetDuration("reconnect.max_backoff")
+		service.Reconnect.Snapshot = viper.GetBool("reconnect.snapshot")
+
+		defer func() {
+			if service.GeoIP != nil {
+				_ = service.GeoIP.Close()
 			}
-			defer func() {
-				_ = g.Close()
-			}()
-		}
+		}()
 
-		var f *filter.Filter
-		filterRules := viper.GetStringSlice("filter")
-		if len(filterRules) > 0 {
-			f, err = filter.NewFilter(filterRules)
-			if err != nil {
-				cobra.CheckErr(fmt.Sprintf("failed to compile filter rules: %v", err))
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		var watcher *fsnotify.Watcher
+		if viper.GetBool("config.watch") {
+			var err error
+			if watcher, err = watchConfig(); err != nil {
+				cobra.CheckErr(fmt.Sprintf("Failed to watch configuration file: %v", err))
+			}
+			if watcher == nil {
+				slog.Warn("No configuration file to watch.")
+			} else {
+				defer func() {
+					_ = watcher.Close()
+				}()
 			}
 		}
 
-		s, err := sink.NewSink(getSinkConfig())
+		reloader := newReloader(service)
+		server, err := startAdmin(service, reloader)
 		if err != nil {
-			cobra.CheckErr(fmt.Sprintf("failed to initialize sink: %v", err))
+			cobra.CheckErr(fmt.Sprintf("Failed to open admin socket: %v", err))
 		}
+		go reloader.run(ctx, hup, watcher)
 
-		service, err := service.NewService(l, g, f, s)
-		cobra.CheckErr(err)
-
-		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-		defer stop()
-
-		if tranquil := service.Run(ctx); !tranquil {
+		tranquil := service.Run(ctx)
+		if server != nil {
+			_ = server.Close()
+		}
+		if !tranquil {
 			os.Exit(1)
 		}
 	},
 }
 
+// newService creates the service with logger, GeoIP database, filter, sink,
+// deduplication and aggregation from the configuration.
+func newService() *service.Service {
+	l, err := logger.NewLogger(viper.GetString("log.level"))
+	if err != nil {
+		cobra.CheckErr(fmt.Sprintf("Failed to create logger: %v", err))
+	}
+
+	g, err := newGeoIP()
+	if err != nil {
+		cobra.CheckErr(fmt.Sprintf("Failed to open geoip database: %v", err))
+	}
+
+	communityid.Seed = viper.GetUint16("community_id.seed")
+
+	f, err := newFilter()
+	if err != nil {
+		cobra.CheckErr(err.Error())
+	}
+
+	s, err := sink.NewSink(getSinkConfig())
+	if err != nil {
+		cobra.CheckErr(fmt.Sprintf("failed to initialize sink: %v", err))
+	}
+
+	service, err := service.NewService(l, g, f, s)
+	cobra.CheckErr(err)
+
+	logFilter(f, g)
+
+	if viper.GetBool("dedup.state_changes") || viper.GetDuration("dedup.window") > 0 {
+		service.Deduplicator = dedup.NewDeduplicator(dedup.Config{
+			StateChanges: viper.GetBool("dedup.state_changes"),
+			Window:       viper.GetDuration("dedup.window"),
+			MaxFlows:     viper.GetInt("dedup.max_flows"),
+		})
+	}
+
+	if viper.GetBool("aggregate.enable") {
+		service.Aggregator = aggregator.NewAggregator(aggregator.Config{
+			IdleTimeout:   viper.GetDuration("aggregate.idle_timeout"),
+			ActiveTimeout: viper.GetDuration("aggregate.active_timeout"),
+			MaxFlows:      viper.GetInt("aggregate.max_flows"),
+		})
+	}
+
+	return service
+}
+
+// newGeoIP opens the configured GeoIP database, if any.
+func newGeoIP() (*geoip.GeoIP, error) {
+	database := viper.GetString("geoip.database")
+	asnDatabase := viper.GetString("geoip.asn_database")
+	if database == "" {
+		if asnDatabase != "" {
+			return nil, fmt.Errorf("geoip.asn_database requires geoip.database")
+		}
+		return nil, nil
+	}
+
+	g, err := geoip.NewGeoIP(database)
+	if err != nil {
+		return nil, err
+	}
+
+	if asnDatabase != "" {
+		if err := g.OpenASN(asnDatabase); err != nil {
+			_ = g.Close()
+			return nil, err
+		}
+	}
+
+	return g, nil
+}
+
+// newFilter compiles the configured filter rules.
+func newFilter() (*filter.Filter, error) {
+	rules, err := getFilterRules()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load filter rules: %w", err)
+	}
+
+	onError, err := filter.ParseErrorPolicy(cast.ToString(getFilterValue("on_error")))
+	if err != nil {
+		return nil, err
+	}
+
+	sets, err := getSets()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sets: %w", err)
+	}
+
+	f, err := filter.NewFilterFromRules(rules, filter.Options{
+		Default: cast.ToString(getFilterValue("default")),
+		OnError: onError,
+		Sets:    sets,
+		Sinks:   getSinkConfig().Targets(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile filter rules: %w", err)
+	}
+
+	return f, nil
+}
+
+// getFilterRules returns the rules given by --filter followed by the rules of
+// the --filter.file files and directories.
+func getFilterRules() ([]filter.Rule, error) {
+	rules, err := filter.ParseRules(cast.ToStringSlice(getFilterValue("rules")))
+	if err != nil {
+		return nil, err
+	}
+
+	fileRules, err := filter.LoadRules(cast.ToStringSlice(getFilterValue("file")))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(rules, fileRules...), nil
+}
+
+// getSets returns the named sets of the configuration file, given either as
+// list of entries or as path of a set file, and of the --set flags.
+func getSets() (map[string]*filter.Set, error) {
+	sets := make(map[string]*filter.Set)
+
+	for name, value := range viper.GetStringMap("sets") {
+		var set *filter.Set
+		var err error
+		if file, ok := value.(string); ok {
+			set, err = filter.LoadSet(name, file)
+		} else {
+			set, err = filter.NewSet(name, cast.ToStringSlice(value))
+		}
+		if err != nil {
+			return nil, err
+		}
+		sets[name] = set
+	}
+
+	for _, spec := range viper.GetStringSlice("set") {
+		name, file, ok := strings.Cut(spec, "=")
+		if !ok || name == "" || file == "" {
+			return nil, fmt.Errorf("invalid set %q, expected name=file", spec)
+		}
+
+		name = strings.ToLower(name)
+		set, err := filter.LoadSet(name, file)
+		if err != nil {
+			return nil, err
+		}
+		sets[name] = set
+	}
+
+	return sets, nil
+}
+
+// getFilterValue returns the value of a filter sub key. In the configuration
+// file `filter` is either a list of rules or a map with the sub keys `rules`,
+// `file` and so on. Flags and environment variables take precedence.
+func getFilterValue(key string) any {
+	if key == "rules" {
+		if rules, ok := viper.Get("filter").(map[string]any); ok {
+			return rules["rules"]
+		}
+		return viper.Get("filter")
+	}
+
+	if viper.IsSet("filter." + key) {
+		return viper.Get("filter." + key)
+	}
+	if values, ok := viper.Get("filter").(map[string]any); ok {
+		if value, ok := values[key]; ok {
+			return value
+		}
+	}
+
+	return viper.Get("filter." + key)
+}
+
 func getSinkConfig() *sink.Config {
 	return &sink.Config{
 		Journal: sink.Journal{
@@ -96,6 +307,7 @@ func getSinkConfig() *sink.Config {
 		Syslog: sink.Syslog{
 			Enable:  viper.GetBool("sink.syslog.enable"),
 			Address: viper.GetString("sink.syslog.address"),
+			Format:  viper.GetString("sink.syslog.format"),
 		},
 		Loki: sink.Loki{
 			Enable:  viper.GetBool("sink.loki.enable"),
@@ -105,6 +317,7 @@ func getSinkConfig() *sink.Config {
 		Stream: sink.Stream{
 			Enable: viper.GetBool("sink.stream.enable"),
 			Writer: viper.GetString("sink.stream.writer"),
+			Format: viper.GetString("sink.stream.format"),
 		},
 	}
 }
@@ -113,51 +326,91 @@ func init() {
 	runCmd.CompletionOptions.SetDefaultShellCompDirective(cobra.ShellCompDirectiveNoFileComp)
 
 	runCmd.Flags().StringVar(&cfgFile, "config", "", "config file (default is /etc/conntrackd/conntrackd.{yaml,json,toml})")
+	runCmd.Flags().Bool("config.watch", false, "Reload filter, sinks and GeoIP database on configuration file changes")
 
-	runCmd.Flags().StringArray("filter", nil, "Filter rules in CEL format (repeatable, first-match wins)")
-	_ = viper.BindPFlag("filter", runCmd.Flags().Lookup("filter"))
+	runCmd.Flags().Int("netlink.buffer", 0, "Netlink socket receive buffer size in bytes (default is the system default)")
+	runCmd.Flags().Int("netlink.workers", listener.DefaultWorkers, "Number of workers decoding conntrack events")
+	runCmd.Flags().Bool("netlink.report_loss", false, "Record a GAP warning on events lost by netlink receive buffer overruns")
 
-	runCmd.Flags().String("log.level", "info", fmt.Sprintf("Log level (%s)", strings.Join(logger.Levels, ", ")))
-	_ = viper.BindPFlag("log.level", runCmd.Flags().Lookup("log.level"))
-	_ = runCmd.RegisterFlagCompletionFunc("log.level", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
-		return logger.Levels, cobra.ShellCompDirectiveNoFileComp
-	})
+	runCmd.Flags().Int("reconnect.max_failures", 5, "Give up reconnecting after this many consecutive netlink failures (1 exits on the first error)")
+	runCmd.Flags().Duration("reconnect.backoff", time.Second, "Delay before the first reconnect, doubled after each failed attempt")
+	runCmd.Flags().Duration("reconnect.max_backoff", 30*time.Second, "Maximum delay between reconnect attempts")
+	runCmd.Flags().Bool("reconnect.snapshot", false, "Process the conntrack table as UPDATE events after reconnecting")
+
+	runCmd.Flags().Bool("admin.enable", false, "Serve the admin API for conntrackd ctl on a Unix socket")
+	runCmd.Flags().String("admin.socket", admin.DefaultSocket, "Path to the admin socket, only accessible to the service user")
+	_ = runCmd.RegisterFlagCompletionFunc("admin.socket", cobra.FixedCompletions(nil, cobra.ShellCompDirectiveDefault))
 
-	runCmd.Flags().String("geoip.database", "", "Path to GeoIP database")
-	_ = viper.BindPFlag("geoip.database", runCmd.Flags().Lookup("geoip.database"))
-	_ = runCmd.RegisterFlagCompletionFunc("geoip.database", cobra.FixedCompletions(nil, cobra.ShellCompDirectiveDefault))
+	runCmd.Flags().Bool("profiler.enable", false, "Enable profiler")
+	runCmd.Flags().String("profiler.address", "http://localhost:4040", "Profiler server address")
 
-	runCmd.Flags().Bool("sink.journal.enable", false, "Enable journald sink")
-	_ = viper.BindPFlag("sink.journal.enable", runCmd.Flags().Lookup("sink.journal.enable"))
+	addServiceFlags(runCmd)
+}
 
-	runCmd.Flags().Bool("sink.syslog.enable", false, "Enable syslog sink")
-	_ = viper.BindPFlag("sink.syslog.enable", runCmd.Flags().Lookup("sink.syslog.enable"))
+// addServiceFlags adds the flags configuring filter, GeoIP, record fields,
+// deduplication, aggregation and sinks of the service.
+func addServiceFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArray("filter", nil, "Filter rules in CEL format (repeatable, first-match wins)")
+	cmd.Flags().String("filter.default", "log", fmt.Sprintf("Action for events matching no rule (%s)", strings.Join(filter.DefaultActions, ", ")))
+	_ = cmd.RegisterFlagCompletionFunc("filter.default", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return filter.DefaultActions, cobra.ShellCompDirectiveNoFileComp
+	})
+	cmd.Flags().String("filter.on_error", "skip", fmt.Sprintf("Handling of rule evaluation errors (%s)", strings.Join(filter.ErrorPolicies, ", ")))
+	_ = cmd.RegisterFlagCompletionFunc("filter.on_error", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return filter.ErrorPolicies, cobra.ShellCompDirectiveNoFileComp
+	})
+	cmd.Flags().StringArray("filter.file", nil, "Filter rule files or directories (repeatable)")
+	_ = cmd.RegisterFlagCompletionFunc("filter.file", cobra.FixedCompletions(nil, cobra.ShellCompDirectiveDefault))
+	cmd.Flags().StringArray("set", nil, "Named set for in_set loaded from a file, as name=file (repeatable)")
 
-	runCmd.Flags().String("sink.syslog.address", "udp://localhost:514", "Syslog address")
-	_ = viper.BindPFlag("sink.syslog.address", runCmd.Flags().Lookup("sink.syslog.address"))
+	cmd.Flags().String("log.level", "info", fmt.Sprintf("Log level (%s)", strings.Join(logger.Levels, ", ")))
+	_ = cmd.RegisterFlagCompletionFunc("log.level", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return logger.Levels, cobra.ShellCompDirectiveNoFileComp
+	})
 
-	runCmd.Flags().Bool("sink.loki.enable", false, "Enable Loki sink")
-	_ = viper.BindPFlag("sink.loki.enable", runCmd.Flags().Lookup("sink.loki.enable"))
+	cmd.Flags().String("geoip.database", "", "Path to GeoIP database")
+	_ = cmd.RegisterFlagCompletionFunc("geoip.database", cobra.FixedCompletions(nil, cobra.ShellCompDirectiveDefault))
+	cmd.Flags().String("geoip.asn_database", "", "Path to GeoIP ASN database")
+	_ = cmd.RegisterFlagCompletionFunc("geoip.asn_database", cobra.FixedCompletions(nil, cobra.ShellCompDirectiveDefault))
 
-	runCmd.Flags().String("sink.loki.address", "http://localhost:3100", "Loki address")
-	_ = viper.BindPFlag("sink.loki.address", runCmd.Flags().Lookup("sink.loki.address"))
+	cmd.Flags().Uint16("community_id.seed", 0, "Seed for the Community ID flow hash")
 
-	runCmd.Flags().StringSlice("sink.loki.labels", nil, "Additional labels for Loki sink in key=value format")
-	_ = viper.BindPFlag("sink.loki.labels", runCmd.Flags().Lookup("sink.loki.labels"))
+	cmd.Flags().Bool("aggregate.enable", false, "Enable flow aggregation, record one summary per connection")
+	cmd.Flags().Duration("aggregate.idle_timeout", 5*time.Minute, "Summarize flows without events for this duration")
+	cmd.Flags().Duration("aggregate.active_timeout", time.Hour, "Summarize flows active for this duration")
+	cmd.Flags().Int("aggregate.max_flows", 65536, "Maximum number of aggregated flows, least recently seen are summarized first")
 
-	runCmd.Flags().Bool("sink.stream.enable", false, "Enable stream sink")
-	_ = viper.BindPFlag("sink.stream.enable", runCmd.Flags().Lookup("sink.stream.enable"))
+	cmd.Flags().Bool("dedup.state_changes", false, "Record UPDATE events only on TCP state, status, mark or NAT changes")
+	cmd.Flags().Duration("dedup.window", 0, "Coalesce UPDATE events of a flow within this duration")
+	cmd.Flags().Int("dedup.max_flows", 65536, "Maximum number of flows tracked for deduplication")
 
-	runCmd.Flags().String("sink.stream.writer", "stdout", fmt.Sprintf("Stream writer (%s)", strings.Join(sink.StreamWriters, ", ")))
-	_ = viper.BindPFlag("sink.stream.writer", runCmd.Flags().Lookup("sink.stream.writer"))
-	_ = runCmd.RegisterFlagCompletionFunc("sink.stream.writer", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	cmd.Flags().Bool("sink.journal.enable", false, "Enable journald sink")
+	cmd.Flags().Bool("sink.syslog.enable", false, "Enable syslog sink")
+	cmd.Flags().String("sink.syslog.address", "udp://localhost:514", "Syslog address")
+	cmd.Flags().String("sink.syslog.format", "json", fmt.Sprintf("Syslog format (%s)", strings.Join(sink.Formats, ", ")))
+	_ = cmd.RegisterFlagCompletionFunc("sink.syslog.format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return sink.Formats, cobra.ShellCompDirectiveNoFileComp
+	})
+	cmd.Flags().Bool("sink.loki.enable", false, "Enable Loki sink")
+	cmd.Flags().String("sink.loki.address", "http://localhost:3100", "Loki address")
+	cmd.Flags().StringSlice("sink.loki.labels", nil, "Additional labels for Loki sink in key=value format")
+	cmd.Flags().Bool("sink.stream.enable", false, "Enable stream sink")
+	cmd.Flags().String("sink.stream.writer", "stdout", fmt.Sprintf("Stream writer (%s)", strings.Join(sink.StreamWriters, ", ")))
+	_ = cmd.RegisterFlagCompletionFunc("sink.stream.writer", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 		return sink.StreamWriters, cobra.ShellCompDirectiveNoFileComp
 	})
+	cmd.Flags().String("sink.stream.format", "json", fmt.Sprintf("Stream format (%s)", strings.Join(sink.Formats, ", ")))
+	_ = cmd.RegisterFlagCompletionFunc("sink.stream.format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return sink.Formats, cobra.ShellCompDirectiveNoFileComp
+	})
+}
 
-	runCmd.Flags().Bool("profiler.enable", false, "Enable profiler")
-	_ = viper.BindPFlag("profiler.enable", runCmd.Flags().Lookup("profiler.enable"))
-
-	runCmd.Flags().String("profiler.address", "http://localhost:4040", "Profiler server address")
-	_ = viper.BindPFlag("profiler.address", runCmd.Flags().Lookup("profiler.address"))
-
+// bindFlags binds all flags of the command but --config to their
+// configuration keys.
+func bindFlags(cmd *cobra.Command) {
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if flag.Name != "config" {
+			_ = viper.BindPFlag(flag.Name, flag)
+		}
+	})
 }
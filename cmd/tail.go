@@ -0,0 +1,69 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/tschaefer/conntrackd/internal/admin"
+	"github.com/tschaefer/conntrackd/internal/tail"
+)
+
+var tailCmd = &cobra.Command{
+	Use:   "tail",
+	Short: "Stream live records of the running service",
+	Long: `Stream the records of the running service as they are written to the sinks,
+enriched and tagged, through its admin API. The service must run with
+--admin.enable. Nothing needs to be restarted.
+
+--filter takes a CEL expression like the filter rules, it is evaluated by the
+service and only records of matching events are streamed. With --dropped
+events dropped by the filter of the service are streamed as well, with the
+dropping rule in dropped_by, filter.default if dropped by the default action.
+While streaming dropped events the kernel pre-filter of the service accepts
+all TCP and UDP events, which raises its load on busy hosts.
+
+A subscriber falling behind is disconnected instead of slowing down the
+service, narrow down the stream by --filter then.`,
+	Example: `  conntrackd tail
+  conntrackd tail --filter 'destination.port == 22' --dropped --output text`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		expr, _ := cmd.Flags().GetString("filter")
+		dropped, _ := cmd.Flags().GetBool("dropped")
+		output, _ := cmd.Flags().GetString("output")
+		if !slices.Contains(tail.Formats, output) {
+			cobra.CheckErr(fmt.Sprintf("Invalid output format %q, expected one of %s", output, strings.Join(tail.Formats, ", ")))
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		out := cmd.OutOrStdout()
+		err := newAdminClient(cmd).Tail(ctx, expr, dropped, func(r *tail.Record) error {
+			return tail.Write(out, output, r)
+		})
+		if errors.Is(err, tail.ErrSlowSubscriber) {
+			cobra.CheckErr(fmt.Sprintf("%v, narrow down the stream by --filter", err))
+		}
+		cobra.CheckErr(err)
+	},
+}
+
+func init() {
+	tailCmd.Flags().String("socket", admin.DefaultSocket, "Path to the admin socket of the service")
+	_ = tailCmd.RegisterFlagCompletionFunc("socket", cobra.FixedCompletions(nil, cobra.ShellCompDirectiveDefault))
+	tailCmd.Flags().String("filter", "", "CEL expression, only records of matching events are streamed")
+	tailCmd.Flags().Bool("dropped", false, "Stream events dropped by the filter of the service with the dropping rule")
+	tailCmd.Flags().StringP("output", "o", "json", fmt.Sprintf("Output format (%s)", strings.Join(tail.Formats, ", ")))
+	_ = tailCmd.RegisterFlagCompletionFunc("output", cobra.FixedCompletions(tail.Formats, cobra.ShellCompDirectiveNoFileComp))
+}
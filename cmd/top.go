@@ -0,0 +1,209 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/ti-mo/conntrack"
+	"github.com/tschaefer/conntrackd/internal/listener"
+	"github.com/tschaefer/conntrackd/internal/top"
+	"golang.org/x/term"
+)
+
+var topCmd = &cobra.Command{
+	Use:   "top",
+	Short: "Show the busiest sources, destinations, ports and countries",
+	Long: `Show a live view of the busiest sources, destinations, destination ports
+and destination countries, refreshed every --interval. No sinks are needed.
+
+New connections per second are counted from the conntrack events of all
+network namespaces. Active flows, packets and bytes are taken from a dump of
+the conntrack table of the current network namespace every --dump_interval.
+Counters are zero unless conntrack accounting is enabled. Countries require
+a GeoIP database.
+
+Rules given by --filter, --filter.file and the configuration file given by
+--config decide the counted events and flows. Type / to enter an additional
+CEL expression, only events and flows matching it are counted.
+
+Keys: 1-4 or tab select the view, left and right arrow or < and > the sort
+column, / edits the filter (enter applies, escape cancels), q quits.`,
+	Example: `  conntrackd top
+  conntrackd top --geoip.database /usr/share/GeoIP/GeoLite2-City.mmdb --interval 2s`,
+	Args: cobra.NoArgs,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindFilterFlags(cmd)
+		for _, key := range []string{"geoip.database", "geoip.asn_database"} {
+			_ = viper.BindPFlag(key, cmd.Flags().Lookup(key))
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		interval, _ := cmd.Flags().GetDuration("interval")
+		dumpInterval, _ := cmd.Flags().GetDuration("dump_interval")
+		if interval <= 0 {
+			cobra.CheckErr(fmt.Sprintf("Invalid refresh interval: %s", interval))
+		}
+
+		stdin, stdout := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+		if !term.IsTerminal(stdin) || !term.IsTerminal(stdout) {
+			cobra.CheckErr("top requires a terminal")
+		}
+
+		f, err := newFilter()
+		if err != nil {
+			cobra.CheckErr(err.Error())
+		}
+		sets, err := getSets()
+		if err != nil {
+			cobra.CheckErr(fmt.Sprintf("failed to load sets: %v", err))
+		}
+		g, err := newGeoIP()
+		if err != nil {
+			cobra.CheckErr(fmt.Sprintf("failed to open geoip database: %v", err))
+		}
+		if g != nil {
+			defer func() {
+				_ = g.Close()
+			}()
+		}
+
+		l, err := listener.Dial(listener.Config{})
+		if err != nil {
+			cobra.CheckErr(fmt.Sprintf("Failed to open netlink socket: %v", err))
+		}
+		if _, err := l.Apply(f.Prefilter()); err != nil {
+			_ = l.Close()
+			cobra.CheckErr(fmt.Sprintf("Failed to subscribe to conntrack events: %v", err))
+		}
+
+		t := top.New(top.Config{Filter: f, GeoIP: g, Sets: sets})
+
+		state, err := term.MakeRaw(stdin)
+		if err != nil {
+			_ = l.Close()
+			cobra.CheckErr(fmt.Sprintf("Failed to set up terminal: %v", err))
+		}
+		// Log records would break the screen.
+		slog.SetDefault(slog.New(slog.DiscardHandler))
+		_, _ = fmt.Fprint(os.Stdout, "\x1b[?1049h\x1b[?25l")
+
+		evCh := make(chan conntrack.Event, 1024)
+		errCh := l.Listen(evCh)
+		go func() {
+			for event := range evCh {
+				t.Event(event)
+			}
+		}()
+
+		done := make(chan struct{})
+		if dumpInterval > 0 {
+			go dumpTable(t, dumpInterval, done)
+		}
+
+		runTop(t, interval, stdout, errCh)
+
+		close(done)
+		_ = l.Close()
+		close(evCh)
+
+		_, _ = fmt.Fprint(os.Stdout, "\x1b[?25h\x1b[?1049l")
+		_ = term.Restore(stdin, state)
+	},
+}
+
+// runTop refreshes the view until quit by key or signal.
+func runTop(t *top.Top, interval time.Duration, stdout int, errCh chan error) {
+	keyCh := make(chan []byte)
+	go readKeys(os.Stdin, keyCh)
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGWINCH)
+	defer signal.Stop(sigCh)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	draw := func() {
+		width, height, err := term.GetSize(stdout)
+		if err != nil {
+			width, height = 80, 24
+		}
+		lines := t.Render(width, height, time.Now())
+		_, _ = fmt.Fprint(os.Stdout, "\x1b[H"+strings.Join(lines, "\x1b[K\r\n")+"\x1b[K\x1b[J")
+	}
+
+	draw()
+	for {
+		select {
+		case data := <-keyCh:
+			if !t.Input(data) {
+				return
+			}
+		case now := <-ticker.C:
+			t.Tick(now)
+		case sig := <-sigCh:
+			if sig != syscall.SIGWINCH {
+				return
+			}
+		case err := <-errCh:
+			t.SetStatus(fmt.Sprintf("Conntrack listener failed: %v", err))
+		}
+		draw()
+	}
+}
+
+// readKeys sends the keys read from the terminal.
+func readKeys(r io.Reader, keyCh chan<- []byte) {
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		keyCh <- append([]byte(nil), buf[:n]...)
+	}
+}
+
+// dumpTable counts the flows of the conntrack table every interval until
+// done.
+func dumpTable(t *top.Top, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if flows, err := listener.Snapshot(); err != nil {
+			t.SetStatus(fmt.Sprintf("Failed to dump conntrack table: %v", err))
+		} else {
+			t.Snapshot(flows)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func init() {
+	addFilterFlags(topCmd)
+
+	topCmd.Flags().Duration("interval", time.Second, "Refresh interval of the view and the rates")
+	topCmd.Flags().Duration("dump_interval", 5*time.Second, "Interval of conntrack table dumps for active flows and counters, 0 disables them")
+	topCmd.Flags().String("geoip.database", "", "Path to GeoIP database for countries and GeoIP variables")
+	_ = topCmd.RegisterFlagCompletionFunc("geoip.database", cobra.FixedCompletions(nil, cobra.ShellCompDirectiveDefault))
+	topCmd.Flags().String("geoip.asn_database", "", "Path to GeoIP ASN database for ASN variables")
+	_ = topCmd.RegisterFlagCompletionFunc("geoip.asn_database", cobra.FixedCompletions(nil, cobra.ShellCompDirectiveDefault))
+}
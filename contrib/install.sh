@@ -213,8 +213,13 @@ Description=Conntrack event logger with GEO location
 After=network.target
 
 [Service]
-Type=simple
+Type=notify
+NotifyAccess=main
 ExecStart=/usr/local/bin/conntrackd run
+ExecReload=/bin/kill -HUP $MAINPID
+WatchdogSec=30s
+RuntimeDirectory=conntrackd
+RuntimeDirectoryMode=0750
 Restart=on-failure
 RestartSec=5s
 
@@ -0,0 +1,276 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package admin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ti-mo/conntrack"
+	"github.com/tschaefer/conntrackd/internal/record"
+	"github.com/tschaefer/conntrackd/internal/service"
+	"github.com/tschaefer/conntrackd/internal/sink"
+	"github.com/tschaefer/conntrackd/internal/tail"
+)
+
+// fakeDaemon records the calls of the admin API.
+type fakeDaemon struct {
+	level   string
+	reloads int
+	sinks   map[string]bool
+	fail    error
+	hub     tail.Hub
+}
+
+func (d *fakeDaemon) Status() service.Status {
+	status := service.Status{
+		Release:  "1.0.0",
+		LogLevel: "INFO",
+		Queue:    service.QueueStatus{Length: 3, Capacity: 1024},
+		Filter: service.FilterStatus{
+			Default: "log",
+			OnError: "skip",
+			Rules:   []service.RuleStatus{{Name: "ssh", Source: "flag", Action: "drop", Expr: "destination.port == 22", Matches: 7}},
+		},
+	}
+	for _, name := range []string{"journal", "stream"} {
+		status.Sinks = append(status.Sinks, sink.Target{Name: name, Enabled: d.sinks[name]})
+	}
+
+	return status
+}
+
+func (d *fakeDaemon) SetLogLevel(level string) error {
+	if level != "debug" {
+		return fmt.Errorf("unknown log level: %q", level)
+	}
+	d.level = level
+	return nil
+}
+
+func (d *fakeDaemon) Reload() error {
+	d.reloads++
+	return d.fail
+}
+
+func (d *fakeDaemon) SetSink(name string, enabled bool) error {
+	if _, ok := d.sinks[name]; !ok {
+		return fmt.Errorf("unknown sink %q", name)
+	}
+	d.sinks[name] = enabled
+	return nil
+}
+
+func (d *fakeDaemon) Subscribe(expr string, dropped bool) (*tail.Subscription, error) {
+	return d.hub.Subscribe(expr, dropped, nil)
+}
+
+func (d *fakeDaemon) Unsubscribe(sub *tail.Subscription) {
+	d.hub.Unsubscribe(sub)
+}
+
+func __publish(d *fakeDaemon, dport uint16, droppedBy string) {
+	flow := conntrack.NewFlow(syscall.IPPROTO_TCP, 0, netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2"), 4711, dport, 60, 0)
+	event := conntrack.Event{Type: conntrack.EventNew, Flow: &flow}
+	d.hub.Publish(event, nil, func() *record.Event { return record.NewEvent(event, nil) }, droppedBy)
+}
+
+func __startServer(t *testing.T) (*fakeDaemon, *Client, string) {
+	daemon := &fakeDaemon{sinks: map[string]bool{"journal": true, "stream": true}}
+	path := filepath.Join(t.TempDir(), "run", "admin.sock")
+
+	server, err := Listen(path, daemon)
+	require.NoError(t, err)
+	go func() {
+		_ = server.Serve()
+	}()
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+
+	return daemon, NewClient(path), path
+}
+
+func listenRestrictsSocket(t *testing.T) {
+	_, _, path := __startServer(t)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSocket|0o600, info.Mode())
+
+	info, err = os.Stat(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o750), info.Mode().Perm())
+
+	_, err = Listen(path, &fakeDaemon{})
+	assert.EqualError(t, err, fmt.Sprintf("socket %s in use", path))
+}
+
+func listenIgnoresPermissiveUmask(t *testing.T) {
+	umask := syscall.Umask(0)
+	defer syscall.Umask(umask)
+
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	server, err := Listen(path, &fakeDaemon{})
+	require.NoError(t, err)
+	defer func() {
+		_ = server.listener.Close()
+	}()
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSocket|0o600, info.Mode())
+	assert.Equal(t, 0, syscall.Umask(0), "umask unchanged")
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	if assert.Len(t, entries, 1, "private directory removed") {
+		assert.Equal(t, "admin.sock", entries[0].Name())
+	}
+}
+
+func listenRemovesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	listener, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, listener.Close())
+
+	server, err := Listen(path, &fakeDaemon{})
+	require.NoError(t, err)
+	require.NoError(t, server.Close())
+	assert.NoFileExists(t, path)
+
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+	_, err = Listen(path, &fakeDaemon{})
+	assert.EqualError(t, err, fmt.Sprintf("%s exists and is not a socket", path))
+}
+
+func clientCallsDaemon(t *testing.T) {
+	daemon, client, _ := __startServer(t)
+
+	status, err := client.Status()
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0", status.Release)
+	assert.Equal(t, uint64(7), status.Filter.Rules[0].Matches)
+	assert.Equal(t, 3, status.Queue.Length)
+
+	assert.NoError(t, client.SetLogLevel("debug"))
+	assert.Equal(t, "debug", daemon.level)
+	assert.EqualError(t, client.SetLogLevel("verbose"), `unknown log level: "verbose"`)
+
+	assert.NoError(t, client.SetSink("stream", false))
+	assert.False(t, daemon.sinks["stream"])
+	assert.EqualError(t, client.SetSink("loki", true), `unknown sink "loki"`)
+
+	assert.NoError(t, client.Reload())
+	daemon.fail = errors.New("failed to compile filter rules")
+	assert.EqualError(t, client.Reload(), "failed to compile filter rules")
+	assert.Equal(t, 2, daemon.reloads)
+}
+
+func clientFailsWithoutServer(t *testing.T) {
+	client := NewClient(filepath.Join(t.TempDir(), "admin.sock"))
+
+	_, err := client.Status()
+	assert.ErrorContains(t, err, "failed to connect to admin socket")
+}
+
+func clientTailsRecords(t *testing.T) {
+	daemon, client, _ := __startServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	records := make(chan *tail.Record)
+	done := make(chan error)
+	go func() {
+		done <- client.Tail(ctx, "destination.port != 22", true, func(r *tail.Record) error {
+			records <- r
+			return nil
+		})
+	}()
+	require.Eventually(t, daemon.hub.Active, time.Second, 10*time.Millisecond)
+
+	__publish(daemon, 22, "")
+	__publish(daemon, 443, "")
+	__publish(daemon, 80, "http")
+
+	r := <-records
+	assert.Equal(t, uint16(443), r.DstPort)
+	assert.Empty(t, r.DroppedBy)
+	r = <-records
+	assert.Equal(t, uint16(80), r.DstPort)
+	assert.Equal(t, "http", r.DroppedBy)
+
+	cancel()
+	assert.NoError(t, <-done)
+	assert.Eventually(t, func() bool { return !daemon.hub.Active() }, time.Second, 10*time.Millisecond)
+
+	err := client.Tail(context.Background(), "destination.port ==", false, nil)
+	assert.ErrorContains(t, err, "invalid filter:")
+}
+
+func clientTailFailsIfTooSlow(t *testing.T) {
+	daemon, client, _ := __startServer(t)
+
+	blocked := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- client.Tail(context.Background(), "", false, func(r *tail.Record) error {
+			<-blocked
+			return nil
+		})
+	}()
+	require.Eventually(t, daemon.hub.Active, time.Second, 10*time.Millisecond)
+
+	for i := 0; daemon.hub.Active() && i < 1_000_000; i++ {
+		__publish(daemon, 443, "")
+	}
+	close(blocked)
+
+	assert.ErrorIs(t, <-done, tail.ErrSlowSubscriber)
+}
+
+func writeStatusPrintsRules(t *testing.T) {
+	daemon := &fakeDaemon{sinks: map[string]bool{"journal": true}}
+	status := daemon.Status()
+	status.Started = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	status.Uptime = 3725.5
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteStatus(&buf, &status))
+	lines := strings.Split(buf.String(), "\n")
+
+	assert.Equal(t, "Started:    2026-01-02T03:04:05Z (up 1h2m5s)", lines[1])
+	assert.Equal(t, "Log level:  info", lines[2])
+	assert.Equal(t, "Sinks:      journal (enabled), stream (disabled)", lines[3])
+	assert.Equal(t, "Queue:      3/1024 events, 0 processing", lines[4])
+	assert.Equal(t, "GeoIP:      none", lines[5])
+	assert.Equal(t, []string{"ssh", "flag", "7", "0", "drop", "destination.port", "==", "22"}, strings.Fields(lines[9]))
+}
+
+func TestAdmin(t *testing.T) {
+	t.Run("admin.Listen restricts socket", listenRestrictsSocket)
+	t.Run("admin.Listen ignores permissive umask", listenIgnoresPermissiveUmask)
+	t.Run("admin.Listen removes stale socket", listenRemovesStaleSocket)
+	t.Run("admin.Client calls daemon", clientCallsDaemon)
+	t.Run("admin.Client fails without server", clientFailsWithoutServer)
+	t.Run("admin.Client tails records", clientTailsRecords)
+	t.Run("admin.Client tail fails if too slow", clientTailFailsIfTooSlow)
+	t.Run("admin.WriteStatus prints rules", writeStatusPrintsRules)
+}
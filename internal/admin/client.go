@@ -0,0 +1,173 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/tschaefer/conntrackd/internal/service"
+	"github.com/tschaefer/conntrackd/internal/tail"
+)
+
+// clientTimeout limits a request including a reload of the configuration,
+// but not the stream of records.
+const clientTimeout = 30 * time.Second
+
+// Client calls the admin API of a running service.
+type Client struct {
+	http *http.Client
+}
+
+// NewClient returns a client connecting to the admin socket at path.
+func NewClient(path string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+
+	return &Client{http: &http.Client{Transport: transport}}
+}
+
+// Status returns a snapshot of the service.
+func (c *Client) Status() (*service.Status, error) {
+	var status service.Status
+	if err := c.do(http.MethodGet, "/v1/status", nil, &status); err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// SetLogLevel changes the log level of the service.
+func (c *Client) SetLogLevel(level string) error {
+	return c.do(http.MethodPut, "/v1/log/level", LogLevel{Level: level}, nil)
+}
+
+// Reload makes the service re-read its configuration.
+func (c *Client) Reload() error {
+	return c.do(http.MethodPost, "/v1/reload", nil, nil)
+}
+
+// SetSink enables or disables the named sink target of the service.
+func (c *Client) SetSink(name string, enabled bool) error {
+	return c.do(http.MethodPut, "/v1/sinks/"+url.PathEscape(name), SinkState{Enabled: enabled}, nil)
+}
+
+// Tail streams the records of events matching the CEL expression, all if
+// empty, to fn until the context is done, fn fails or the service ends the
+// subscription. Events dropped by the filter are included if dropped is set.
+func (c *Client) Tail(ctx context.Context, expr string, dropped bool, fn func(*tail.Record) error) error {
+	query := url.Values{"filter": {expr}, "dropped": {strconv.FormatBool(dropped)}}
+	response, err := c.send(ctx, http.MethodGet, "/v1/tail?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var line struct {
+			*tail.Record
+			Error string `json:"error"`
+		}
+		if err := decoder.Decode(&line); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, io.EOF) {
+				return errors.New("subscription ended by the service")
+			}
+			return err
+		}
+
+		if line.Error == tail.ErrSlowSubscriber.Error() {
+			return tail.ErrSlowSubscriber
+		}
+		if line.Error != "" {
+			return errors.New(line.Error)
+		}
+		if err := fn(line.Record); err != nil {
+			return err
+		}
+	}
+}
+
+// do sends the request with the JSON encoded body, if any, and decodes the
+// response into result, if any.
+func (c *Client) do(method, path string, body, result any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), clientTimeout)
+	defer cancel()
+
+	response, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// send sends the request with the JSON encoded body, if any. API errors are
+// returned with their message.
+func (c *Client) send(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, "http://conntrackd"+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("failed to connect to admin socket: %w", err)
+	}
+
+	if response.StatusCode >= http.StatusBadRequest {
+		defer func() {
+			_ = response.Body.Close()
+		}()
+
+		var apiErr apiError
+		if err := json.NewDecoder(response.Body).Decode(&apiErr); err != nil || apiErr.Error == "" {
+			return nil, fmt.Errorf("admin request failed: %s", response.Status)
+		}
+		return nil, errors.New(apiErr.Error)
+	}
+
+	return response, nil
+}
@@ -0,0 +1,67 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package admin
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tschaefer/conntrackd/internal/service"
+)
+
+// WriteStatus writes the status as text: service, sinks, queue, GeoIP
+// databases and a table of the rules with their hit counts.
+func WriteStatus(w io.Writer, status *service.Status) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	sinks := make([]string, 0, len(status.Sinks))
+	for _, sink := range status.Sinks {
+		state := "enabled"
+		if !sink.Enabled {
+			state = "disabled"
+		}
+		sinks = append(sinks, fmt.Sprintf("%s (%s)", sink.Name, state))
+	}
+
+	uptime := time.Duration(status.Uptime * float64(time.Second)).Truncate(time.Second)
+	release := status.Release
+	if status.Commit != "" {
+		release += " (" + status.Commit + ")"
+	}
+	_, _ = fmt.Fprintf(tw, "Release:\t%s\n", release)
+	_, _ = fmt.Fprintf(tw, "Started:\t%s (up %s)\n", status.Started.Format(time.RFC3339), uptime)
+	_, _ = fmt.Fprintf(tw, "Log level:\t%s\n", strings.ToLower(status.LogLevel))
+	_, _ = fmt.Fprintf(tw, "Sinks:\t%s\n", strings.Join(sinks, ", "))
+	_, _ = fmt.Fprintf(tw, "Queue:\t%d/%d events, %d processing\n",
+		status.Queue.Length, status.Queue.Capacity, status.Queue.Processing)
+	if len(status.GeoIP) == 0 {
+		_, _ = fmt.Fprintf(tw, "GeoIP:\tnone\n")
+	}
+	for _, database := range status.GeoIP {
+		_, _ = fmt.Fprintf(tw, "GeoIP:\t%s (%s, built %s)\n",
+			database.Path, database.Type, database.Built.Format(time.DateOnly))
+	}
+	_, _ = fmt.Fprintf(tw, "Filter:\tdefault %s, on error %s\n", status.Filter.Default, status.Filter.OnError)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(status.Filter.Rules) == 0 {
+		return nil
+	}
+
+	_, _ = fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "RULE\tSOURCE\tMATCHES\tERRORS\tRULE TEXT")
+	for _, rule := range status.Filter.Rules {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s %s\n",
+			rule.Name, rule.Source, rule.Matches, rule.Errors, rule.Action, rule.Expr)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,266 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/tschaefer/conntrackd/internal/service"
+	"github.com/tschaefer/conntrackd/internal/tail"
+)
+
+// DefaultSocket is the default path of the admin socket.
+const DefaultSocket = "/run/conntrackd/admin.sock"
+
+// Daemon is the running service controlled by the admin API.
+type Daemon interface {
+	// Status returns a snapshot of the service.
+	Status() service.Status
+	// SetLogLevel changes the log level.
+	SetLogLevel(level string) error
+	// Reload re-reads the configuration.
+	Reload() error
+	// SetSink enables or disables the named sink target.
+	SetSink(name string, enabled bool) error
+	// Subscribe subscribes to the records of events matching the CEL
+	// expression, including events dropped by the filter if dropped is set.
+	Subscribe(expr string, dropped bool) (*tail.Subscription, error)
+	// Unsubscribe ends the subscription.
+	Unsubscribe(sub *tail.Subscription)
+}
+
+// LogLevel is the request body changing the log level.
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+// SinkState is the request body enabling or disabling a sink target.
+type SinkState struct {
+	Enabled bool `json:"enabled"`
+}
+
+// apiError is the response body of failed requests.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// Server serves the admin API over HTTP on a Unix socket. Access is limited
+// by the permissions of the socket, only the owner may connect.
+type Server struct {
+	path     string
+	daemon   Daemon
+	listener net.Listener
+	server   *http.Server
+}
+
+// Listen creates the admin socket at path with mode 0600, the directory is
+// created with mode 0750 if missing. The socket is created in a private
+// directory and moved to path once restricted, it is never accessible by
+// others. A stale socket of a previous run is removed, a socket in use is an
+// error.
+func Listen(path string, daemon Daemon) (*Server, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, err
+	}
+	if err := removeStale(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := listenPrivate(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{path: path, daemon: daemon, listener: listener}
+	s.server = &http.Server{Handler: s.routes(), ReadHeaderTimeout: 10 * time.Second}
+
+	return s, nil
+}
+
+// listenPrivate creates the socket in a private directory next to path,
+// restricts it to mode 0600 and moves it to path.
+func listenPrivate(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".admin-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	private := filepath.Join(dir, filepath.Base(path))
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: private, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	listener.SetUnlinkOnClose(false)
+
+	if err := os.Chmod(private, 0o600); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	if err := os.Rename(private, path); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// removeStale removes the socket at path unless a server accepts
+// connections on it.
+func removeStale(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("socket %s in use", path)
+	}
+
+	return os.Remove(path)
+}
+
+// Serve handles requests until the server is closed.
+func (s *Server) Serve() error {
+	err := s.server.Serve(s.listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Close stops the server and removes the socket.
+func (s *Server) Close() error {
+	err := s.server.Close()
+	_ = os.Remove(s.path)
+	return err
+}
+
+// routes returns the handler of the API endpoints.
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/status", s.status)
+	mux.HandleFunc("PUT /v1/log/level", s.logLevel)
+	mux.HandleFunc("POST /v1/reload", s.reload)
+	mux.HandleFunc("PUT /v1/sinks/{name}", s.sink)
+	mux.HandleFunc("GET /v1/tail", s.tail)
+
+	return mux
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.daemon.Status())
+}
+
+func (s *Server) logLevel(w http.ResponseWriter, r *http.Request) {
+	var body LogLevel
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+
+	if err := s.daemon.SetLogLevel(body.Level); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	slog.Info("Changed log level.", "level", body.Level)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) reload(w http.ResponseWriter, r *http.Request) {
+	if err := s.daemon.Reload(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) sink(w http.ResponseWriter, r *http.Request) {
+	var body SinkState
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+
+	name := r.PathValue("name")
+	if err := s.daemon.SetSink(name, body.Enabled); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	slog.Info("Changed sink state.", "sink", name, "enabled", body.Enabled)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tail streams the records of the subscription as JSON lines until the
+// client disconnects. A subscription ended by the service is followed by an
+// error line.
+func (s *Server) tail(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	dropped, _ := strconv.ParseBool(query.Get("dropped"))
+
+	sub, err := s.daemon.Subscribe(query.Get("filter"), dropped)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid filter: %w", err))
+		return
+	}
+	defer s.daemon.Unsubscribe(sub)
+	slog.Info("Tail subscriber connected.", "filter", query.Get("filter"), "dropped", dropped)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	controller := http.NewResponseController(w)
+	_ = controller.Flush()
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			slog.Info("Tail subscriber disconnected.")
+			return
+		case record, ok := <-sub.Records():
+			if !ok {
+				if err := sub.Err(); err != nil {
+					slog.Warn("Tail subscriber disconnected.", "error", err)
+					_ = encoder.Encode(apiError{Error: err.Error()})
+				}
+				return
+			}
+			if encoder.Encode(record) != nil || controller.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, apiError{Error: err.Error()})
+}
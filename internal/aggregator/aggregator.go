@@ -0,0 +1,181 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package aggregator
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/ti-mo/conntrack"
+	"github.com/tschaefer/conntrackd/internal/geoip"
+	"github.com/tschaefer/conntrackd/internal/record"
+)
+
+// Summary reasons.
+const (
+	ReasonDestroy       = "destroy"
+	ReasonIdleTimeout   = "idle_timeout"
+	ReasonActiveTimeout = "active_timeout"
+	ReasonEvicted       = "evicted"
+	ReasonShutdown      = "shutdown"
+)
+
+// Config holds the aggregation configuration.
+type Config struct {
+	IdleTimeout   time.Duration
+	ActiveTimeout time.Duration
+	MaxFlows      int
+}
+
+// Aggregator keeps per-flow state and emits one summary record per
+// connection.
+type Aggregator struct {
+	config Config
+	mu     sync.Mutex
+	flows  map[uint32]*list.Element
+	lru    *list.List
+	now    func() time.Time
+}
+
+// flowState is the aggregated state of a single flow.
+type flowState struct {
+	id        uint32
+	event     *record.Event
+	firstSeen time.Time
+	lastSeen  time.Time
+	events    uint64
+	states    []string
+}
+
+// NewAggregator creates a new flow aggregator.
+func NewAggregator(config Config) *Aggregator {
+	return &Aggregator{
+		config: config,
+		flows:  make(map[uint32]*list.Element),
+		lru:    list.New(),
+		now:    time.Now,
+	}
+}
+
+// Add adds a conntrack event to the flow state. Geolocation data is looked up
+// on first sight of a flow only, the filter policy of each event is applied to
+// the flow. Returns the summaries due, i.e. the summary of a destroyed flow and
+// partial summaries of evicted flows.
+func (a *Aggregator) Add(event conntrack.Event, geo *geoip.Lookup, policy record.Policy) []*record.Event {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	id := event.Flow.ID
+
+	var state *flowState
+	if element, ok := a.flows[id]; ok {
+		state = element.Value.(*flowState)
+		state.event.SetFlowState(event.Flow)
+		a.lru.MoveToFront(element)
+	} else {
+		state = &flowState{
+			id:        id,
+			event:     record.NewEvent(event, geo),
+			firstSeen: now,
+		}
+		a.flows[id] = a.lru.PushFront(state)
+	}
+
+	state.event.Apply(policy)
+	state.lastSeen = now
+	state.events++
+	if tcpState := state.event.TCPState; tcpState != "" {
+		if len(state.states) == 0 || state.states[len(state.states)-1] != tcpState {
+			state.states = append(state.states, tcpState)
+		}
+	}
+
+	var summaries []*record.Event
+	if event.Type == conntrack.EventDestroy {
+		summaries = append(summaries, a.remove(state, ReasonDestroy))
+	}
+
+	for a.config.MaxFlows > 0 && a.lru.Len() > a.config.MaxFlows {
+		oldest := a.lru.Back().Value.(*flowState)
+		summaries = append(summaries, a.remove(oldest, ReasonEvicted))
+	}
+
+	return summaries
+}
+
+// Expire returns partial summaries of flows exceeding the idle or active
+// timeout and removes them.
+func (a *Aggregator) Expire() []*record.Event {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+
+	var summaries []*record.Event
+	for element := a.lru.Back(); element != nil; {
+		state := element.Value.(*flowState)
+		element = element.Prev()
+
+		switch {
+		case a.config.IdleTimeout > 0 && now.Sub(state.lastSeen) >= a.config.IdleTimeout:
+			summaries = append(summaries, a.remove(state, ReasonIdleTimeout))
+		case a.config.ActiveTimeout > 0 && now.Sub(state.firstSeen) >= a.config.ActiveTimeout:
+			summaries = append(summaries, a.remove(state, ReasonActiveTimeout))
+		}
+	}
+
+	return summaries
+}
+
+// Flush returns partial summaries of all flows and removes them.
+func (a *Aggregator) Flush() []*record.Event {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var summaries []*record.Event
+	for element := a.lru.Back(); element != nil; {
+		state := element.Value.(*flowState)
+		element = element.Prev()
+		summaries = append(summaries, a.remove(state, ReasonShutdown))
+	}
+
+	return summaries
+}
+
+// Len returns the number of tracked flows.
+func (a *Aggregator) Len() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.lru.Len()
+}
+
+// remove removes the flow state and returns its summary.
+func (a *Aggregator) remove(state *flowState, reason string) *record.Event {
+	if element, ok := a.flows[state.id]; ok {
+		a.lru.Remove(element)
+		delete(a.flows, state.id)
+	}
+
+	return state.summary(reason)
+}
+
+// summary creates the summary record of the flow state.
+func (s *flowState) summary(reason string) *record.Event {
+	summary := *s.event
+	duration := s.lastSeen.Sub(s.firstSeen).Seconds()
+
+	summary.Type = "SUMMARY"
+	summary.FirstSeen = s.firstSeen
+	summary.LastSeen = s.lastSeen
+	summary.Duration = &duration
+	summary.Events = s.events
+	summary.TCPStates = s.states
+	summary.Reason = reason
+
+	return &summary
+}
@@ -0,0 +1,162 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package aggregator
+
+import (
+	"log/slog"
+	"net/netip"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ti-mo/conntrack"
+	"github.com/tschaefer/conntrackd/internal/record"
+)
+
+func __createEvent(id uint32, state uint8) conntrack.Event {
+	flow := conntrack.NewFlow(
+		syscall.IPPROTO_TCP,
+		conntrack.StatusAssured,
+		netip.MustParseAddr("10.19.80.100"), netip.MustParseAddr("78.47.60.169"),
+		4711, 443,
+		60, 0,
+	)
+	flow.ID = id
+	flow.ProtoInfo.TCP = &conntrack.ProtoInfoTCP{State: state}
+
+	return conntrack.Event{
+		Type: conntrack.EventUpdate,
+		Flow: &flow,
+	}
+}
+
+func __newAggregator(config Config) (*Aggregator, *time.Time) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := NewAggregator(config)
+	a.now = func() time.Time { return now }
+
+	return a, &now
+}
+
+func addReturnsSummaryOnDestroy(t *testing.T) {
+	a, now := __newAggregator(Config{})
+
+	assert.Empty(t, a.Add(__createEvent(1, 1), nil, record.Policy{}))
+	*now = now.Add(time.Second)
+	assert.Empty(t, a.Add(__createEvent(1, 3), nil, record.Policy{}))
+	assert.Empty(t, a.Add(__createEvent(1, 3), nil, record.Policy{}))
+	*now = now.Add(2 * time.Second)
+
+	event := __createEvent(1, 7)
+	event.Type = conntrack.EventDestroy
+	event.Flow.CountersOrig = conntrack.Counter{Packets: 10, Bytes: 1000}
+	summaries := a.Add(event, nil, record.Policy{})
+	assert.Len(t, summaries, 1)
+	assert.Zero(t, a.Len())
+
+	summary := summaries[0]
+	assert.Equal(t, "SUMMARY", summary.Type)
+	assert.Equal(t, uint32(1), summary.Flow)
+	assert.Equal(t, ReasonDestroy, summary.Reason)
+	assert.Equal(t, uint64(4), summary.Events)
+	assert.Equal(t, 3.0, *summary.Duration)
+	assert.Equal(t, []string{"SYN_SENT", "ESTABLISHED", "TIME_WAIT"}, summary.TCPStates)
+	assert.Equal(t, "TIME_WAIT", summary.TCPState)
+	assert.Equal(t, uint64(10), summary.OrigPackets)
+	assert.Equal(t, uint64(1000), summary.OrigBytes)
+	assert.Equal(t, 3*time.Second, summary.LastSeen.Sub(summary.FirstSeen))
+}
+
+func addEvictsLeastRecentlyUsedFlow(t *testing.T) {
+	a, _ := __newAggregator(Config{MaxFlows: 2})
+
+	assert.Empty(t, a.Add(__createEvent(1, 1), nil, record.Policy{}))
+	assert.Empty(t, a.Add(__createEvent(2, 1), nil, record.Policy{}))
+	assert.Empty(t, a.Add(__createEvent(1, 3), nil, record.Policy{}))
+
+	summaries := a.Add(__createEvent(3, 1), nil, record.Policy{})
+	assert.Len(t, summaries, 1)
+	assert.Equal(t, uint32(2), summaries[0].Flow)
+	assert.Equal(t, ReasonEvicted, summaries[0].Reason)
+	assert.Equal(t, 2, a.Len())
+}
+
+func expireReturnsTimedOutFlows(t *testing.T) {
+	a, now := __newAggregator(Config{IdleTimeout: time.Minute, ActiveTimeout: time.Hour})
+
+	assert.Empty(t, a.Add(__createEvent(1, 1), nil, record.Policy{}))
+	assert.Empty(t, a.Add(__createEvent(2, 1), nil, record.Policy{}))
+	assert.Empty(t, a.Expire())
+
+	*now = now.Add(30 * time.Second)
+	assert.Empty(t, a.Add(__createEvent(2, 3), nil, record.Policy{}))
+	*now = now.Add(30 * time.Second)
+
+	summaries := a.Expire()
+	assert.Len(t, summaries, 1)
+	assert.Equal(t, uint32(1), summaries[0].Flow)
+	assert.Equal(t, ReasonIdleTimeout, summaries[0].Reason)
+	assert.Equal(t, 1, a.Len())
+
+	*now = now.Add(time.Hour)
+	summaries = a.Expire()
+	assert.Len(t, summaries, 1)
+	assert.Equal(t, uint32(2), summaries[0].Flow)
+	assert.Equal(t, ReasonIdleTimeout, summaries[0].Reason)
+}
+
+func expireReturnsLongLivedFlows(t *testing.T) {
+	a, now := __newAggregator(Config{IdleTimeout: time.Minute, ActiveTimeout: 2 * time.Minute})
+
+	assert.Empty(t, a.Add(__createEvent(1, 1), nil, record.Policy{}))
+	*now = now.Add(59 * time.Second)
+	assert.Empty(t, a.Add(__createEvent(1, 3), nil, record.Policy{}))
+	*now = now.Add(59 * time.Second)
+	assert.Empty(t, a.Add(__createEvent(1, 3), nil, record.Policy{}))
+	assert.Empty(t, a.Expire())
+
+	*now = now.Add(2 * time.Second)
+	summaries := a.Expire()
+	assert.Len(t, summaries, 1)
+	assert.Equal(t, ReasonActiveTimeout, summaries[0].Reason)
+	assert.Equal(t, uint64(3), summaries[0].Events)
+	assert.Zero(t, a.Len())
+}
+
+func flushReturnsAllFlows(t *testing.T) {
+	a, _ := __newAggregator(Config{})
+
+	assert.Empty(t, a.Add(__createEvent(1, 1), nil, record.Policy{}))
+	assert.Empty(t, a.Add(__createEvent(2, 1), nil, record.Policy{}))
+
+	summaries := a.Flush()
+	assert.Len(t, summaries, 2)
+	for _, summary := range summaries {
+		assert.Equal(t, ReasonShutdown, summary.Reason)
+	}
+	assert.Zero(t, a.Len())
+}
+
+func addAppliesPolicyToFlow(t *testing.T) {
+	a, _ := __newAggregator(Config{})
+
+	assert.Empty(t, a.Add(__createEvent(1, 1), nil, record.Policy{Tags: []string{"web"}}))
+	assert.Empty(t, a.Add(__createEvent(1, 3), nil, record.Policy{Tags: []string{"web", "tls"}, Alert: true}))
+
+	summaries := a.Flush()
+	assert.Len(t, summaries, 1)
+	assert.Equal(t, []string{"web", "tls"}, summaries[0].Tags)
+	assert.Equal(t, slog.LevelWarn, summaries[0].Level)
+}
+
+func TestAggregator(t *testing.T) {
+	t.Run("aggregator.Add returns summary on destroy", addReturnsSummaryOnDestroy)
+	t.Run("aggregator.Add evicts least recently used flow", addEvictsLeastRecentlyUsedFlow)
+	t.Run("aggregator.Expire returns timed out flows", expireReturnsTimedOutFlows)
+	t.Run("aggregator.Expire returns long-lived flows", expireReturnsLongLivedFlows)
+	t.Run("aggregator.Flush returns all flows", flushReturnsAllFlows)
+	t.Run("aggregator.Add applies policy to flow", addAppliesPolicyToFlow)
+}
@@ -0,0 +1,185 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mdlayher/netlink"
+	"github.com/ti-mo/conntrack"
+)
+
+// Version is the version of the capture format.
+const Version = 1
+
+// Magic starts every capture file.
+var Magic = []byte("CTDCAP")
+
+// NSIDUnknown is the network namespace id of records received without it.
+const NSIDUnknown = -1
+
+// maxLength limits the length of headers and messages read.
+const maxLength = 1 << 20
+
+// Header describes the capture.
+type Header struct {
+	Host    string    `json:"host,omitempty"`
+	Kernel  string    `json:"kernel,omitempty"`
+	Started time.Time `json:"started,omitzero"`
+	// Rules are the filter rules the captured events were pre-filtered by.
+	Rules []string `json:"rules,omitempty"`
+}
+
+// Record is a captured netlink message of a conntrack event.
+type Record struct {
+	Received time.Time
+	NSID     int32
+	Message  netlink.Message
+}
+
+// Event decodes the conntrack event of the record.
+func (r Record) Event() (conntrack.Event, error) {
+	var event conntrack.Event
+	err := event.Unmarshal(r.Message)
+	return event, err
+}
+
+// Writer writes a capture file. A capture file starts with Magic, the format
+// version as uint16 and the length of the JSON encoded Header as uint32. Each
+// record follows as receive time in Unix nanoseconds as int64, network
+// namespace id as int32, length of the netlink message as uint32 and the
+// message itself. Numbers are little endian, the netlink message is in host
+// byte order as received from the kernel.
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter writes the file header and returns a writer for the records.
+func NewWriter(w io.Writer, header Header) (*Writer, error) {
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bufio.NewWriter(w)
+	_, _ = buf.Write(Magic)
+	_ = binary.Write(buf, binary.LittleEndian, uint16(Version))
+	_ = binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+	_, _ = buf.Write(data)
+
+	return &Writer{w: buf}, buf.Flush()
+}
+
+// Write writes a record. Records are buffered, see Flush.
+func (w *Writer) Write(record Record) error {
+	data, err := record.Message.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	var prefix [16]byte
+	binary.LittleEndian.PutUint64(prefix[0:], uint64(record.Received.UnixNano()))
+	binary.LittleEndian.PutUint32(prefix[8:], uint32(record.NSID))
+	binary.LittleEndian.PutUint32(prefix[12:], uint32(len(data)))
+	if _, err := w.w.Write(prefix[:]); err != nil {
+		return err
+	}
+	_, err = w.w.Write(data)
+	return err
+}
+
+// Flush writes the buffered records.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Reader reads a capture file.
+type Reader struct {
+	r      *bufio.Reader
+	header Header
+}
+
+// IsCapture reports whether the data starts like a capture file.
+func IsCapture(data []byte) bool {
+	return bytes.HasPrefix(data, Magic)
+}
+
+// NewReader reads the file header and returns a reader for the records.
+func NewReader(r io.Reader) (*Reader, error) {
+	buf := bufio.NewReader(r)
+
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(buf, magic); err != nil || !IsCapture(magic) {
+		return nil, fmt.Errorf("not a capture file")
+	}
+
+	var version uint16
+	var length uint32
+	if err := binary.Read(buf, binary.LittleEndian, &version); err != nil {
+		return nil, fmt.Errorf("read capture version: %w", err)
+	}
+	if version != Version {
+		return nil, fmt.Errorf("unsupported capture version %d", version)
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &length); err != nil {
+		return nil, fmt.Errorf("read capture header: %w", err)
+	}
+	if length > maxLength {
+		return nil, fmt.Errorf("capture header of %d bytes too large", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(buf, data); err != nil {
+		return nil, fmt.Errorf("read capture header: %w", err)
+	}
+	reader := &Reader{r: buf}
+	if err := json.Unmarshal(data, &reader.header); err != nil {
+		return nil, fmt.Errorf("decode capture header: %w", err)
+	}
+
+	return reader, nil
+}
+
+// Header returns the file header.
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// Next returns the next record, io.EOF at the end of the file.
+func (r *Reader) Next() (Record, error) {
+	var prefix [16]byte
+	if _, err := io.ReadFull(r.r, prefix[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return Record{}, fmt.Errorf("truncated capture record")
+		}
+		return Record{}, err
+	}
+
+	length := binary.LittleEndian.Uint32(prefix[12:])
+	if length > maxLength {
+		return Record{}, fmt.Errorf("capture record of %d bytes too large", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return Record{}, fmt.Errorf("truncated capture record")
+	}
+
+	record := Record{
+		Received: time.Unix(0, int64(binary.LittleEndian.Uint64(prefix[0:]))),
+		NSID:     int32(binary.LittleEndian.Uint32(prefix[8:])),
+	}
+	if err := record.Message.UnmarshalBinary(data); err != nil {
+		return Record{}, fmt.Errorf("decode capture record: %w", err)
+	}
+
+	return record, nil
+}
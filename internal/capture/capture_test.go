@@ -0,0 +1,115 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package capture
+
+import (
+	"bytes"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ti-mo/conntrack"
+	"github.com/ti-mo/netfilter"
+)
+
+// __createMessage returns the ctnetlink message of a NEW TCP event from
+// 10.0.0.1:4711 to 10.0.0.2:443
+func __createMessage(t *testing.T, flow uint32) netlink.Message {
+	header := netfilter.Header{
+		SubsystemID: netfilter.NFSubsysCTNetlink,
+		Family:      netfilter.ProtoIPv4,
+		Flags:       netlink.Create | netlink.Excl,
+	}
+	attrs := []netfilter.Attribute{
+		{Type: 1, Nested: true, Children: []netfilter.Attribute{
+			{Type: 1, Nested: true, Children: []netfilter.Attribute{
+				{Type: 1, Data: []byte{10, 0, 0, 1}},
+				{Type: 2, Data: []byte{10, 0, 0, 2}},
+			}},
+			{Type: 2, Nested: true, Children: []netfilter.Attribute{
+				{Type: 1, Data: []byte{syscall.IPPROTO_TCP}},
+				{Type: 2, Data: []byte{0x12, 0x67}, NetByteOrder: true},
+				{Type: 3, Data: []byte{0x01, 0xbb}, NetByteOrder: true},
+			}},
+		}},
+		{Type: 12, Data: []byte{byte(flow >> 24), byte(flow >> 16), byte(flow >> 8), byte(flow)}, NetByteOrder: true},
+	}
+
+	message, err := netfilter.MarshalNetlink(header, attrs)
+	require.NoError(t, err)
+	message.Header.Length = uint32(16 + len(message.Data))
+	return message
+}
+
+func writerAndReaderRoundTrip(t *testing.T) {
+	header := Header{
+		Host:    "gateway",
+		Kernel:  "6.12.0",
+		Started: time.Unix(1764074111, 0).UTC(),
+		Rules:   []string{"drop destination.port == 22"},
+	}
+	received := time.Unix(1764074112, 5000)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, header)
+	require.NoError(t, err)
+	require.NoError(t, w.Write(Record{Received: received, NSID: NSIDUnknown, Message: __createMessage(t, 1234)}))
+	require.NoError(t, w.Write(Record{Received: received.Add(time.Second), NSID: 3, Message: __createMessage(t, 5678)}))
+	require.NoError(t, w.Flush())
+
+	r, err := NewReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, header, r.Header())
+
+	record, err := r.Next()
+	require.NoError(t, err)
+	assert.True(t, received.Equal(record.Received))
+	assert.Equal(t, int32(NSIDUnknown), record.NSID)
+
+	event, err := record.Event()
+	require.NoError(t, err)
+	assert.Equal(t, conntrack.EventNew, event.Type)
+	assert.Equal(t, uint32(1234), event.Flow.ID)
+	assert.Equal(t, uint16(443), event.Flow.TupleOrig.Proto.DestinationPort)
+
+	record, err = r.Next()
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), record.NSID)
+
+	_, err = r.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func readerRejectsOtherFiles(t *testing.T) {
+	_, err := NewReader(bytes.NewBufferString(`{"type":"NEW"}`))
+	assert.EqualError(t, err, "not a capture file")
+
+	data := append(bytes.Clone(Magic), 2, 0)
+	_, err = NewReader(bytes.NewBuffer(data))
+	assert.EqualError(t, err, "unsupported capture version 2")
+}
+
+func readerFailsOnTruncatedRecord(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, Header{})
+	require.NoError(t, err)
+	require.NoError(t, w.Write(Record{Received: time.Now(), Message: __createMessage(t, 1)}))
+	require.NoError(t, w.Flush())
+
+	r, err := NewReader(bytes.NewBuffer(buf.Bytes()[:buf.Len()-4]))
+	require.NoError(t, err)
+	_, err = r.Next()
+	assert.EqualError(t, err, "truncated capture record")
+}
+
+func TestCapture(t *testing.T) {
+	t.Run("capture.Writer and capture.Reader round trip", writerAndReaderRoundTrip)
+	t.Run("capture.NewReader rejects other files", readerRejectsOtherFiles)
+	t.Run("capture.Reader fails on truncated record", readerFailsOnTruncatedRecord)
+}
@@ -0,0 +1,114 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package communityid
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"net/netip"
+	"syscall"
+
+	"github.com/ti-mo/conntrack"
+)
+
+// Version prefix of the Community ID flow hash.
+const prefix = "1:"
+
+// Seed is the seed used for all flow hashes, default 0.
+var Seed uint16
+
+// ICMP message types and their request/response counterpart.
+var icmpEquivalents = map[uint16]uint16{
+	0: 8, 8: 0, // echo
+	9: 10, 10: 9, // router advertisement/solicitation
+	13: 14, 14: 13, // timestamp
+	15: 16, 16: 15, // information
+	17: 18, 18: 17, // address mask
+}
+
+// ICMPv6 message types and their request/response counterpart.
+var icmp6Equivalents = map[uint16]uint16{
+	128: 129, 129: 128, // echo
+	130: 131, 131: 130, // multicast listener
+	133: 134, 134: 133, // router solicitation/advertisement
+	135: 136, 136: 135, // neighbor solicitation/advertisement
+	139: 140, 140: 139, // node information
+	144: 145, 145: 144, // home agent address discovery
+}
+
+// Hash computes the Community ID v1 flow hash of the given tuple. For ICMP
+// and ICMPv6 the source port is the message type and the destination port
+// the message code.
+func Hash(seed uint16, proto uint8, src, dst netip.Addr, srcPort, dstPort uint16) string {
+	oneWay := false
+	switch proto {
+	case syscall.IPPROTO_ICMP:
+		srcPort, dstPort, oneWay = icmpPorts(icmpEquivalents, srcPort, dstPort)
+	case syscall.IPPROTO_ICMPV6:
+		srcPort, dstPort, oneWay = icmpPorts(icmp6Equivalents, srcPort, dstPort)
+	}
+
+	if !oneWay && !isOrdered(src, dst, srcPort, dstPort) {
+		src, dst = dst, src
+		srcPort, dstPort = dstPort, srcPort
+	}
+
+	data := binary.BigEndian.AppendUint16(nil, seed)
+	data = append(data, src.AsSlice()...)
+	data = append(data, dst.AsSlice()...)
+	data = append(data, proto, 0)
+	if hasPorts(proto) {
+		data = binary.BigEndian.AppendUint16(data, srcPort)
+		data = binary.BigEndian.AppendUint16(data, dstPort)
+	}
+
+	sum := sha1.Sum(data)
+	return prefix + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// FlowHash computes the Community ID v1 flow hash of the original tuple of
+// the given flow with the configured seed.
+func FlowHash(flow *conntrack.Flow) string {
+	tuple := flow.TupleOrig
+	srcPort, dstPort := tuple.Proto.SourcePort, tuple.Proto.DestinationPort
+
+	switch tuple.Proto.Protocol {
+	case syscall.IPPROTO_ICMP, syscall.IPPROTO_ICMPV6:
+		srcPort, dstPort = uint16(tuple.Proto.ICMPType), uint16(tuple.Proto.ICMPCode)
+	}
+
+	return Hash(Seed, tuple.Proto.Protocol,
+		tuple.IP.SourceAddress, tuple.IP.DestinationAddress,
+		srcPort, dstPort,
+	)
+}
+
+// icmpPorts maps ICMP type and code to port equivalents. Messages without
+// counterpart are one-way and must not be reordered.
+func icmpPorts(equivalents map[uint16]uint16, typ, code uint16) (uint16, uint16, bool) {
+	if counterpart, ok := equivalents[typ]; ok {
+		return typ, counterpart, false
+	}
+	return typ, code, true
+}
+
+// isOrdered reports whether the source endpoint is ordered before the
+// destination endpoint.
+func isOrdered(src, dst netip.Addr, srcPort, dstPort uint16) bool {
+	cmp := src.Compare(dst)
+	return cmp < 0 || (cmp == 0 && srcPort < dstPort)
+}
+
+// hasPorts reports whether the protocol hash includes ports.
+func hasPorts(proto uint8) bool {
+	switch proto {
+	case syscall.IPPROTO_TCP, syscall.IPPROTO_UDP, syscall.IPPROTO_SCTP,
+		syscall.IPPROTO_ICMP, syscall.IPPROTO_ICMPV6:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,119 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package communityid
+
+import (
+	"net/netip"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ti-mo/conntrack"
+)
+
+func hashMatchesSpecTestVectors(t *testing.T) {
+	tests := []struct {
+		name    string
+		seed    uint16
+		proto   uint8
+		src     string
+		dst     string
+		srcPort uint16
+		dstPort uint16
+		want    string
+	}{
+		{"tcp", 0, syscall.IPPROTO_TCP, "128.232.110.120", "66.35.250.204", 34855, 80, "1:LQU9qZlK+B5F3KDmev6m5PMibrg="},
+		{"tcp seed", 1, syscall.IPPROTO_TCP, "128.232.110.120", "66.35.250.204", 34855, 80, "1:3V71V58M3Ksw/yuFALMcW0LAHvc="},
+		{"udp", 0, syscall.IPPROTO_UDP, "192.168.1.52", "8.8.8.8", 54585, 53, "1:d/FP5EW3wiY1vCndhwleRRKHowQ="},
+		{"icmp", 0, syscall.IPPROTO_ICMP, "192.168.0.89", "192.168.0.1", 8, 0, "1:X0snYXpgwiv9TZtqg64sgzUn6Dk="},
+		{"icmp6", 0, syscall.IPPROTO_ICMPV6, "fe80::200:86ff:fe05:80da", "fe80::260:97ff:fe07:69ea", 135, 0, "1:dGHyGvjMfljg6Bppwm3bg0LO8TY="},
+	}
+
+	for _, tt := range tests {
+		got := Hash(tt.seed, tt.proto,
+			netip.MustParseAddr(tt.src), netip.MustParseAddr(tt.dst),
+			tt.srcPort, tt.dstPort,
+		)
+		assert.Equal(t, tt.want, got, tt.name)
+	}
+}
+
+func hashIsDirectionIndependent(t *testing.T) {
+	tests := []struct {
+		name  string
+		proto uint8
+		src   string
+		dst   string
+	}{
+		{"tcp ipv4", syscall.IPPROTO_TCP, "10.0.0.1", "8.8.8.8"},
+		{"tcp ipv6", syscall.IPPROTO_TCP, "2003:cf:1716:7b64::1", "2a01:4f8:160:5372::2"},
+		{"udp ipv6", syscall.IPPROTO_UDP, "2003:cf:1716:7b64::1", "2a01:4f8:160:5372::2"},
+		{"same address", syscall.IPPROTO_UDP, "10.0.0.1", "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		src, dst := netip.MustParseAddr(tt.src), netip.MustParseAddr(tt.dst)
+		forward := Hash(0, tt.proto, src, dst, 41756, 443)
+		reverse := Hash(0, tt.proto, dst, src, 443, 41756)
+		assert.Equal(t, forward, reverse, tt.name)
+	}
+
+	src, dst := netip.MustParseAddr("192.168.0.89"), netip.MustParseAddr("192.168.0.1")
+	request := Hash(0, syscall.IPPROTO_ICMP, src, dst, 8, 0)
+	reply := Hash(0, syscall.IPPROTO_ICMP, dst, src, 0, 0)
+	assert.Equal(t, request, reply, "icmp echo request and reply")
+}
+
+func hashKeepsOneWayICMPDirection(t *testing.T) {
+	src, dst := netip.MustParseAddr("192.168.0.1"), netip.MustParseAddr("192.168.0.89")
+	forward := Hash(0, syscall.IPPROTO_ICMP, src, dst, 3, 1)
+	reverse := Hash(0, syscall.IPPROTO_ICMP, dst, src, 3, 1)
+	assert.NotEqual(t, forward, reverse)
+}
+
+func hashIgnoresPortsForPortlessProtocols(t *testing.T) {
+	src, dst := netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")
+	assert.Equal(t,
+		Hash(0, syscall.IPPROTO_GRE, src, dst, 1, 2),
+		Hash(0, syscall.IPPROTO_GRE, src, dst, 3, 4),
+	)
+}
+
+func flowHashUsesOriginalTuple(t *testing.T) {
+	flow := conntrack.NewFlow(
+		syscall.IPPROTO_TCP,
+		conntrack.StatusAssured,
+		netip.MustParseAddr("128.232.110.120"), netip.MustParseAddr("66.35.250.204"),
+		34855, 80,
+		60, 0,
+	)
+	assert.Equal(t, "1:LQU9qZlK+B5F3KDmev6m5PMibrg=", FlowHash(&flow))
+
+	Seed = 1
+	defer func() { Seed = 0 }()
+	assert.Equal(t, "1:3V71V58M3Ksw/yuFALMcW0LAHvc=", FlowHash(&flow))
+}
+
+func flowHashUsesICMPTypeAndCode(t *testing.T) {
+	flow := conntrack.NewFlow(
+		syscall.IPPROTO_ICMP,
+		conntrack.StatusAssured,
+		netip.MustParseAddr("192.168.0.89"), netip.MustParseAddr("192.168.0.1"),
+		0, 0,
+		30, 0,
+	)
+	flow.TupleOrig.Proto.ICMPType = 8
+	flow.TupleOrig.Proto.ICMPCode = 0
+	assert.Equal(t, "1:X0snYXpgwiv9TZtqg64sgzUn6Dk=", FlowHash(&flow))
+}
+
+func TestCommunityID(t *testing.T) {
+	t.Run("communityid.Hash matches spec test vectors", hashMatchesSpecTestVectors)
+	t.Run("communityid.Hash is direction independent", hashIsDirectionIndependent)
+	t.Run("communityid.Hash keeps one-way ICMP direction", hashKeepsOneWayICMPDirection)
+	t.Run("communityid.Hash ignores ports for portless protocols", hashIgnoresPortsForPortlessProtocols)
+	t.Run("communityid.FlowHash uses original tuple", flowHashUsesOriginalTuple)
+	t.Run("communityid.FlowHash uses ICMP type and code", flowHashUsesICMPTypeAndCode)
+}
@@ -0,0 +1,249 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package dedup
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/ti-mo/conntrack"
+	"github.com/tschaefer/conntrackd/internal/record"
+)
+
+// Config holds the deduplication configuration.
+type Config struct {
+	StateChanges bool
+	Window       time.Duration
+	MaxFlows     int
+}
+
+// Stats holds the number of suppressed UPDATE events. Coalesced counts
+// UPDATE events superseded by a later event of the flow within the window.
+type Stats struct {
+	Unchanged uint64
+	Coalesced uint64
+}
+
+// Held is the newest UPDATE event of a flow suppressed within the coalescing
+// window with the filter policy to record it with. It is recorded when the
+// window closes, unless superseded by a later event of the flow.
+type Held struct {
+	Event  conntrack.Event
+	Policy record.Policy
+}
+
+// Deduplicator suppresses UPDATE events carrying no relevant change and
+// coalesces bursts of UPDATE events.
+type Deduplicator struct {
+	config Config
+	mu     sync.Mutex
+	flows  map[uint32]*list.Element
+	held   map[uint32]*flowState
+	lru    *list.List
+	stats  Stats
+	now    func() time.Time
+}
+
+// flowState is the last recorded state of a single flow.
+type flowState struct {
+	id       uint32
+	state    state
+	recorded time.Time
+	held     *Held
+}
+
+// state holds the flow attributes an UPDATE event is recorded for on change.
+type state struct {
+	tcpState uint8
+	status   conntrack.Status
+	mark     uint32
+	reply    conntrack.Tuple
+}
+
+// NewDeduplicator creates a new UPDATE event deduplicator.
+func NewDeduplicator(config Config) *Deduplicator {
+	return &Deduplicator{
+		config: config,
+		flows:  make(map[uint32]*list.Element),
+		held:   make(map[uint32]*flowState),
+		lru:    list.New(),
+		now:    time.Now,
+	}
+}
+
+// Window returns the coalescing window, 0 if disabled.
+func (d *Deduplicator) Window() time.Duration {
+	return d.config.Window
+}
+
+// Allow reports whether the conntrack event should be recorded. NEW and
+// DESTROY events are always allowed, UPDATE events are suppressed if the flow
+// state did not change. UPDATE events within the coalescing window after a
+// recorded one are held instead, the newest one is returned by Expire once
+// the window closes. A later recorded event of the flow supersedes it.
+func (d *Deduplicator) Allow(event conntrack.Event, policy record.Policy) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id := event.Flow.ID
+	current := getState(event.Flow)
+
+	if event.Type == conntrack.EventDestroy {
+		if element, ok := d.flows[id]; ok {
+			d.remove(element)
+		}
+		return true
+	}
+
+	now := d.now()
+
+	element, ok := d.flows[id]
+	if !ok {
+		element = d.lru.PushFront(&flowState{id: id, state: current, recorded: now})
+		d.flows[id] = element
+
+		for d.config.MaxFlows > 0 && d.lru.Len() > d.config.MaxFlows {
+			d.remove(d.lru.Back())
+		}
+
+		return true
+	}
+
+	d.lru.MoveToFront(element)
+	flow := element.Value.(*flowState)
+
+	if event.Type != conntrack.EventUpdate {
+		d.record(flow, current, now)
+		return true
+	}
+
+	if d.config.StateChanges && flow.state == current {
+		d.stats.Unchanged++
+		return false
+	}
+
+	if d.config.Window > 0 && now.Sub(flow.recorded) < d.config.Window {
+		d.hold(flow, &Held{Event: event, Policy: policy})
+		return false
+	}
+
+	d.record(flow, current, now)
+	return true
+}
+
+// Expire returns the held UPDATE events of the flows whose coalescing window
+// closed, restricted to the flows owned by the caller if owns is given. The
+// returned events count as recorded.
+func (d *Deduplicator) Expire(owns func(flow uint32) bool) []Held {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+
+	var held []Held
+	for id, flow := range d.held {
+		if owns != nil && !owns(id) || now.Sub(flow.recorded) < d.config.Window {
+			continue
+		}
+		held = append(held, d.emit(flow, now))
+	}
+
+	return held
+}
+
+// Flush returns the held UPDATE events of all flows regardless of the
+// coalescing window, e.g. on shutdown.
+func (d *Deduplicator) Flush() []Held {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+
+	var held []Held
+	for _, flow := range d.held {
+		held = append(held, d.emit(flow, now))
+	}
+
+	return held
+}
+
+// hold holds the UPDATE event of the flow, superseding a previously held one.
+func (d *Deduplicator) hold(flow *flowState, held *Held) {
+	if flow.held != nil {
+		d.stats.Coalesced++
+	}
+	flow.held = held
+	d.held[flow.id] = flow
+}
+
+// record sets the recorded state of the flow, superseding a held event.
+func (d *Deduplicator) record(flow *flowState, current state, now time.Time) {
+	if flow.held != nil {
+		d.stats.Coalesced++
+		d.release(flow)
+	}
+	flow.state, flow.recorded = current, now
+}
+
+// emit releases the held event of the flow and sets it as recorded state.
+func (d *Deduplicator) emit(flow *flowState, now time.Time) Held {
+	held := *flow.held
+	d.release(flow)
+	flow.state, flow.recorded = getState(held.Event.Flow), now
+
+	return held
+}
+
+// release forgets the held event of the flow.
+func (d *Deduplicator) release(flow *flowState) {
+	flow.held = nil
+	delete(d.held, flow.id)
+}
+
+// remove removes the flow, a held event is dropped.
+func (d *Deduplicator) remove(element *list.Element) {
+	flow := element.Value.(*flowState)
+	if flow.held != nil {
+		d.stats.Coalesced++
+		d.release(flow)
+	}
+	d.lru.Remove(element)
+	delete(d.flows, flow.id)
+}
+
+// Stats returns the number of suppressed UPDATE events since the last call
+// and resets the counters.
+func (d *Deduplicator) Stats() Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats := d.stats
+	d.stats = Stats{}
+
+	return stats
+}
+
+// Len returns the number of tracked flows.
+func (d *Deduplicator) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.lru.Len()
+}
+
+// getState returns the relevant state of the flow.
+func getState(flow *conntrack.Flow) state {
+	s := state{
+		status: flow.Status,
+		mark:   flow.Mark,
+		reply:  flow.TupleReply,
+	}
+	if flow.ProtoInfo.TCP != nil {
+		s.tcpState = flow.ProtoInfo.TCP.State
+	}
+
+	return s
+}
@@ -0,0 +1,158 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package dedup
+
+import (
+	"net/netip"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ti-mo/conntrack"
+	"github.com/tschaefer/conntrackd/internal/record"
+)
+
+func __createEvent(id uint32, state uint8) conntrack.Event {
+	flow := conntrack.NewFlow(
+		syscall.IPPROTO_TCP,
+		conntrack.StatusAssured,
+		netip.MustParseAddr("10.19.80.100"), netip.MustParseAddr("78.47.60.169"),
+		4711, 443,
+		60, 0,
+	)
+	flow.ID = id
+	flow.ProtoInfo.TCP = &conntrack.ProtoInfoTCP{State: state}
+
+	return conntrack.Event{
+		Type: conntrack.EventUpdate,
+		Flow: &flow,
+	}
+}
+
+func __newDeduplicator(config Config) (*Deduplicator, *time.Time) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := NewDeduplicator(config)
+	d.now = func() time.Time { return now }
+
+	return d, &now
+}
+
+func allowSuppressesUnchangedUpdates(t *testing.T) {
+	d, _ := __newDeduplicator(Config{StateChanges: true})
+
+	event := __createEvent(1, 1)
+	event.Type = conntrack.EventNew
+	assert.True(t, d.Allow(event, record.Policy{}))
+
+	assert.False(t, d.Allow(__createEvent(1, 1), record.Policy{}))
+	assert.True(t, d.Allow(__createEvent(1, 3), record.Policy{}))
+	assert.False(t, d.Allow(__createEvent(1, 3), record.Policy{}))
+
+	event = __createEvent(1, 3)
+	event.Flow.Mark = 42
+	assert.True(t, d.Allow(event, record.Policy{}))
+
+	event = __createEvent(1, 3)
+	event.Flow.Mark = 42
+	event.Flow.Status |= conntrack.StatusSeenReply
+	assert.True(t, d.Allow(event, record.Policy{}))
+
+	event = __createEvent(1, 3)
+	event.Flow.Mark = 42
+	event.Flow.Status |= conntrack.StatusSeenReply
+	event.Flow.TupleReply.IP.DestinationAddress = netip.MustParseAddr("192.0.2.1")
+	assert.True(t, d.Allow(event, record.Policy{}))
+
+	event = __createEvent(1, 7)
+	event.Type = conntrack.EventDestroy
+	assert.True(t, d.Allow(event, record.Policy{}))
+	assert.Zero(t, d.Len())
+
+	assert.Equal(t, Stats{Unchanged: 2}, d.Stats())
+	assert.Equal(t, Stats{}, d.Stats())
+}
+
+func allowCoalescesUpdatesWithinWindow(t *testing.T) {
+	d, now := __newDeduplicator(Config{Window: 10 * time.Second})
+	policy := record.Policy{Tags: []string{"web"}}
+
+	assert.True(t, d.Allow(__createEvent(1, 3), policy))
+	*now = now.Add(5 * time.Second)
+	assert.False(t, d.Allow(__createEvent(1, 3), policy))
+	assert.False(t, d.Allow(__createEvent(1, 4), policy))
+	assert.True(t, d.Allow(__createEvent(2, 3), policy))
+	assert.Empty(t, d.Expire(nil), "window not closed")
+
+	*now = now.Add(5 * time.Second)
+	held := d.Expire(nil)
+	assert.Len(t, held, 1)
+	assert.Equal(t, uint8(4), held[0].Event.Flow.ProtoInfo.TCP.State, "newest update held")
+	assert.Equal(t, policy, held[0].Policy)
+	assert.Empty(t, d.Expire(nil))
+
+	*now = now.Add(time.Second)
+	assert.False(t, d.Allow(__createEvent(1, 5), policy))
+	*now = now.Add(10 * time.Second)
+	assert.True(t, d.Allow(__createEvent(1, 6), policy), "held update superseded")
+	assert.Empty(t, d.Expire(nil))
+
+	assert.Equal(t, Stats{Coalesced: 2}, d.Stats())
+}
+
+func expireReturnsHeldUpdatesOfOwnedFlows(t *testing.T) {
+	d, now := __newDeduplicator(Config{Window: 10 * time.Second})
+
+	for id := uint32(1); id <= 3; id++ {
+		assert.True(t, d.Allow(__createEvent(id, 3), record.Policy{}))
+		assert.False(t, d.Allow(__createEvent(id, 4), record.Policy{}))
+	}
+
+	*now = now.Add(10 * time.Second)
+	held := d.Expire(func(flow uint32) bool { return flow == 2 })
+	assert.Len(t, held, 1)
+	assert.Equal(t, uint32(2), held[0].Event.Flow.ID)
+
+	assert.Len(t, d.Flush(), 2)
+	assert.Empty(t, d.Flush())
+	assert.Equal(t, Stats{}, d.Stats())
+}
+
+func allowRemovesFlowOnDestroy(t *testing.T) {
+	d, _ := __newDeduplicator(Config{StateChanges: true, Window: 10 * time.Second})
+
+	assert.True(t, d.Allow(__createEvent(1, 3), record.Policy{}))
+	assert.False(t, d.Allow(__createEvent(1, 4), record.Policy{}))
+
+	event := __createEvent(1, 7)
+	event.Type = conntrack.EventDestroy
+	assert.True(t, d.Allow(event, record.Policy{}))
+	assert.Zero(t, d.Len())
+	assert.Empty(t, d.Flush(), "held update dropped")
+	assert.Equal(t, Stats{Coalesced: 1}, d.Stats())
+
+	assert.True(t, d.Allow(__createEvent(1, 3), record.Policy{}), "reused flow ID")
+}
+
+func allowEvictsLeastRecentlyUsedFlow(t *testing.T) {
+	d, _ := __newDeduplicator(Config{StateChanges: true, MaxFlows: 2})
+
+	assert.True(t, d.Allow(__createEvent(1, 3), record.Policy{}))
+	assert.True(t, d.Allow(__createEvent(2, 3), record.Policy{}))
+	assert.False(t, d.Allow(__createEvent(1, 3), record.Policy{}))
+	assert.True(t, d.Allow(__createEvent(3, 3), record.Policy{}))
+	assert.Equal(t, 2, d.Len())
+
+	assert.True(t, d.Allow(__createEvent(2, 3), record.Policy{}))
+	assert.False(t, d.Allow(__createEvent(3, 3), record.Policy{}))
+}
+
+func TestDeduplicator(t *testing.T) {
+	t.Run("dedup.Allow suppresses unchanged updates", allowSuppressesUnchangedUpdates)
+	t.Run("dedup.Allow coalesces updates within window", allowCoalescesUpdatesWithinWindow)
+	t.Run("dedup.Allow evicts least recently used flow", allowEvictsLeastRecentlyUsedFlow)
+	t.Run("dedup.Allow removes flow on destroy", allowRemovesFlowOnDestroy)
+	t.Run("dedup.Expire returns held updates of owned flows", expireReturnsHeldUpdatesOfOwnedFlows)
+}
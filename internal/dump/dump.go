@@ -0,0 +1,169 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package dump
+
+import (
+	"cmp"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/ti-mo/conntrack"
+	"github.com/tschaefer/conntrackd/internal/filter"
+	"github.com/tschaefer/conntrackd/internal/geoip"
+	"github.com/tschaefer/conntrackd/internal/record"
+)
+
+// SortKeys are the keys records can be sorted by.
+var SortKeys = []string{"src", "dst", "sport", "dport", "proto", "state", "country", "packets", "bytes"}
+
+// GroupKeys are the keys records can be grouped by.
+var GroupKeys = []string{"dst", "country", "state"}
+
+// unknown is the group key of records without value.
+const unknown = "-"
+
+// Group summarizes the records sharing a key.
+type Group struct {
+	Key     string `json:"key"`
+	Flows   int    `json:"flows"`
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// Collect returns the records of the TCP and UDP flows logged by the filter,
+// enriched like the service does. Flows are decided as UPDATE events, like
+// the conntrack table snapshot after a reconnect. A nil filter logs all
+// flows.
+func Collect(flows []conntrack.Flow, f *filter.Filter, g *geoip.GeoIP) []*record.Event {
+	records := make([]*record.Event, 0, len(flows))
+	for i := range flows {
+		protocol := flows[i].TupleOrig.Proto.Protocol
+		if protocol != syscall.IPPROTO_TCP && protocol != syscall.IPPROTO_UDP {
+			continue
+		}
+
+		event := conntrack.Event{Type: conntrack.EventUpdate, Flow: &flows[i]}
+		geo := g.Lookup()
+
+		verdict := f.Decide(event, geo)
+		if !verdict.Log {
+			continue
+		}
+
+		e := record.NewEvent(event, geo)
+		e.Apply(record.Policy{Tags: verdict.Tags, Alert: verdict.Alert, Sinks: verdict.Sinks})
+		records = append(records, e)
+	}
+
+	return records
+}
+
+// Sort sorts the records by the key, addresses, ports, protocol, state and
+// country ascending, packets and bytes of both directions descending. Ties
+// keep their order.
+func Sort(records []*record.Event, key string) error {
+	var compare func(a, b *record.Event) int
+	switch key {
+	case "src":
+		compare = func(a, b *record.Event) int {
+			return cmp.Or(compareAddr(a.SrcAddr, b.SrcAddr), cmp.Compare(a.SrcPort, b.SrcPort))
+		}
+	case "dst":
+		compare = func(a, b *record.Event) int {
+			return cmp.Or(compareAddr(a.DstAddr, b.DstAddr), cmp.Compare(a.DstPort, b.DstPort))
+		}
+	case "sport":
+		compare = func(a, b *record.Event) int { return cmp.Compare(a.SrcPort, b.SrcPort) }
+	case "dport":
+		compare = func(a, b *record.Event) int { return cmp.Compare(a.DstPort, b.DstPort) }
+	case "proto":
+		compare = func(a, b *record.Event) int { return strings.Compare(a.Protocol, b.Protocol) }
+	case "state":
+		compare = func(a, b *record.Event) int { return strings.Compare(a.TCPState, b.TCPState) }
+	case "country":
+		compare = func(a, b *record.Event) int { return strings.Compare(a.DstCountry, b.DstCountry) }
+	case "packets":
+		compare = func(a, b *record.Event) int { return cmp.Compare(totalPackets(b), totalPackets(a)) }
+	case "bytes":
+		compare = func(a, b *record.Event) int { return cmp.Compare(totalBytes(b), totalBytes(a)) }
+	default:
+		return fmt.Errorf("invalid sort key %q, expected one of %s", key, strings.Join(SortKeys, ", "))
+	}
+
+	slices.SortStableFunc(records, compare)
+	return nil
+}
+
+// GroupBy groups the records by destination address, destination country or
+// TCP state. Groups are sorted by number of flows descending, then by key.
+func GroupBy(records []*record.Event, key string) ([]Group, error) {
+	var value func(e *record.Event) string
+	switch key {
+	case "dst":
+		value = func(e *record.Event) string { return e.DstAddr }
+	case "country":
+		value = func(e *record.Event) string { return e.DstCountry }
+	case "state":
+		value = func(e *record.Event) string { return e.TCPState }
+	default:
+		return nil, fmt.Errorf("invalid group key %q, expected one of %s", key, strings.Join(GroupKeys, ", "))
+	}
+
+	index := make(map[string]int)
+	var groups []Group
+	for _, e := range records {
+		k := value(e)
+		if k == "" {
+			k = unknown
+		}
+
+		i, ok := index[k]
+		if !ok {
+			i = len(groups)
+			index[k] = i
+			groups = append(groups, Group{Key: k})
+		}
+		groups[i].Flows++
+		groups[i].Packets += totalPackets(e)
+		groups[i].Bytes += totalBytes(e)
+	}
+
+	slices.SortFunc(groups, func(a, b Group) int {
+		if key == "dst" {
+			return cmp.Or(cmp.Compare(b.Flows, a.Flows), compareAddr(a.Key, b.Key))
+		}
+		return cmp.Or(cmp.Compare(b.Flows, a.Flows), strings.Compare(a.Key, b.Key))
+	})
+
+	return groups, nil
+}
+
+// compareAddr compares addresses numerically, invalid addresses last.
+func compareAddr(a, b string) int {
+	addrA, errA := netip.ParseAddr(a)
+	addrB, errB := netip.ParseAddr(b)
+	switch {
+	case errA != nil && errB != nil:
+		return strings.Compare(a, b)
+	case errA != nil:
+		return 1
+	case errB != nil:
+		return -1
+	}
+	return addrA.Compare(addrB)
+}
+
+// totalPackets returns the packets of both directions.
+func totalPackets(e *record.Event) uint64 {
+	return e.OrigPackets + e.ReplyPackets
+}
+
+// totalBytes returns the bytes of both directions.
+func totalBytes(e *record.Event) uint64 {
+	return e.OrigBytes + e.ReplyBytes
+}
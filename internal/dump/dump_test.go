@@ -0,0 +1,140 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package dump
+
+import (
+	"bytes"
+	"net/netip"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ti-mo/conntrack"
+	"github.com/tschaefer/conntrackd/internal/filter"
+	"github.com/tschaefer/conntrackd/internal/record"
+)
+
+func __createFlow(proto uint8, src, dst string, dport uint16, orig uint64) conntrack.Flow {
+	flow := conntrack.NewFlow(proto, 0, netip.MustParseAddr(src), netip.MustParseAddr(dst), 4711, dport, 60, 0)
+	flow.CountersOrig = conntrack.Counter{Packets: 1, Bytes: orig}
+	if proto == syscall.IPPROTO_TCP {
+		flow.ProtoInfo.TCP = &conntrack.ProtoInfoTCP{State: 3}
+	}
+	return flow
+}
+
+func __createRecords() []*record.Event {
+	return []*record.Event{
+		{Protocol: "TCP", SrcAddr: "10.0.0.9", DstAddr: "10.0.0.10", DstPort: 443, TCPState: "ESTABLISHED", OrigBytes: 100, DstCountry: "DE"},
+		{Protocol: "UDP", SrcAddr: "10.0.0.1", DstAddr: "9.9.9.9", DstPort: 53, OrigBytes: 50, ReplyBytes: 150},
+		{Protocol: "TCP", SrcAddr: "10.0.0.2", DstAddr: "10.0.0.10", DstPort: 22, TCPState: "TIME_WAIT", OrigBytes: 10, DstCountry: "DE"},
+	}
+}
+
+func collectAppliesFilter(t *testing.T) {
+	flows := []conntrack.Flow{
+		__createFlow(syscall.IPPROTO_TCP, "10.0.0.1", "10.0.0.2", 443, 100),
+		__createFlow(syscall.IPPROTO_UDP, "10.0.0.1", "10.0.0.3", 53, 50),
+		__createFlow(syscall.IPPROTO_ICMP, "10.0.0.1", "10.0.0.4", 0, 10),
+	}
+	f, err := filter.NewFilter([]string{"tag web destination.port == 443", "drop protocol == 'UDP'"})
+	require.NoError(t, err)
+
+	records := Collect(flows, f, nil)
+	require.Len(t, records, 1)
+	assert.Equal(t, "UPDATE", records[0].Type)
+	assert.Equal(t, "ESTABLISHED", records[0].TCPState)
+	assert.Equal(t, []string{"web"}, records[0].Tags)
+	assert.NotEmpty(t, records[0].CommunityID)
+
+	assert.Len(t, Collect(flows, nil, nil), 2)
+}
+
+func sortSortsByKey(t *testing.T) {
+	records := __createRecords()
+
+	require.NoError(t, Sort(records, "src"))
+	assert.Equal(t, "10.0.0.1", records[0].SrcAddr)
+	assert.Equal(t, "10.0.0.9", records[2].SrcAddr)
+
+	require.NoError(t, Sort(records, "dst"))
+	assert.Equal(t, "9.9.9.9", records[0].DstAddr)
+	assert.Equal(t, uint16(22), records[1].DstPort)
+
+	require.NoError(t, Sort(records, "bytes"))
+	assert.Equal(t, "UDP", records[0].Protocol)
+	assert.Equal(t, uint16(22), records[2].DstPort)
+
+	assert.EqualError(t, Sort(records, "flow"), `invalid sort key "flow", expected one of `+strings.Join(SortKeys, ", "))
+}
+
+func groupByGroupsRecords(t *testing.T) {
+	groups, err := GroupBy(__createRecords(), "dst")
+	require.NoError(t, err)
+	assert.Equal(t, []Group{
+		{Key: "10.0.0.10", Flows: 2, Packets: 0, Bytes: 110},
+		{Key: "9.9.9.9", Flows: 1, Packets: 0, Bytes: 200},
+	}, groups)
+
+	groups, err = GroupBy(__createRecords(), "state")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-", "ESTABLISHED", "TIME_WAIT"}, []string{groups[0].Key, groups[1].Key, groups[2].Key})
+
+	_, err = GroupBy(__createRecords(), "port")
+	assert.Error(t, err)
+}
+
+func writeRecordsFormats(t *testing.T) {
+	records := __createRecords()[:2]
+	records[0].Tags = []string{"web", "internal"}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteRecords(&buf, "table", records))
+	assert.Equal(t, `PROTO  SOURCE      DESTINATION    STATE        PACKETS  BYTES  COUNTRY  TAGS
+TCP    10.0.0.9:0  10.0.0.10:443  ESTABLISHED  0        100    DE       web,internal
+UDP    10.0.0.1:0  9.9.9.9:53     -            0        200    -        -
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, WriteRecords(&buf, "json", records))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[1], `"dst_addr":"9.9.9.9"`)
+
+	buf.Reset()
+	require.NoError(t, WriteRecords(&buf, "csv", records))
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, strings.Join(csvColumns, ","), lines[0])
+	assert.Equal(t, `TCP,10.0.0.9,0,10.0.0.10,443,ESTABLISHED,0,100,0,0,,DE,0,0,,"web,internal"`, lines[1])
+
+	assert.Error(t, WriteRecords(&buf, "yaml", records))
+}
+
+func writeGroupsFormats(t *testing.T) {
+	groups := []Group{{Key: "DE", Flows: 2, Packets: 4, Bytes: 110}}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteGroups(&buf, "table", "country", groups))
+	assert.Equal(t, "COUNTRY  FLOWS  PACKETS  BYTES\nDE       2      4        110\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, WriteGroups(&buf, "json", "country", groups))
+	assert.Equal(t, `{"key":"DE","flows":2,"packets":4,"bytes":110}`+"\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, WriteGroups(&buf, "csv", "country", groups))
+	assert.Equal(t, "key,flows,packets,bytes\nDE,2,4,110\n", buf.String())
+}
+
+func TestDump(t *testing.T) {
+	t.Run("dump.Collect applies filter", collectAppliesFilter)
+	t.Run("dump.Sort sorts by key", sortSortsByKey)
+	t.Run("dump.GroupBy groups records", groupByGroupsRecords)
+	t.Run("dump.WriteRecords formats", writeRecordsFormats)
+	t.Run("dump.WriteGroups formats", writeGroupsFormats)
+}
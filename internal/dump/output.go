@@ -0,0 +1,124 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package dump
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tschaefer/conntrackd/internal/record"
+)
+
+// Formats are the output formats.
+var Formats = []string{"table", "json", "csv"}
+
+// csvColumns are the columns of records in CSV format, named like the JSON
+// fields.
+var csvColumns = []string{
+	"prot", "src_addr", "src_port", "dst_addr", "dst_port", "tcp_state",
+	"orig_packets", "orig_bytes", "reply_packets", "reply_bytes",
+	"src_country", "dst_country", "src_asn", "dst_asn", "community_id", "tags",
+}
+
+// groupTitles are the table titles of the group keys.
+var groupTitles = map[string]string{
+	"dst":     "DESTINATION",
+	"country": "COUNTRY",
+	"state":   "STATE",
+}
+
+// WriteRecords writes the records as table, as JSON records one per line
+// like the stream sink or as CSV with header.
+func WriteRecords(w io.Writer, format string, records []*record.Event) error {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, "PROTO\tSOURCE\tDESTINATION\tSTATE\tPACKETS\tBYTES\tCOUNTRY\tTAGS")
+		for _, e := range records {
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n",
+				e.Protocol,
+				net.JoinHostPort(e.SrcAddr, strconv.Itoa(int(e.SrcPort))),
+				net.JoinHostPort(e.DstAddr, strconv.Itoa(int(e.DstPort))),
+				orUnknown(e.TCPState), totalPackets(e), totalBytes(e),
+				orUnknown(e.DstCountry), orUnknown(strings.Join(e.Tags, ",")),
+			)
+		}
+		return tw.Flush()
+	case "json":
+		encoder := json.NewEncoder(w)
+		for _, e := range records {
+			if err := encoder.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write(csvColumns)
+		for _, e := range records {
+			_ = cw.Write([]string{
+				e.Protocol, e.SrcAddr, strconv.Itoa(int(e.SrcPort)), e.DstAddr, strconv.Itoa(int(e.DstPort)), e.TCPState,
+				formatUint(e.OrigPackets), formatUint(e.OrigBytes), formatUint(e.ReplyPackets), formatUint(e.ReplyBytes),
+				e.SrcCountry, e.DstCountry, formatUint(uint64(e.SrcASN)), formatUint(uint64(e.DstASN)),
+				e.CommunityID, strings.Join(e.Tags, ","),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("invalid output format %q, expected one of %s", format, strings.Join(Formats, ", "))
+	}
+}
+
+// WriteGroups writes the groups of the key as table, as JSON records one per
+// line or as CSV with header.
+func WriteGroups(w io.Writer, format string, key string, groups []Group) error {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintf(tw, "%s\tFLOWS\tPACKETS\tBYTES\n", groupTitles[key])
+		for _, group := range groups {
+			_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", group.Key, group.Flows, group.Packets, group.Bytes)
+		}
+		return tw.Flush()
+	case "json":
+		encoder := json.NewEncoder(w)
+		for _, group := range groups {
+			if err := encoder.Encode(group); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"key", "flows", "packets", "bytes"})
+		for _, group := range groups {
+			_ = cw.Write([]string{group.Key, strconv.Itoa(group.Flows), formatUint(group.Packets), formatUint(group.Bytes)})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("invalid output format %q, expected one of %s", format, strings.Join(Formats, ", "))
+	}
+}
+
+// orUnknown returns the value or a dash if empty.
+func orUnknown(value string) string {
+	if value == "" {
+		return unknown
+	}
+	return value
+}
+
+// formatUint formats a number in decimal.
+func formatUint(n uint64) string {
+	return strconv.FormatUint(n, 10)
+}
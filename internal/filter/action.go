@@ -0,0 +1,203 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Actions are the valid rule actions
+var Actions = []string{
+	actionLog.String(), actionDrop.String(), actionTag.String(),
+	actionSample.String(), actionAlert.String(), actionRoute.String(),
+}
+
+// DefaultActions are the valid default actions
+var DefaultActions = []string{actionLog.String(), actionDrop.String()}
+
+// action represents the action to take when a rule matches
+type action int
+
+const (
+	actionLog action = iota
+	actionDrop
+	actionTag
+	actionSample
+	actionAlert
+	actionRoute
+)
+
+func (a action) String() string {
+	switch a {
+	case actionLog:
+		return "log"
+	case actionDrop:
+		return "drop"
+	case actionTag:
+		return "tag"
+	case actionSample:
+		return "sample"
+	case actionAlert:
+		return "alert"
+	case actionRoute:
+		return "route"
+	default:
+		return "unknown"
+	}
+}
+
+// hasArgument reports whether the action takes an argument
+func (a action) hasArgument() bool {
+	return a == actionTag || a == actionSample || a == actionRoute
+}
+
+// isTerminal reports whether the action ends the evaluation, tag rules
+// continue with the next rule
+func (a action) isTerminal() bool {
+	return a != actionTag
+}
+
+// ruleAction is the action of a rule with its argument
+type ruleAction struct {
+	action
+	tag   string
+	rate  float64
+	sinks []string
+}
+
+// String returns the action in rule form, e.g. "sample 0.1"
+func (a ruleAction) String() string {
+	switch a.action {
+	case actionTag:
+		return a.action.String() + " " + a.tag
+	case actionSample:
+		return a.action.String() + " " + strconv.FormatFloat(a.rate, 'g', -1, 64)
+	case actionRoute:
+		return a.action.String() + " " + strings.Join(a.sinks, ",")
+	default:
+		return a.action.String()
+	}
+}
+
+// Verdict is the result of the evaluation of an event
+type Verdict struct {
+	// Matched is set if a rule decided, otherwise the default action applies
+	Matched bool
+	// Log is set if the event is recorded
+	Log bool
+	// Rule is the name of the deciding rule
+	Rule string
+	// Action is the action of the deciding rule in rule form
+	Action string
+	// Tags are the tags of the matching tag rules
+	Tags []string
+	// Alert is set by the alert action
+	Alert bool
+	// Sinks are the sinks of the route action, empty for all sinks
+	Sinks []string
+}
+
+// parseRuleString parses a rule string to extract action and CEL expression
+// Format: "<action> [argument] <expression>", e.g. "log <expression>" or
+// "sample 0.1 <expression>"
+func parseRuleString(ruleStr string) (ruleAction, string, error) {
+	ruleStr = strings.TrimSpace(ruleStr)
+
+	name, expr, _ := strings.Cut(ruleStr, " ")
+	act, err := parseAction(name)
+	if err != nil {
+		return ruleAction{}, "", err
+	}
+
+	text := act.String()
+	if act.hasArgument() {
+		argument, rest, _ := strings.Cut(strings.TrimSpace(expr), " ")
+		text += " " + argument
+		expr = rest
+	}
+
+	ruleAct, err := parseRuleAction(text)
+	if err != nil {
+		return ruleAction{}, "", err
+	}
+
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return ruleAction{}, "", fmt.Errorf("missing expression after '%s'", ruleAct)
+	}
+
+	return ruleAct, expr, nil
+}
+
+// parseRuleAction parses the action of a rule with its argument, e.g.
+// "tag web" or "route loki,syslog"
+func parseRuleAction(text string) (ruleAction, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return ruleAction{}, errInvalidAction
+	}
+
+	act, err := parseAction(fields[0])
+	if err != nil {
+		return ruleAction{}, err
+	}
+
+	if !act.hasArgument() {
+		if len(fields) > 1 {
+			return ruleAction{}, fmt.Errorf("unexpected argument %q after '%s'", fields[1], act)
+		}
+		return ruleAction{action: act}, nil
+	}
+	if len(fields) != 2 {
+		return ruleAction{}, fmt.Errorf("missing argument after '%s'", act)
+	}
+
+	ruleAct := ruleAction{action: act}
+	argument := fields[1]
+	switch act {
+	case actionTag:
+		ruleAct.tag = argument
+	case actionSample:
+		rate, err := strconv.ParseFloat(argument, 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return ruleAction{}, fmt.Errorf("invalid sample rate %q, expected a number greater than 0 and at most 1", argument)
+		}
+		ruleAct.rate = rate
+	case actionRoute:
+		for sink := range strings.SplitSeq(argument, ",") {
+			if sink == "" {
+				return ruleAction{}, fmt.Errorf("invalid sinks %q", argument)
+			}
+			ruleAct.sinks = append(ruleAct.sinks, sink)
+		}
+	}
+
+	return ruleAct, nil
+}
+
+// errInvalidAction is returned for unknown actions
+var errInvalidAction = fmt.Errorf("rule must start with one of %s", strings.Join(Actions, ", "))
+
+// parseAction parses the action name of a rule
+func parseAction(name string) (action, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "log":
+		return actionLog, nil
+	case "drop":
+		return actionDrop, nil
+	case "tag":
+		return actionTag, nil
+	case "sample":
+		return actionSample, nil
+	case "alert":
+		return actionAlert, nil
+	case "route":
+		return actionRoute, nil
+	default:
+		return 0, errInvalidAction
+	}
+}
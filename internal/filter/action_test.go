@@ -0,0 +1,125 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package filter
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRules_Actions(t *testing.T) {
+	tests := []struct {
+		rule   string
+		action string
+		expr   string
+	}{
+		{`tag web destination.port == 443`, "tag web", "destination.port == 443"},
+		{`SAMPLE 0.25 any`, "sample 0.25", "any"},
+		{`alert destination.port == 22`, "alert", "destination.port == 22"},
+		{`route loki,syslog protocol == "UDP"`, "route loki,syslog", `protocol == "UDP"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			rules, err := ParseRules([]string{tt.rule})
+			require.NoError(t, err)
+			assert.Equal(t, tt.action, rules[0].Action)
+			assert.Equal(t, tt.expr, rules[0].Expr)
+		})
+	}
+}
+
+func TestParseRules_InvalidActions(t *testing.T) {
+	tests := []struct {
+		rule string
+		err  string
+	}{
+		{`allow any`, "rule must start with one of log, drop, tag, sample, alert, route"},
+		{`tag`, "missing argument after 'tag'"},
+		{`tag web`, "missing expression after 'tag web'"},
+		{`sample 0 any`, `invalid sample rate "0", expected a number greater than 0 and at most 1`},
+		{`sample often any`, `invalid sample rate "often", expected a number greater than 0 and at most 1`},
+		{`route loki, any`, `invalid sinks "loki,"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			_, err := ParseRules([]string{tt.rule})
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+
+	_, err := NewFilterFromRules([]Rule{{Name: "r", Action: "alert now", Expr: "any", Source: "r"}}, Options{})
+	assert.ErrorContains(t, err, `unexpected argument "now" after 'alert'`)
+
+	_, err = NewFilterFromRules(nil, Options{Default: "alert"})
+	assert.EqualError(t, err, `invalid default action "alert", expected one of log, drop`)
+}
+
+func TestCELFilter_Decide(t *testing.T) {
+	filter, err := NewFilter([]string{
+		`tag web destination.port == 443`,
+		`tag tls destination.port == 443`,
+		`alert destination.port == 22`,
+		`route loki,syslog protocol == "UDP"`,
+		`sample 0.5 destination.port == 80`,
+		`drop destination.port == 53`,
+	})
+	require.NoError(t, err)
+	filter.random = func() float64 { return 0.7 }
+
+	tests := []struct {
+		name     string
+		proto    uint8
+		dport    uint16
+		expected Verdict
+	}{
+		{"tags and default", syscall.IPPROTO_TCP, 443, Verdict{Log: true, Action: "log", Tags: []string{"web", "tls"}}},
+		{"alert", syscall.IPPROTO_TCP, 22, Verdict{Matched: true, Log: true, Rule: "filter[2]", Action: "alert", Alert: true}},
+		{"route", syscall.IPPROTO_UDP, 443, Verdict{Matched: true, Log: true, Rule: "filter[3]", Action: "route loki,syslog", Tags: []string{"web", "tls"}, Sinks: []string{"loki", "syslog"}}},
+		{"sampled out", syscall.IPPROTO_TCP, 80, Verdict{Matched: true, Log: false, Rule: "filter[4]", Action: "sample 0.5"}},
+		{"drop", syscall.IPPROTO_TCP, 53, Verdict{Matched: true, Log: false, Rule: "filter[5]", Action: "drop"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := createEventWithAddrs(1, tt.proto, "10.0.0.1", "1.1.1.1", 1234, tt.dport)
+			assert.Equal(t, tt.expected, filter.Decide(event, nil))
+		})
+	}
+
+	filter.random = func() float64 { return 0.3 }
+	event := createEventWithAddrs(1, syscall.IPPROTO_TCP, "10.0.0.1", "1.1.1.1", 1234, 80)
+	assert.True(t, filter.Decide(event, nil).Log)
+
+	assert.Equal(t, uint64(2), filter.Matches()["filter[0]"])
+}
+
+func TestCELFilter_RouteToEnabledSinks(t *testing.T) {
+	rules, err := ParseRules([]string{`route loki any`})
+	require.NoError(t, err)
+
+	_, err = NewFilterFromRules(rules, Options{Sinks: []string{"journal"}})
+	assert.ErrorContains(t, err, `route to sink "loki" which is not enabled`)
+
+	_, err = NewFilterFromRules(rules, Options{Sinks: []string{"journal", "loki"}})
+	assert.NoError(t, err)
+}
+
+func TestIssues_TagRules(t *testing.T) {
+	filter, err := NewFilter([]string{
+		`tag all true`,
+		`tag dns destination.port == 53`,
+		`drop destination.port == 53`,
+		`log any`,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"filter[3]: unconditional rule is redundant with default action log",
+	}, issueStrings(filter))
+}
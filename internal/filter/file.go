@@ -0,0 +1,198 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package filter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RuleFileExtensions are the extensions of rule files loaded from a directory.
+var RuleFileExtensions = []string{".rules", ".yaml", ".yml"}
+
+// Rule represents a filter rule in source form.
+type Rule struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Action      string `yaml:"action"`
+	Expr        string `yaml:"expr"`
+
+	// Source is the origin of the rule, e.g. file and line.
+	Source string `yaml:"-"`
+}
+
+// String returns the rule in line form.
+func (r Rule) String() string {
+	return r.Action + " " + r.Expr
+}
+
+// ParseRules parses rule strings in line form. Rules are named by their index.
+func ParseRules(ruleStrings []string) ([]Rule, error) {
+	rules := make([]Rule, 0, len(ruleStrings))
+	for i, ruleStr := range ruleStrings {
+		act, expr, err := parseRuleString(ruleStr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse rule %d (%s): %w", i, ruleStr, err)
+		}
+
+		rules = append(rules, Rule{
+			Name:   fmt.Sprintf("filter[%d]", i),
+			Action: act.String(),
+			Expr:   expr,
+			Source: fmt.Sprintf("filter[%d]", i),
+		})
+	}
+
+	return rules, nil
+}
+
+// LoadRules loads rules from files or directories. Directories are read for
+// files with one of the RuleFileExtensions in lexical order. Files with a
+// .yaml or .yml extension hold a list of structured rules, all other files
+// hold one rule per line. Unnamed rules are named by their source, the path
+// of the file as given and the line, unique across directories.
+func LoadRules(paths []string) ([]Rule, error) {
+	var rules []Rule
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		files := []string{path}
+		if info.IsDir() {
+			files, err = ruleFiles(path)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, file := range files {
+			fileRules, err := loadRuleFile(file)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, fileRules...)
+		}
+	}
+
+	return rules, nil
+}
+
+// ruleFiles returns the rule files of a directory in lexical order.
+func ruleFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || !slices.Contains(RuleFileExtensions, filepath.Ext(entry.Name())) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, entry.Name()))
+	}
+
+	return files, nil
+}
+
+// loadRuleFile loads the rules of a single file.
+func loadRuleFile(file string) ([]Rule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	switch filepath.Ext(file) {
+	case ".yaml", ".yml":
+		return parseYAMLRules(file, data)
+	default:
+		return parseLineRules(file, data)
+	}
+}
+
+// parseLineRules parses one rule per line. Empty lines and lines starting
+// with '#' are ignored.
+func parseLineRules(file string, data []byte) ([]Rule, error) {
+	var rules []Rule
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		source := fmt.Sprintf("%s:%d", file, line)
+		act, expr, err := parseRuleString(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+
+		rules = append(rules, Rule{
+			Name:   source,
+			Action: act.String(),
+			Expr:   expr,
+			Source: source,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	return rules, nil
+}
+
+// parseYAMLRules parses a list of structured rules.
+func parseYAMLRules(file string, data []byte) ([]Rule, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if len(document.Content) == 0 {
+		return nil, nil
+	}
+
+	list := document.Content[0]
+	if list.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("%s:%d: expected list of rules", file, list.Line)
+	}
+
+	rules := make([]Rule, 0, len(list.Content))
+	for _, node := range list.Content {
+		source := fmt.Sprintf("%s:%d", file, node.Line)
+
+		var rule Rule
+		if err := node.Decode(&rule); err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+		if _, err := parseRuleAction(rule.Action); err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+		if strings.TrimSpace(rule.Expr) == "" {
+			return nil, fmt.Errorf("%s: missing expression", source)
+		}
+
+		if rule.Name == "" {
+			rule.Name = source
+		}
+		rule.Expr = strings.TrimSpace(rule.Expr)
+		rule.Source = source
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,147 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package filter
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeRuleFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestLoadRules_LineFile(t *testing.T) {
+	dir := t.TempDir()
+	path := writeRuleFile(t, dir, "dns.rules", `# DNS noise
+drop destination.port == 53
+
+  # catch all
+log any
+`)
+
+	rules, err := LoadRules([]string{path})
+	require.NoError(t, err)
+	assert.Equal(t, []Rule{
+		{Name: path + ":2", Action: "drop", Expr: "destination.port == 53", Source: path + ":2"},
+		{Name: path + ":5", Action: "log", Expr: "any", Source: path + ":5"},
+	}, rules)
+}
+
+func TestLoadRules_YAMLFile(t *testing.T) {
+	dir := t.TempDir()
+	path := writeRuleFile(t, dir, "web.yaml", `# web traffic
+- name: drop-google-dns
+  description: Public resolver
+  action: drop
+  expr: destination.address == "8.8.8.8"
+- action: log
+  expr: |
+    protocol == "TCP" &&
+    destination.port == 443
+`)
+
+	rules, err := LoadRules([]string{path})
+	require.NoError(t, err)
+	assert.Equal(t, []Rule{
+		{
+			Name:        "drop-google-dns",
+			Description: "Public resolver",
+			Action:      "drop",
+			Expr:        `destination.address == "8.8.8.8"`,
+			Source:      path + ":2",
+		},
+		{
+			Name:   path + ":6",
+			Action: "log",
+			Expr:   "protocol == \"TCP\" &&\ndestination.port == 443",
+			Source: path + ":6",
+		},
+	}, rules)
+
+	filter, err := NewFilterFromRules(rules, Options{})
+	require.NoError(t, err)
+
+	event := createEventWithAddrs(1, syscall.IPPROTO_TCP, "10.0.0.1", "1.1.1.1", 1234, 443)
+	matched, allow, rule := filter.Evaluate(event)
+	assert.True(t, matched)
+	assert.True(t, allow)
+	assert.Equal(t, path+":6", rule)
+}
+
+func TestLoadRules_Directory(t *testing.T) {
+	dir := t.TempDir()
+	writeRuleFile(t, dir, "20-catch-all.rules", "drop any\n")
+	writeRuleFile(t, dir, "10-web.yml", "- {name: web, action: log, expr: destination.port == 443}\n")
+	writeRuleFile(t, dir, "README", "not a rule\n")
+
+	rules, err := LoadRules([]string{dir})
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, "web", rules[0].Name)
+	assert.Equal(t, filepath.Join(dir, "20-catch-all.rules")+":1", rules[1].Name)
+}
+
+func TestLoadRules_SameFileNameInDirectories(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"a", "b"} {
+		require.NoError(t, os.Mkdir(filepath.Join(root, dir), 0o755))
+		writeRuleFile(t, filepath.Join(root, dir), "10-web.yaml", "- {action: log, expr: destination.port == 443}\n")
+	}
+
+	rules, err := LoadRules([]string{filepath.Join(root, "a"), filepath.Join(root, "b")})
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.NotEqual(t, rules[0].Name, rules[1].Name)
+
+	_, err = NewFilterFromRules(rules, Options{})
+	assert.NoError(t, err)
+}
+
+func TestLoadRules_ErrorsPointAtFileAndLine(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		err     string
+	}{
+		{"invalid action", "a.rules", "# comment\nallow any\n", "a.rules:2: rule must start with one of log, drop, tag, sample, alert, route"},
+		{"missing expression", "b.rules", "log any\ndrop \n", "b.rules:2: missing expression after 'drop'"},
+		{"invalid yaml action", "c.yaml", "- action: log\n  expr: any\n- action: allow\n  expr: any\n", "c.yaml:3: rule must start with one of log, drop, tag, sample, alert, route"},
+		{"missing yaml expression", "d.yaml", "- action: log\n", "d.yaml:1: missing expression"},
+		{"yaml not a list", "e.yaml", "action: log\n", "e.yaml:1: expected list of rules"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeRuleFile(t, dir, tt.file, tt.content)
+			_, err := LoadRules([]string{path})
+			assert.EqualError(t, err, filepath.Join(dir, tt.err))
+		})
+	}
+
+	path := writeRuleFile(t, dir, "f.rules", "log any\nlog destination.port == \n")
+	rules, err := LoadRules([]string{path})
+	require.NoError(t, err)
+	_, err = NewFilterFromRules(rules, Options{})
+	assert.ErrorContains(t, err, "failed to compile rule "+path+":2")
+}
+
+func TestNewFilterFromRules_DuplicateName(t *testing.T) {
+	_, err := NewFilterFromRules([]Rule{
+		{Name: "dns", Action: "drop", Expr: "destination.port == 53", Source: "a.yaml:1"},
+		{Name: "dns", Action: "log", Expr: "any", Source: "b.yaml:1"},
+	}, Options{})
+	assert.EqualError(t, err, `duplicate rule name "dns" in b.yaml:1, first defined in a.yaml:1`)
+}
@@ -6,128 +6,438 @@ package filter
 
 import (
 	"fmt"
+	"log/slog"
+	"math/rand/v2"
 	"net/netip"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/common/types"
 	"github.com/google/cel-go/common/types/ref"
 	"github.com/ti-mo/conntrack"
+	"github.com/tschaefer/conntrackd/internal/communityid"
+	"github.com/tschaefer/conntrackd/internal/geoip"
 )
 
+// errorLogInterval is the minimum interval between warnings about evaluation
+// errors of the same rule
+const errorLogInterval = time.Minute
+
+// ErrorPolicy is the handling of rule evaluation errors
+type ErrorPolicy string
+
+const (
+	// ErrorPolicySkip skips the rule and continues with the next one
+	ErrorPolicySkip ErrorPolicy = "skip"
+	// ErrorPolicyMatch treats the rule as matching, its action applies
+	ErrorPolicyMatch ErrorPolicy = "match"
+	// ErrorPolicyDrop drops the event
+	ErrorPolicyDrop ErrorPolicy = "drop"
+)
+
+// ErrorPolicies are the valid error policies
+var ErrorPolicies = []string{string(ErrorPolicySkip), string(ErrorPolicyMatch), string(ErrorPolicyDrop)}
+
+// ParseErrorPolicy parses an error policy, empty defaults to skip
+func ParseErrorPolicy(policy string) (ErrorPolicy, error) {
+	switch ErrorPolicy(strings.ToLower(policy)) {
+	case "", ErrorPolicySkip:
+		return ErrorPolicySkip, nil
+	case ErrorPolicyMatch:
+		return ErrorPolicyMatch, nil
+	case ErrorPolicyDrop:
+		return ErrorPolicyDrop, nil
+	default:
+		return "", fmt.Errorf("invalid error policy %q, expected one of %s", policy, strings.Join(ErrorPolicies, ", "))
+	}
+}
+
+// Options holds the options of a rule set
+type Options struct {
+	// Default is the action for events matching no rule, empty defaults
+	// to log
+	Default string
+	OnError ErrorPolicy
+	// Sets are the named sets usable by in_set, names are case-insensitive
+	Sets map[string]*Set
+	// Sinks are the names of the enabled sinks checked for route actions,
+	// nil skips the check
+	Sinks []string
+}
+
+// geoVariables are the CEL variables backed by GeoIP lookups
+var geoVariables = map[string]*cel.Type{
+	"source.country":      cel.StringType,
+	"source.city":         cel.StringType,
+	"source.asn":          cel.IntType,
+	"source.as_org":       cel.StringType,
+	"destination.country": cel.StringType,
+	"destination.city":    cel.StringType,
+	"destination.asn":     cel.IntType,
+	"destination.as_org":  cel.StringType,
+}
+
 // Filter represents a CEL-based filter
 type Filter struct {
-	rules []compiledRule
+	rules         []compiledRule
+	issues        []Issue
+	sets          map[string]*Set
+	usesGeo       bool
+	onError       ErrorPolicy
+	defaultAction action
+	random        func() float64
 }
 
 // compiledRule represents a compiled CEL filter rule
 type compiledRule struct {
-	action   action
+	rule     Rule
+	action   ruleAction
 	program  cel.Program
 	ruleText string
+	matches  *atomic.Uint64
+	errors   *atomic.Uint64
+	warned   *atomic.Int64
+	// guard and exact are the translation for the prefilter
+	guard [][]Condition
+	exact bool
+}
+
+// NewFilter creates a new CEL-based filter from rule strings
+func NewFilter(ruleStrings []string) (*Filter, error) {
+	rules, err := ParseRules(ruleStrings)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFilterFromRules(rules, Options{})
 }
 
-// action represents the action to take when a rule matches
-type action int
+// NewFilterFromRules creates a new CEL-based filter from rules
+func NewFilterFromRules(rules []Rule, options Options) (*Filter, error) {
+	onError, err := ParseErrorPolicy(string(options.OnError))
+	if err != nil {
+		return nil, err
+	}
 
-const (
-	actionLog action = iota
-	actionDrop
-)
+	defaultAction := actionLog
+	if options.Default != "" {
+		defaultAction, err = parseAction(options.Default)
+		if err != nil || !slices.Contains(DefaultActions, defaultAction.String()) {
+			return nil, fmt.Errorf("invalid default action %q, expected one of %s", options.Default, strings.Join(DefaultActions, ", "))
+		}
+	}
 
-func (a action) String() string {
-	switch a {
-	case actionLog:
-		return "log"
-	case actionDrop:
-		return "drop"
-	default:
-		return "unknown"
+	sets := make(map[string]*Set, len(options.Sets))
+	for name, set := range options.Sets {
+		sets[strings.ToLower(name)] = set
 	}
-}
 
-// NewFilter creates a new CEL-based filter from rule strings
-func NewFilter(ruleStrings []string) (*Filter, error) {
 	filter := &Filter{
-		rules: make([]compiledRule, 0, len(ruleStrings)),
+		rules:         make([]compiledRule, 0, len(rules)),
+		sets:          sets,
+		onError:       onError,
+		defaultAction: defaultAction,
+		random:        rand.Float64,
 	}
 
-	env, err := createCELEnvironment()
+	env, err := createCELEnvironment(sets)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create CEL environment: %w", err)
 	}
 
-	for i, ruleStr := range ruleStrings {
-		act, expr, err := parseRuleString(ruleStr)
+	checker := ruleSetChecker{defaultAction: defaultAction}
+	names := make(map[string]string, len(rules))
+	for _, rule := range rules {
+		ruleStr := rule.String()
+
+		if source, ok := names[rule.Name]; ok {
+			return nil, fmt.Errorf("duplicate rule name %q in %s, first defined in %s", rule.Name, rule.Source, source)
+		}
+		names[rule.Name] = rule.Source
+
+		act, err := parseRuleAction(rule.Action)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse rule %d (%s): %w", i, ruleStr, err)
+			return nil, fmt.Errorf("failed to parse rule %s (%s): %w", rule.Source, ruleStr, err)
+		}
+		for _, sink := range act.sinks {
+			if options.Sinks != nil && !slices.Contains(options.Sinks, sink) {
+				return nil, fmt.Errorf("failed to parse rule %s (%s): route to sink %q which is not enabled", rule.Source, ruleStr, sink)
+			}
 		}
 
+		expr := rule.Expr
 		if expr == "any" {
 			expr = "true"
 		}
 
 		ast, issues := env.Compile(expr)
 		if issues != nil && issues.Err() != nil {
-			return nil, fmt.Errorf("failed to compile rule %d (%s): %w", i, ruleStr, issues.Err())
+			return nil, fmt.Errorf("failed to compile rule %s (%s): %w", rule.Source, ruleStr, issues.Err())
+		}
+		if err := checkSetReferences(ast, sets); err != nil {
+			return nil, fmt.Errorf("failed to compile rule %s (%s): %w", rule.Source, ruleStr, err)
 		}
 
-		program, err := env.Program(ast)
+		program, err := env.Program(ast, cel.CustomDecorator(foldNetLiterals))
 		if err != nil {
-			return nil, fmt.Errorf("failed to create program for rule %d (%s): %w", i, ruleStr, err)
+			return nil, fmt.Errorf("failed to create program for rule %s (%s): %w", rule.Source, ruleStr, err)
+		}
+
+		checker.check(rule, act, ast)
+		guard, exact := prefilterGuard(ast)
+
+		for _, reference := range ast.NativeRep().ReferenceMap() {
+			if _, ok := geoVariables[reference.Name]; ok {
+				filter.usesGeo = true
+			}
 		}
 
 		filter.rules = append(filter.rules, compiledRule{
+			rule:     rule,
 			action:   act,
 			program:  program,
 			ruleText: ruleStr,
+			matches:  new(atomic.Uint64),
+			errors:   new(atomic.Uint64),
+			warned:   new(atomic.Int64),
+			guard:    guard,
+			exact:    exact,
 		})
 	}
+	filter.issues = checker.issues
 
 	return filter, nil
 }
 
-// Evaluate evaluates the filter against an event
-// Returns: (matched bool, shouldLog bool, matchedRuleIndex int)
-// If no rule matches, returns (false, true, -1) for log-by-default policy
-func (f *Filter) Evaluate(event conntrack.Event) (bool, bool, int) {
-	if f == nil || len(f.rules) == 0 {
-		return false, true, -1
+// Issues returns the findings of the static checks of the rule set: unknown
+// network categories, invalid CIDR literals, non-bool expressions,
+// unreachable and shadowed rules
+func (f *Filter) Issues() []Issue {
+	if f == nil {
+		return nil
 	}
 
-	ctx := createEventContext(event)
+	return f.issues
+}
 
-	for i, compiledRule := range f.rules {
-		result, _, err := compiledRule.program.Eval(ctx)
-		if err != nil {
-			continue
+// UsesGeo reports whether a rule references GeoIP variables
+func (f *Filter) UsesGeo() bool {
+	if f == nil {
+		return false
+	}
+
+	return f.usesGeo
+}
+
+// Evaluate evaluates the filter against an event without GeoIP data
+// Returns: (matched bool, shouldLog bool, matchedRuleName string)
+// If no rule matches, returns (false, shouldLog, "") according to the default
+// action, log if the filter is nil
+func (f *Filter) Evaluate(event conntrack.Event) (bool, bool, string) {
+	return f.EvaluateLookup(event, nil)
+}
+
+// EvaluateLookup evaluates the filter against an event, GeoIP variables are
+// looked up lazily with geo when a rule references them. Returns the same as
+// Evaluate.
+func (f *Filter) EvaluateLookup(event conntrack.Event, geo *geoip.Lookup) (bool, bool, string) {
+	verdict := f.Decide(event, geo)
+	return verdict.Matched, verdict.Log, verdict.Rule
+}
+
+// Decide evaluates the filter against an event and returns the verdict of
+// the first matching rule with a terminal action, tags of matching tag rules
+// are collected on the way. If no such rule matches, the default action
+// applies, log if the filter is nil.
+func (f *Filter) Decide(event conntrack.Event, geo *geoip.Lookup) Verdict {
+	if f == nil {
+		return Verdict{Log: true}
+	}
+
+	var verdict Verdict
+	if len(f.rules) > 0 {
+		ctx := createEventContext(event, geo)
+
+		for _, compiledRule := range f.rules {
+			result, _, err := compiledRule.program.Eval(ctx)
+			if err != nil {
+				compiledRule.evaluationFailed(err)
+
+				switch f.onError {
+				case ErrorPolicyMatch:
+					result = types.True
+				case ErrorPolicyDrop:
+					verdict.Matched, verdict.Rule, verdict.Action = true, compiledRule.rule.Name, actionDrop.String()
+					return verdict
+				default:
+					continue
+				}
+			}
+
+			if result != types.True {
+				continue
+			}
+
+			compiledRule.matches.Add(1)
+			if f.apply(compiledRule.action, &verdict) {
+				verdict.Matched, verdict.Rule = true, compiledRule.rule.Name
+				return verdict
+			}
 		}
+	}
+
+	verdict.Log = f.defaultAction == actionLog
+	verdict.Action = f.defaultAction.String()
 
-		if result == types.True {
-			shouldLog := compiledRule.action == actionLog
-			return true, shouldLog, i
+	return verdict
+}
+
+// apply applies the action of a matching rule to the verdict. Returns true
+// if the action is terminal.
+func (f *Filter) apply(act ruleAction, verdict *Verdict) bool {
+	switch act.action {
+	case actionTag:
+		if !slices.Contains(verdict.Tags, act.tag) {
+			verdict.Tags = append(verdict.Tags, act.tag)
 		}
+		return false
+	case actionSample:
+		verdict.Log = f.random() < act.rate
+	case actionAlert:
+		verdict.Log, verdict.Alert = true, true
+	case actionRoute:
+		verdict.Log, verdict.Sinks = true, act.sinks
+	default:
+		verdict.Log = act.action == actionLog
+	}
+	verdict.Action = act.String()
+
+	return true
+}
+
+// Sets returns the named sets of the filter
+func (f *Filter) Sets() map[string]*Set {
+	if f == nil {
+		return nil
+	}
+
+	return f.sets
+}
+
+// Default returns the action for events matching no rule
+func (f *Filter) Default() string {
+	if f == nil {
+		return actionLog.String()
 	}
 
-	return false, true, -1
+	return f.defaultAction.String()
 }
 
-// createCELEnvironment creates a CEL environment with custom functions
-func createCELEnvironment() (*cel.Env, error) {
-	return cel.NewEnv(
+// OnError returns the error policy of the filter
+func (f *Filter) OnError() ErrorPolicy {
+	if f == nil {
+		return ErrorPolicySkip
+	}
+
+	return f.onError
+}
+
+// Errors returns the number of evaluation errors per rule name
+func (f *Filter) Errors() map[string]uint64 {
+	errors := make(map[string]uint64)
+	if f == nil {
+		return errors
+	}
+
+	for _, compiledRule := range f.rules {
+		errors[compiledRule.rule.Name] = compiledRule.errors.Load()
+	}
+
+	return errors
+}
+
+// evaluationFailed counts an evaluation error of the rule. The first error is
+// logged with the rule text, further errors at most once per
+// errorLogInterval.
+func (r *compiledRule) evaluationFailed(err error) {
+	count := r.errors.Add(1)
+
+	now := time.Now().UnixNano()
+	last := r.warned.Load()
+	if count > 1 && now-last < int64(errorLogInterval) {
+		return
+	}
+	if !r.warned.CompareAndSwap(last, now) {
+		return
+	}
+
+	slog.Warn("Filter rule evaluation failed.",
+		"rule", r.rule.Name, "source", r.rule.Source, "text", r.ruleText,
+		"error", err, "errors", count,
+	)
+}
+
+// Rules returns the rules of the filter in evaluation order
+func (f *Filter) Rules() []Rule {
+	if f == nil {
+		return nil
+	}
+
+	rules := make([]Rule, 0, len(f.rules))
+	for _, compiledRule := range f.rules {
+		rules = append(rules, compiledRule.rule)
+	}
+
+	return rules
+}
+
+// Matches returns the number of events matched per rule name
+func (f *Filter) Matches() map[string]uint64 {
+	matches := make(map[string]uint64)
+	if f == nil {
+		return matches
+	}
+
+	for _, compiledRule := range f.rules {
+		matches[compiledRule.rule.Name] = compiledRule.matches.Load()
+	}
+
+	return matches
+}
+
+// createCELEnvironment creates a CEL environment with custom functions, in_set
+// looks up the given sets
+func createCELEnvironment(sets map[string]*Set) (*cel.Env, error) {
+	options := netFunctions()
+	for name, celType := range geoVariables {
+		options = append(options, cel.Variable(name, celType))
+	}
+
+	return cel.NewEnv(append(options,
 		cel.Variable("event.type", cel.StringType),
 		cel.Variable("protocol", cel.StringType),
 		cel.Variable("source.address", cel.StringType),
 		cel.Variable("destination.address", cel.StringType),
+		cel.Variable("source.ip", IPType),
+		cel.Variable("destination.ip", IPType),
 		cel.Variable("source.port", cel.IntType),
 		cel.Variable("destination.port", cel.IntType),
+		cel.Variable("community_id", cel.StringType),
+		cel.Variable("mark", cel.IntType),
 
 		cel.Function("is_network",
 			cel.Overload("is_network_string_string",
 				[]*cel.Type{cel.StringType, cel.StringType},
 				cel.BoolType,
 				cel.BinaryBinding(isNetworkFunc)),
+			cel.Overload("is_network_ip_string",
+				[]*cel.Type{IPType, cel.StringType},
+				cel.BoolType,
+				cel.BinaryBinding(isNetworkFunc)),
 		),
 		cel.Function("in_cidr",
 			cel.Overload("in_cidr_string_string",
@@ -141,23 +451,43 @@ func createCELEnvironment() (*cel.Env, error) {
 				cel.BoolType,
 				cel.FunctionBinding(inRangeFunc)),
 		),
-	)
+		cel.Function("in_set",
+			cel.Overload("in_set_string_string",
+				[]*cel.Type{cel.StringType, cel.StringType},
+				cel.BoolType,
+				cel.BinaryBinding(inSetFunc(sets))),
+			cel.Overload("in_set_ip_string",
+				[]*cel.Type{IPType, cel.StringType},
+				cel.BoolType,
+				cel.BinaryBinding(inSetFunc(sets))),
+			cel.Overload("in_set_int_string",
+				[]*cel.Type{cel.IntType, cel.StringType},
+				cel.BoolType,
+				cel.BinaryBinding(inSetFunc(sets))),
+		),
+	)...)
 }
 
 // isNetworkFunc checks if an IP address belongs to a network category
 func isNetworkFunc(lhs ref.Val, rhs ref.Val) ref.Val {
-	ipStr, ok := lhs.(types.String)
-	if !ok {
-		return types.NewErr("invalid IP address type")
-	}
 	network, ok := rhs.(types.String)
 	if !ok {
 		return types.NewErr("invalid network type")
 	}
 
-	ip, err := netip.ParseAddr(string(ipStr))
-	if err != nil {
-		return types.Bool(false)
+	var ip netip.Addr
+	switch value := lhs.(type) {
+	case IP:
+		ip = value.Unmap()
+	case types.String:
+		var err error
+		ip, err = netip.ParseAddr(string(value))
+		if err != nil {
+			return types.Bool(false)
+		}
+		ip = ip.Unmap()
+	default:
+		return types.NewErr("invalid IP address type")
 	}
 
 	isLocal := ip.IsLoopback() || ip.IsLinkLocalUnicast()
@@ -200,7 +530,40 @@ func inCIDRFunc(lhs ref.Val, rhs ref.Val) ref.Val {
 		return types.Bool(false)
 	}
 
-	return types.Bool(prefix.Contains(ip))
+	return types.Bool(prefix.Contains(ip.Unmap()))
+}
+
+// inSetFunc returns a function checking if an IP address or port is in a
+// named set
+func inSetFunc(sets map[string]*Set) func(lhs ref.Val, rhs ref.Val) ref.Val {
+	return func(lhs ref.Val, rhs ref.Val) ref.Val {
+		name, ok := rhs.(types.String)
+		if !ok {
+			return types.NewErr("invalid set name type")
+		}
+		set, ok := sets[strings.ToLower(string(name))]
+		if !ok {
+			return types.NewErr("unknown set %q", string(name))
+		}
+
+		switch value := lhs.(type) {
+		case IP:
+			return types.Bool(set.ContainsAddr(value.Addr))
+		case types.String:
+			ip, err := netip.ParseAddr(string(value))
+			if err != nil {
+				return types.Bool(false)
+			}
+			return types.Bool(set.ContainsAddr(ip))
+		case types.Int:
+			if value < 0 || value > 65535 {
+				return types.Bool(false)
+			}
+			return types.Bool(set.ContainsPort(uint16(value)))
+		default:
+			return types.NewErr("invalid set value type")
+		}
+	}
 }
 
 // inRangeFunc checks if a value is in a range (inclusive)
@@ -225,8 +588,10 @@ func inRangeFunc(args ...ref.Val) ref.Val {
 	return types.Bool(val >= min && val <= max)
 }
 
-// createEventContext creates a CEL evaluation context from a conntrack event
-func createEventContext(event conntrack.Event) map[string]any {
+// createEventContext creates a CEL evaluation context from a conntrack event.
+// Address strings, Community ID and GeoIP variables are functions evaluated
+// only if a rule references them.
+func createEventContext(event conntrack.Event, geo *geoip.Lookup) map[string]any {
 	var eventType string
 	switch event.Type {
 	case conntrack.EventNew:
@@ -245,36 +610,42 @@ func createEventContext(event conntrack.Event) map[string]any {
 		protocol = "UDP"
 	}
 
+	src, dst := event.Flow.TupleOrig.IP.SourceAddress, event.Flow.TupleOrig.IP.DestinationAddress
+
 	return map[string]any{
 		"event.type":          eventType,
 		"protocol":            protocol,
-		"source.address":      event.Flow.TupleOrig.IP.SourceAddress.String(),
-		"destination.address": event.Flow.TupleOrig.IP.DestinationAddress.String(),
+		"source.address":      func() any { return src.String() },
+		"destination.address": func() any { return dst.String() },
+		"source.ip":           IP{src},
+		"destination.ip":      IP{dst},
 		"source.port":         int64(event.Flow.TupleOrig.Proto.SourcePort),
 		"destination.port":    int64(event.Flow.TupleOrig.Proto.DestinationPort),
+		"community_id":        func() any { return communityid.FlowHash(event.Flow) },
+		"mark":                int64(event.Flow.Mark),
+		"source.country":      func() any { return geoLocation(geo, src).Country },
+		"source.city":         func() any { return geoLocation(geo, src).City },
+		"source.asn":          func() any { return int64(geoASN(geo, src).Number) },
+		"source.as_org":       func() any { return geoASN(geo, src).Organization },
+		"destination.country": func() any { return geoLocation(geo, dst).Country },
+		"destination.city":    func() any { return geoLocation(geo, dst).City },
+		"destination.asn":     func() any { return int64(geoASN(geo, dst).Number) },
+		"destination.as_org":  func() any { return geoASN(geo, dst).Organization },
 	}
 }
 
-// parseRuleString parses a rule string to extract action and CEL expression
-// Format: "log <expression>" or "drop <expression>"
-func parseRuleString(ruleStr string) (action, string, error) {
-	ruleStr = strings.TrimSpace(ruleStr)
-
-	if strings.HasPrefix(strings.ToLower(ruleStr), "log ") {
-		expr := strings.TrimSpace(ruleStr[4:])
-		if expr == "" {
-			return 0, "", fmt.Errorf("missing expression after 'log'")
-		}
-		return actionLog, expr, nil
+// geoLocation returns the location of an address, empty if unknown
+func geoLocation(geo *geoip.Lookup, addr netip.Addr) geoip.Location {
+	if location := geo.Location(addr); location != nil {
+		return *location
 	}
+	return geoip.Location{}
+}
 
-	if strings.HasPrefix(strings.ToLower(ruleStr), "drop ") {
-		expr := strings.TrimSpace(ruleStr[5:])
-		if expr == "" {
-			return 0, "", fmt.Errorf("missing expression after 'drop'")
-		}
-		return actionDrop, expr, nil
+// geoASN returns the autonomous system of an address, empty if unknown
+func geoASN(geo *geoip.Lookup, addr netip.Addr) geoip.ASN {
+	if asn := geo.ASN(addr); asn != nil {
+		return *asn
 	}
-
-	return 0, "", fmt.Errorf("rule must start with 'log' or 'drop'")
+	return geoip.ASN{}
 }
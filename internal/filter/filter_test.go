@@ -5,7 +5,10 @@ Licensed under the MIT License, see LICENSE file in the project root for details
 package filter
 
 import (
+	"bytes"
+	"log/slog"
 	"net/netip"
+	"strings"
 	"syscall"
 	"testing"
 
@@ -201,6 +204,33 @@ func TestCEL_PortPredicate(t *testing.T) {
 	}
 }
 
+func TestCEL_CommunityIDPredicate(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		srcIP    string
+		dstIP    string
+		srcPort  uint16
+		dstPort  uint16
+		expected bool
+	}{
+		{"match", `log community_id == "1:LQU9qZlK+B5F3KDmev6m5PMibrg="`, "128.232.110.120", "66.35.250.204", 34855, 80, true},
+		{"match reverse", `log community_id == "1:LQU9qZlK+B5F3KDmev6m5PMibrg="`, "66.35.250.204", "128.232.110.120", 80, 34855, true},
+		{"no match", `log community_id == "1:LQU9qZlK+B5F3KDmev6m5PMibrg="`, "128.232.110.120", "66.35.250.204", 34856, 80, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewFilter([]string{tt.rule})
+			require.NoError(t, err)
+
+			event := createEventWithAddrs(1, syscall.IPPROTO_TCP, tt.srcIP, tt.dstIP, tt.srcPort, tt.dstPort)
+			matched, _, _ := filter.Evaluate(event)
+			assert.Equal(t, tt.expected, matched)
+		})
+	}
+}
+
 func TestCEL_AnyPredicate(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -341,41 +371,41 @@ func TestCELFilter_Evaluate(t *testing.T) {
 	require.NoError(t, err)
 
 	tests := []struct {
-		name         string
-		event        conntrack.Event
-		matched      bool
-		allow        bool
-		matchedIndex int
+		name        string
+		event       conntrack.Event
+		matched     bool
+		allow       bool
+		matchedRule string
 	}{
 		{
 			"first rule denies",
 			createEventWithAddrs(1, syscall.IPPROTO_TCP, "10.0.0.1", "8.8.8.8", 1234, 80),
 			true,
 			false,
-			0,
+			"filter[0]",
 		},
 		{
 			"second rule allows",
 			createEventWithAddrs(1, syscall.IPPROTO_TCP, "10.0.0.1", "1.1.1.1", 1234, 80),
 			true,
 			true,
-			1,
+			"filter[1]",
 		},
 		{
 			"no match allows by default",
 			createEventWithAddrs(1, syscall.IPPROTO_UDP, "10.0.0.1", "192.168.1.1", 1234, 80),
 			false,
 			true,
-			-1,
+			"",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			matched, allow, matchedIndex := filter.Evaluate(tt.event)
+			matched, allow, matchedRule := filter.Evaluate(tt.event)
 			assert.Equal(t, tt.matched, matched)
 			assert.Equal(t, tt.allow, allow)
-			assert.Equal(t, tt.matchedIndex, matchedIndex)
+			assert.Equal(t, tt.matchedRule, matchedRule)
 		})
 	}
 }
@@ -385,10 +415,10 @@ func TestCELFilter_EmptyAllowsByDefault(t *testing.T) {
 	require.NoError(t, err)
 
 	event := createEventWithAddrs(1, syscall.IPPROTO_TCP, "10.0.0.1", "8.8.8.8", 1234, 80)
-	matched, allow, matchedIndex := filter.Evaluate(event)
+	matched, allow, matchedRule := filter.Evaluate(event)
 	assert.False(t, matched)
 	assert.True(t, allow)
-	assert.Equal(t, -1, matchedIndex)
+	assert.Empty(t, matchedRule)
 }
 
 func TestCELFilter_FirstMatchWins(t *testing.T) {
@@ -401,10 +431,11 @@ func TestCELFilter_FirstMatchWins(t *testing.T) {
 	require.NoError(t, err)
 
 	event := createEventWithAddrs(1, syscall.IPPROTO_TCP, "10.0.0.1", "8.8.8.8", 1234, 80)
-	matched, allow, matchedIndex := filter.Evaluate(event)
+	matched, allow, matchedRule := filter.Evaluate(event)
 	assert.True(t, matched)
 	assert.True(t, allow)
-	assert.Equal(t, 0, matchedIndex)
+	assert.Equal(t, "filter[0]", matchedRule)
+	assert.Equal(t, map[string]uint64{"filter[0]": 1, "filter[1]": 0}, filter.Matches())
 }
 
 func TestCEL_ComplexExamples(t *testing.T) {
@@ -437,3 +468,120 @@ func TestCEL_ComplexExamples(t *testing.T) {
 		})
 	}
 }
+
+func TestCELFilter_EvaluationErrorPolicy(t *testing.T) {
+	rules := []Rule{
+		{Name: "broken", Action: "log", Expr: "100 / (destination.port - 80) > 1", Source: "test:1"},
+		{Name: "web", Action: "drop", Expr: "destination.port == 80", Source: "test:2"},
+	}
+	event := createEventWithAddrs(1, syscall.IPPROTO_TCP, "10.0.0.1", "8.8.8.8", 1234, 80)
+
+	tests := []struct {
+		policy      ErrorPolicy
+		matched     bool
+		allow       bool
+		matchedRule string
+	}{
+		{ErrorPolicySkip, true, false, "web"},
+		{ErrorPolicyMatch, true, true, "broken"},
+		{ErrorPolicyDrop, true, false, "broken"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			filter, err := NewFilterFromRules(rules, Options{OnError: tt.policy})
+			require.NoError(t, err)
+
+			for range 3 {
+				matched, allow, matchedRule := filter.Evaluate(event)
+				assert.Equal(t, tt.matched, matched)
+				assert.Equal(t, tt.allow, allow)
+				assert.Equal(t, tt.matchedRule, matchedRule)
+			}
+			assert.Equal(t, map[string]uint64{"broken": 3, "web": 0}, filter.Errors())
+		})
+	}
+
+	_, err := NewFilterFromRules(rules, Options{OnError: "ignore"})
+	assert.EqualError(t, err, `invalid error policy "ignore", expected one of skip, match, drop`)
+}
+
+func TestCELFilter_EvaluationErrorIsLoggedOnce(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	filter, err := NewFilter([]string{"log 100 / (destination.port - 80) > 1"})
+	require.NoError(t, err)
+
+	event := createEventWithAddrs(1, syscall.IPPROTO_TCP, "10.0.0.1", "8.8.8.8", 1234, 80)
+	for range 3 {
+		filter.Evaluate(event)
+	}
+
+	assert.Equal(t, 1, strings.Count(buf.String(), "Filter rule evaluation failed."))
+	assert.Contains(t, buf.String(), `text="log 100 / (destination.port - 80) > 1"`)
+	assert.Contains(t, buf.String(), "division by zero")
+}
+
+func TestCELFilter_DefaultAction(t *testing.T) {
+	rules, err := ParseRules([]string{`log protocol == "TCP"`})
+	require.NoError(t, err)
+
+	tcp := createEventWithAddrs(1, syscall.IPPROTO_TCP, "10.0.0.1", "8.8.8.8", 1234, 80)
+	udp := createEventWithAddrs(1, syscall.IPPROTO_UDP, "10.0.0.1", "8.8.8.8", 1234, 53)
+
+	filter, err := NewFilterFromRules(rules, Options{Default: "drop"})
+	require.NoError(t, err)
+	assert.Equal(t, "drop", filter.Default())
+
+	matched, allow, matchedRule := filter.Evaluate(tcp)
+	assert.True(t, matched)
+	assert.True(t, allow)
+	assert.Equal(t, "filter[0]", matchedRule)
+
+	matched, allow, matchedRule = filter.Evaluate(udp)
+	assert.False(t, matched)
+	assert.False(t, allow)
+	assert.Empty(t, matchedRule)
+
+	filter, err = NewFilterFromRules(nil, Options{Default: "DROP"})
+	require.NoError(t, err)
+	_, allow, _ = filter.Evaluate(tcp)
+	assert.False(t, allow)
+
+	filter, err = NewFilterFromRules(nil, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, "log", filter.Default())
+	_, allow, _ = filter.Evaluate(tcp)
+	assert.True(t, allow)
+
+	_, err = NewFilterFromRules(rules, Options{Default: "allow"})
+	assert.EqualError(t, err, `invalid default action "allow", expected one of log, drop`)
+}
+
+func TestCELFilter_GeoVariables(t *testing.T) {
+	filter, err := NewFilter([]string{`drop destination.country == "Germany" || source.asn == 15169`})
+	require.NoError(t, err)
+	assert.True(t, filter.UsesGeo())
+
+	// Without GeoIP data the variables are empty
+	event := createEventWithAddrs(1, syscall.IPPROTO_TCP, "8.8.8.8", "1.1.1.1", 1234, 443)
+	matched, shouldLog, _ := filter.EvaluateLookup(event, nil)
+	assert.False(t, matched)
+	assert.True(t, shouldLog)
+
+	filter, err = NewFilter([]string{`drop destination.as_org == "" && source.city == ""`})
+	require.NoError(t, err)
+	matched, shouldLog, _ = filter.EvaluateLookup(event, nil)
+	assert.True(t, matched)
+	assert.False(t, shouldLog)
+
+	filter, err = NewFilter([]string{`drop destination.port == 53`})
+	require.NoError(t, err)
+	assert.False(t, filter.UsesGeo())
+
+	_, err = NewFilter([]string{`drop source.asn == "15169"`})
+	assert.Error(t, err)
+}
@@ -0,0 +1,124 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package filter
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	celast "github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/types"
+)
+
+// NetworkCategories are the network categories known by is_network.
+var NetworkCategories = []string{"LOCAL", "PRIVATE", "PUBLIC", "MULTICAST"}
+
+// Issue is a finding of the static checks of a rule set.
+type Issue struct {
+	Rule    Rule
+	Message string
+}
+
+// String returns the issue prefixed by the rule source.
+func (i Issue) String() string {
+	return fmt.Sprintf("%s: %s", i.Rule.Source, i.Message)
+}
+
+// ruleSetChecker checks the rules of a set in evaluation order.
+type ruleSetChecker struct {
+	defaultAction action
+	issues        []Issue
+	unconditional *Rule
+	expressions   map[string]string
+}
+
+// check checks a compiled rule against itself and the preceding rules. Only
+// rules with terminal actions shadow later rules.
+func (c *ruleSetChecker) check(rule Rule, act ruleAction, ast *cel.Ast) {
+	report := func(format string, args ...any) {
+		c.issues = append(c.issues, Issue{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if c.unconditional != nil {
+		report("unreachable after unconditional rule %s", c.unconditional.Name)
+	}
+
+	expr := strings.Join(strings.Fields(rule.Expr), " ")
+	if c.expressions == nil {
+		c.expressions = make(map[string]string)
+	}
+	if name, ok := c.expressions[expr]; ok && c.unconditional == nil {
+		report("shadowed by rule %s with the same expression", name)
+	} else if !ok && act.isTerminal() {
+		c.expressions[expr] = rule.Name
+	}
+
+	if outputType := ast.OutputType(); !outputType.IsExactType(cel.BoolType) && !outputType.IsExactType(cel.DynType) {
+		report("expression has type %s, expected bool", outputType)
+	}
+
+	root := ast.NativeRep().Expr()
+	if root.Kind() == celast.LiteralKind {
+		switch root.AsLiteral() {
+		case types.True:
+			if c.unconditional == nil && act.isTerminal() {
+				c.unconditional = &rule
+				if act.action == c.defaultAction {
+					report("unconditional rule is redundant with default action %s", act)
+				}
+			}
+		case types.False:
+			report("expression is always false, rule never matches")
+		}
+	}
+
+	celast.PreOrderVisit(root, celast.NewExprVisitor(func(e celast.Expr) {
+		if e.Kind() != celast.CallKind {
+			return
+		}
+
+		call := e.AsCall()
+		args := call.Args()
+		switch call.FunctionName() {
+		case "is_network":
+			if category, ok := stringLiteral(args[1]); ok && !slices.Contains(NetworkCategories, category) {
+				report("unknown network category %q, expected one of %s", category, strings.Join(NetworkCategories, ", "))
+			}
+		case "in_cidr":
+			if cidr, ok := stringLiteral(args[len(args)-1]); ok {
+				if _, err := netip.ParsePrefix(cidr); err != nil {
+					report("invalid CIDR %q", cidr)
+				}
+			}
+		case "in_range":
+			minimum, minOK := intLiteral(args[1])
+			maximum, maxOK := intLiteral(args[2])
+			if minOK && maxOK && minimum > maximum {
+				report("empty range %d to %d", minimum, maximum)
+			}
+		}
+	}))
+}
+
+// stringLiteral returns the value of a string literal.
+func stringLiteral(e celast.Expr) (string, bool) {
+	if e.Kind() != celast.LiteralKind {
+		return "", false
+	}
+	value, ok := e.AsLiteral().(types.String)
+	return string(value), ok
+}
+
+// intLiteral returns the value of an int literal.
+func intLiteral(e celast.Expr) (int64, bool) {
+	if e.Kind() != celast.LiteralKind {
+		return 0, false
+	}
+	value, ok := e.AsLiteral().(types.Int)
+	return int64(value), ok
+}
@@ -0,0 +1,86 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func issueStrings(f *Filter) []string {
+	var issues []string
+	for _, issue := range f.Issues() {
+		issues = append(issues, issue.String())
+	}
+	return issues
+}
+
+func TestIssues_Literals(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  string
+		issue string
+	}{
+		{"unknown network category", `log is_network(destination.address, "PUBLC")`, `filter[0]: unknown network category "PUBLC", expected one of LOCAL, PRIVATE, PUBLIC, MULTICAST`},
+		{"invalid CIDR", `drop in_cidr(source.address, "10.0.0.0/33")`, `filter[0]: invalid CIDR "10.0.0.0/33"`},
+		{"address instead of CIDR", `drop !in_cidr(source.address, "10.0.0.1")`, `filter[0]: invalid CIDR "10.0.0.1"`},
+		{"empty range", `log in_range(destination.port, 9000, 8000)`, `filter[0]: empty range 9000 to 8000`},
+		{"non-bool expression", `log destination.port`, `filter[0]: expression has type int, expected bool`},
+		{"always false", `log false`, `filter[0]: expression is always false, rule never matches`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewFilter([]string{tt.rule})
+			require.NoError(t, err)
+			assert.Equal(t, []string{tt.issue}, issueStrings(filter))
+		})
+	}
+}
+
+func TestIssues_ValidRules(t *testing.T) {
+	filter, err := NewFilter([]string{
+		`log is_network(destination.address, "PUBLIC") && in_cidr(source.address, "10.0.0.0/8")`,
+		`drop in_range(destination.port, 8000, 8999)`,
+		`drop is_network(destination.address, protocol)`,
+		`drop any`,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, filter.Issues())
+}
+
+func TestIssues_UnreachableAndShadowedRules(t *testing.T) {
+	filter, err := NewFilter([]string{
+		`drop destination.port == 53`,
+		`log   destination.port ==  53`,
+		`log any`,
+		`drop protocol == "UDP"`,
+		`drop true`,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"filter[1]: shadowed by rule filter[0] with the same expression",
+		"filter[2]: unconditional rule is redundant with default action log",
+		"filter[3]: unreachable after unconditional rule filter[2]",
+		"filter[4]: unreachable after unconditional rule filter[2]",
+	}, issueStrings(filter))
+}
+
+func TestIssues_RedundantUnconditionalRule(t *testing.T) {
+	rules, err := ParseRules([]string{`log protocol == "TCP"`, `drop any`})
+	require.NoError(t, err)
+
+	filter, err := NewFilterFromRules(rules, Options{})
+	require.NoError(t, err)
+	assert.Empty(t, filter.Issues())
+
+	filter, err = NewFilterFromRules(rules, Options{Default: "drop"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"filter[1]: unconditional rule is redundant with default action drop",
+	}, issueStrings(filter))
+}
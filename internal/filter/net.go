@@ -0,0 +1,285 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package filter
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/google/cel-go/interpreter"
+)
+
+var (
+	// IPType is the CEL type of IP addresses
+	IPType = cel.OpaqueType("net.IP")
+	// CIDRType is the CEL type of IP prefixes, a container of IP addresses
+	// for the in operator
+	CIDRType = cel.OpaqueType("net.CIDR").WithTraits(traits.ContainerType)
+)
+
+// IP is a CEL IP address value
+type IP struct {
+	netip.Addr
+}
+
+// ConvertToNative implements ref.Val
+func (ip IP) ConvertToNative(typeDesc reflect.Type) (any, error) {
+	switch typeDesc {
+	case reflect.TypeFor[netip.Addr]():
+		return ip.Addr, nil
+	case reflect.TypeFor[string]():
+		return ip.String(), nil
+	}
+	return nil, fmt.Errorf("type conversion error from %s to %s", IPType, typeDesc)
+}
+
+// ConvertToType implements ref.Val
+func (ip IP) ConvertToType(typeValue ref.Type) ref.Val {
+	switch typeValue {
+	case types.StringType:
+		return types.String(ip.String())
+	case types.TypeType:
+		return IPType
+	}
+	return types.NewErr("type conversion error from %s to %s", IPType, typeValue)
+}
+
+// Equal implements ref.Val
+func (ip IP) Equal(other ref.Val) ref.Val {
+	o, ok := other.(IP)
+	return types.Bool(ok && ip.Addr == o.Addr)
+}
+
+// Type implements ref.Val
+func (ip IP) Type() ref.Type {
+	return IPType
+}
+
+// Value implements ref.Val
+func (ip IP) Value() any {
+	return ip.Addr
+}
+
+// CIDR is a CEL IP prefix value
+type CIDR struct {
+	Prefix netip.Prefix
+}
+
+// ConvertToNative implements ref.Val
+func (c CIDR) ConvertToNative(typeDesc reflect.Type) (any, error) {
+	switch typeDesc {
+	case reflect.TypeFor[netip.Prefix]():
+		return c.Prefix, nil
+	case reflect.TypeFor[string]():
+		return c.Prefix.String(), nil
+	}
+	return nil, fmt.Errorf("type conversion error from %s to %s", CIDRType, typeDesc)
+}
+
+// ConvertToType implements ref.Val
+func (c CIDR) ConvertToType(typeValue ref.Type) ref.Val {
+	switch typeValue {
+	case types.StringType:
+		return types.String(c.Prefix.String())
+	case types.TypeType:
+		return CIDRType
+	}
+	return types.NewErr("type conversion error from %s to %s", CIDRType, typeValue)
+}
+
+// Equal implements ref.Val
+func (c CIDR) Equal(other ref.Val) ref.Val {
+	o, ok := other.(CIDR)
+	return types.Bool(ok && c.Prefix == o.Prefix)
+}
+
+// Contains implements traits.Container, it is called by the in operator
+func (c CIDR) Contains(value ref.Val) ref.Val {
+	ip, ok := value.(IP)
+	if !ok {
+		return types.NewErr("invalid IP address type")
+	}
+	return types.Bool(c.Prefix.Contains(ip.Unmap()))
+}
+
+// Type implements ref.Val
+func (c CIDR) Type() ref.Type {
+	return CIDRType
+}
+
+// Value implements ref.Val
+func (c CIDR) Value() any {
+	return c.Prefix
+}
+
+// netFunctions returns the CEL functions of the IP and CIDR types
+func netFunctions() []cel.EnvOption {
+	return []cel.EnvOption{
+		cel.Function("ip",
+			cel.Overload("ip_string", []*cel.Type{cel.StringType}, IPType,
+				cel.UnaryBinding(parseIPFunc)),
+		),
+		cel.Function("cidr",
+			cel.Overload("cidr_string", []*cel.Type{cel.StringType}, CIDRType,
+				cel.UnaryBinding(parseCIDRFunc)),
+		),
+		cel.Function("string",
+			cel.Overload("string_ip", []*cel.Type{IPType}, cel.StringType,
+				cel.UnaryBinding(func(value ref.Val) ref.Val { return value.ConvertToType(types.StringType) })),
+			cel.Overload("string_cidr", []*cel.Type{CIDRType}, cel.StringType,
+				cel.UnaryBinding(func(value ref.Val) ref.Val { return value.ConvertToType(types.StringType) })),
+		),
+		cel.Function(operators.In,
+			cel.Overload("ip_in_cidr", []*cel.Type{IPType, CIDRType}, cel.BoolType),
+		),
+		cel.Function("in_cidr",
+			cel.MemberOverload("ip_in_cidr_string", []*cel.Type{IPType, cel.StringType}, cel.BoolType,
+				cel.BinaryBinding(func(lhs, rhs ref.Val) ref.Val {
+					cidr, ok := parseCIDRFunc(rhs).(CIDR)
+					if !ok {
+						return types.NewErr("invalid CIDR %q", rhs)
+					}
+					return cidr.Contains(lhs)
+				})),
+		),
+		cel.Function("is_private",
+			cel.MemberOverload("ip_is_private", []*cel.Type{IPType}, cel.BoolType,
+				cel.UnaryBinding(func(value ref.Val) ref.Val {
+					ip, ok := value.(IP)
+					if !ok {
+						return types.NewErr("invalid IP address type")
+					}
+					return types.Bool(ip.Unmap().IsPrivate())
+				})),
+		),
+		cel.Function("family",
+			cel.MemberOverload("ip_family", []*cel.Type{IPType}, cel.IntType,
+				cel.UnaryBinding(func(value ref.Val) ref.Val {
+					ip, ok := value.(IP)
+					if !ok {
+						return types.NewErr("invalid IP address type")
+					}
+					if ip.Unmap().Is4() {
+						return types.Int(4)
+					}
+					return types.Int(6)
+				})),
+		),
+	}
+}
+
+// parseIPFunc parses an IP address
+func parseIPFunc(value ref.Val) ref.Val {
+	s, ok := value.(types.String)
+	if !ok {
+		return types.NewErr("invalid IP address type")
+	}
+
+	addr, err := netip.ParseAddr(string(s))
+	if err != nil {
+		return types.NewErr("invalid IP address %q", string(s))
+	}
+	return IP{addr}
+}
+
+// parseCIDRFunc parses an IP prefix
+func parseCIDRFunc(value ref.Val) ref.Val {
+	s, ok := value.(types.String)
+	if !ok {
+		return types.NewErr("invalid CIDR type")
+	}
+
+	prefix, err := netip.ParsePrefix(string(s))
+	if err != nil {
+		return types.NewErr("invalid CIDR %q", string(s))
+	}
+	return CIDR{prefix.Masked()}
+}
+
+// foldNetLiterals parses string literals of ip(), cidr() and in_cidr() once
+// when the program is planned instead of on every evaluation. Invalid
+// literals fail the compilation, except for in_cidr() on address strings,
+// which keeps returning false for compatibility.
+func foldNetLiterals(i interpreter.Interpretable) (interpreter.Interpretable, error) {
+	call, ok := i.(interpreter.InterpretableCall)
+	if !ok {
+		return i, nil
+	}
+
+	args := call.Args()
+	if len(args) == 0 {
+		return i, nil
+	}
+	literal, ok := args[len(args)-1].(interpreter.InterpretableConst)
+	if !ok {
+		return i, nil
+	}
+
+	switch call.OverloadID() {
+	case "ip_string":
+		ip := parseIPFunc(literal.Value())
+		if err, ok := ip.(*types.Err); ok {
+			return nil, err
+		}
+		return interpreter.NewConstValue(call.ID(), ip), nil
+	case "cidr_string":
+		cidr := parseCIDRFunc(literal.Value())
+		if err, ok := cidr.(*types.Err); ok {
+			return nil, err
+		}
+		return interpreter.NewConstValue(call.ID(), cidr), nil
+	case "ip_in_cidr_string":
+		cidr := parseCIDRFunc(literal.Value())
+		if err, ok := cidr.(*types.Err); ok {
+			return nil, err
+		}
+		return &evalInPrefix{id: call.ID(), addr: args[0], prefix: cidr.(CIDR).Prefix}, nil
+	case "in_cidr_string_string":
+		cidr, ok := parseCIDRFunc(literal.Value()).(CIDR)
+		if !ok {
+			return i, nil
+		}
+		return &evalInPrefix{id: call.ID(), addr: args[0], prefix: cidr.Prefix}, nil
+	}
+
+	return i, nil
+}
+
+// evalInPrefix checks if an IP address or address string is in a prefix
+// parsed at compile time
+type evalInPrefix struct {
+	id     int64
+	addr   interpreter.Interpretable
+	prefix netip.Prefix
+}
+
+// ID implements interpreter.Interpretable
+func (e *evalInPrefix) ID() int64 {
+	return e.id
+}
+
+// Eval implements interpreter.Interpretable
+func (e *evalInPrefix) Eval(activation interpreter.Activation) ref.Val {
+	switch value := e.addr.Eval(activation).(type) {
+	case IP:
+		return types.Bool(e.prefix.Contains(value.Unmap()))
+	case types.String:
+		addr, err := netip.ParseAddr(string(value))
+		if err != nil {
+			return types.False
+		}
+		return types.Bool(e.prefix.Contains(addr.Unmap()))
+	case *types.Err, *types.Unknown:
+		return value
+	default:
+		return types.NewErr("invalid IP address type")
+	}
+}
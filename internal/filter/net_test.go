@@ -0,0 +1,89 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package filter
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCEL_IPTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		srcIP    string
+		dstIP    string
+		expected bool
+	}{
+		{"ip equality", `log destination.ip == ip("8.8.8.8")`, "10.0.0.1", "8.8.8.8", true},
+		{"ip inequality", `log destination.ip == ip("8.8.8.8")`, "10.0.0.1", "8.8.4.4", false},
+		{"in cidr", `log destination.ip in cidr("8.8.8.0/24")`, "10.0.0.1", "8.8.8.100", true},
+		{"not in cidr", `log destination.ip in cidr("8.8.8.0/24")`, "10.0.0.1", "8.8.9.1", false},
+		{"in cidr method", `log source.ip.in_cidr("10.0.0.0/8")`, "10.0.0.1", "8.8.8.8", true},
+		{"in cidr method ipv6", `log destination.ip.in_cidr("2001:db8::/32")`, "10.0.0.1", "2001:db8::1", true},
+		{"in cidr dynamic", `log destination.ip in cidr(source.address + "/24")`, "10.0.0.1", "10.0.0.99", true},
+		{"is private", `log source.ip.is_private() && !destination.ip.is_private()`, "192.168.1.1", "8.8.8.8", true},
+		{"is not private", `log destination.ip.is_private()`, "10.0.0.1", "8.8.8.8", false},
+		{"family 4", `log destination.ip.family() == 4`, "10.0.0.1", "8.8.8.8", true},
+		{"family 6", `log destination.ip.family() == 6`, "::1", "2001:db8::1", true},
+		{"string conversion", `log string(destination.ip) == destination.address`, "10.0.0.1", "2001:db8::1", true},
+		{"is network", `log is_network(source.ip, "PRIVATE")`, "10.0.0.1", "8.8.8.8", true},
+		{"string variables", `log in_cidr(destination.address, "8.8.8.0/24")`, "10.0.0.1", "8.8.8.8", true},
+		{"mapped ip in cidr", `log destination.ip in cidr("8.8.8.0/24")`, "10.0.0.1", "::ffff:8.8.8.8", true},
+		{"mapped string in cidr", `log in_cidr(destination.address, "8.8.8.0/24")`, "10.0.0.1", "::ffff:8.8.8.8", true},
+		{"mapped string in dynamic cidr", `log in_cidr(destination.address, "8.8.8.0/" + "24")`, "10.0.0.1", "::ffff:8.8.8.8", true},
+		{"mapped string is network", `log is_network(source.address, "PRIVATE")`, "::ffff:10.0.0.1", "8.8.8.8", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewFilter([]string{tt.rule})
+			require.NoError(t, err)
+
+			event := createEventWithAddrs(1, syscall.IPPROTO_TCP, tt.srcIP, tt.dstIP, 1234, 80)
+			matched, _, _ := filter.Evaluate(event)
+			assert.Equal(t, tt.expected, matched)
+			assert.Zero(t, filter.Errors()["filter[0]"])
+		})
+	}
+}
+
+func TestCEL_InvalidIPLiterals(t *testing.T) {
+	tests := []struct {
+		name string
+		rule string
+		err  string
+	}{
+		{"invalid ip", `log destination.ip == ip("8.8.8.256")`, `invalid IP address "8.8.8.256"`},
+		{"invalid cidr", `log destination.ip in cidr("10.0.0.0/33")`, `invalid CIDR "10.0.0.0/33"`},
+		{"invalid cidr method", `log destination.ip.in_cidr("10.0.0.0")`, `invalid CIDR "10.0.0.0"`},
+		{"ip compared to string", `log destination.ip == "8.8.8.8"`, "no matching overload"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFilter([]string{tt.rule})
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestCEL_InSetIP(t *testing.T) {
+	blocklist, err := NewSet("blocklist", []string{"203.0.113.0/24"})
+	require.NoError(t, err)
+
+	rules, err := ParseRules([]string{`drop in_set(destination.ip, "blocklist")`})
+	require.NoError(t, err)
+	filter, err := NewFilterFromRules(rules, Options{Sets: map[string]*Set{"blocklist": blocklist}})
+	require.NoError(t, err)
+
+	event := createEventWithAddrs(1, syscall.IPPROTO_TCP, "10.0.0.1", "203.0.113.5", 1234, 443)
+	_, shouldLog, rule := filter.Evaluate(event)
+	assert.False(t, shouldLog)
+	assert.Equal(t, "filter[0]", rule)
+}
@@ -0,0 +1,356 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package filter
+
+import (
+	"math"
+	"slices"
+	"syscall"
+
+	"github.com/google/cel-go/cel"
+	celast "github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/types"
+	"github.com/ti-mo/conntrack"
+)
+
+// maxPrefilterTerms limits the number of conjunctions of a translated rule
+const maxPrefilterTerms = 16
+
+// Field is an event field usable by the prefilter
+type Field string
+
+const (
+	// FieldProtocol is the IP protocol number
+	FieldProtocol Field = "protocol"
+	// FieldEventType is the conntrack event type, see conntrack.EventNew
+	FieldEventType Field = "event.type"
+	// FieldMark is the connection mark
+	FieldMark Field = "mark"
+)
+
+// EventTypes are the event types known by the filter
+var EventTypes = []string{"NEW", "UPDATE", "DESTROY"}
+
+// prefilterValues maps the string literals of fields to their numbers
+var prefilterValues = map[Field]map[string]uint32{
+	FieldProtocol: {
+		"TCP": syscall.IPPROTO_TCP,
+		"UDP": syscall.IPPROTO_UDP,
+	},
+	FieldEventType: {
+		"NEW":     uint32(conntrack.EventNew),
+		"UPDATE":  uint32(conntrack.EventUpdate),
+		"DESTROY": uint32(conntrack.EventDestroy),
+	},
+}
+
+// Condition checks if an event field is one of the values
+type Condition struct {
+	Field  Field
+	Values []uint32
+	// Negate checks if the field is none of the values
+	Negate bool
+}
+
+// matches reports whether the value satisfies the condition
+func (c Condition) matches(value uint32) bool {
+	return slices.Contains(c.Values, value) != c.Negate
+}
+
+// PrefilterRule is a leading filter rule translated to conditions on event
+// fields
+type PrefilterRule struct {
+	// Name is the name of the filter rule
+	Name string
+	// Match is a disjunction of conjunctions of conditions
+	Match [][]Condition
+	// Exact is set if Match is the complete rule expression, otherwise it is
+	// a necessary condition of the expression only
+	Exact bool
+	// Accept is the verdict for matching events, accepted events are
+	// evaluated by the filter
+	Accept bool
+}
+
+// Prefilter is the part of a filter that is decidable from the protocol,
+// event type and mark of an event alone, e.g. by a kernel socket filter.
+// Events rejected by the prefilter are never recorded by the filter.
+type Prefilter struct {
+	// Events are the event types that may be recorded
+	Events []string
+	// Rules are evaluated in order, the first matching rule decides
+	Rules []PrefilterRule
+	// Accept is the verdict for events matching none of the rules, all
+	// guards failed for them
+	Accept bool
+	// Complete is set if all rules are exact, no rule is left to the filter
+	Complete bool
+}
+
+// Prefilter returns the prefilter of the leading rules. Tag rules are
+// skipped, they never change whether an event is recorded. A rule with
+// conditions on other fields ends the exact rules, its top-level conditions
+// on prefilter fields are kept as guard. Match counts of rejected events are
+// not updated.
+func (f *Filter) Prefilter() Prefilter {
+	if f == nil {
+		return Prefilter{Events: EventTypes, Accept: true, Complete: true}
+	}
+
+	prefilter := Prefilter{Complete: true}
+	for _, eventType := range EventTypes {
+		if f.mayRecord(prefilterValues[FieldEventType][eventType]) {
+			prefilter.Events = append(prefilter.Events, eventType)
+		}
+	}
+
+	for _, compiledRule := range f.rules {
+		if !compiledRule.action.isTerminal() {
+			continue
+		}
+
+		rule := PrefilterRule{
+			Name:   compiledRule.rule.Name,
+			Match:  compiledRule.guard,
+			Exact:  compiledRule.exact,
+			Accept: !compiledRule.exact || compiledRule.action.action != actionDrop,
+		}
+		if !rule.Exact {
+			prefilter.Complete = false
+		}
+		if !rule.Exact && alwaysTrue(rule.Match) {
+			prefilter.Accept = true
+			return prefilter
+		}
+
+		prefilter.Rules = append(prefilter.Rules, rule)
+		if rule.Exact && alwaysTrue(rule.Match) {
+			prefilter.Accept = rule.Accept
+			return prefilter
+		}
+	}
+	prefilter.Accept = f.defaultAction == actionLog
+
+	return prefilter
+}
+
+// mayRecord reports whether events of the type may be recorded
+func (f *Filter) mayRecord(eventType uint32) bool {
+	for _, compiledRule := range f.rules {
+		if !compiledRule.action.isTerminal() {
+			continue
+		}
+
+		matches, certain := matchEventType(compiledRule.guard, eventType)
+		if !matches {
+			continue
+		}
+		if compiledRule.action.action != actionDrop {
+			return true
+		}
+		if certain && compiledRule.exact {
+			return false
+		}
+	}
+
+	return f.defaultAction == actionLog
+}
+
+// matchEventType checks the conditions on the event type. Returns whether a
+// conjunction may match and whether one matches for all events of the type.
+func matchEventType(match [][]Condition, eventType uint32) (bool, bool) {
+	var matches, certain bool
+	for _, term := range match {
+		termMatches, termCertain := true, true
+		for _, condition := range term {
+			if condition.Field != FieldEventType {
+				termCertain = false
+				continue
+			}
+			if !condition.matches(eventType) {
+				termMatches = false
+				break
+			}
+		}
+		matches = matches || termMatches
+		certain = certain || termMatches && termCertain
+	}
+
+	return matches, certain
+}
+
+// alwaysTrue reports whether the match has a conjunction without conditions
+func alwaysTrue(match [][]Condition) bool {
+	return slices.ContainsFunc(match, func(term []Condition) bool { return len(term) == 0 })
+}
+
+// prefilterGuard translates a compiled expression to conditions on
+// prefilter fields. Returns the conditions and whether they are exact. If
+// the expression uses other fields, the translatable top-level conjuncts are
+// returned as necessary condition.
+func prefilterGuard(ast *cel.Ast) ([][]Condition, bool) {
+	native := ast.NativeRep()
+	t := translator{references: native.ReferenceMap()}
+
+	if match, ok := t.translate(native.Expr(), false); ok {
+		return match, true
+	}
+
+	guard := [][]Condition{{}}
+	for _, conjunct := range conjuncts(native.Expr()) {
+		if match, ok := t.translate(conjunct, false); ok {
+			if product, ok := and(guard, match); ok {
+				guard = product
+			}
+		}
+	}
+
+	return guard, false
+}
+
+// conjuncts returns the operands of nested logical ands
+func conjuncts(e celast.Expr) []celast.Expr {
+	if e.Kind() != celast.CallKind || e.AsCall().FunctionName() != operators.LogicalAnd {
+		return []celast.Expr{e}
+	}
+
+	var operands []celast.Expr
+	for _, arg := range e.AsCall().Args() {
+		operands = append(operands, conjuncts(arg)...)
+	}
+	return operands
+}
+
+// translator translates expressions using the reference map of the checked
+// AST to resolve variables
+type translator struct {
+	references map[int64]*celast.ReferenceInfo
+}
+
+// translate converts an expression, negated if requested, to a disjunction
+// of conjunctions. Returns false if the expression is not translatable.
+func (t translator) translate(e celast.Expr, negate bool) ([][]Condition, bool) {
+	switch e.Kind() {
+	case celast.LiteralKind:
+		value, ok := e.AsLiteral().(types.Bool)
+		if !ok {
+			return nil, false
+		}
+		if bool(value) != negate {
+			return [][]Condition{{}}, true
+		}
+		return [][]Condition{}, true
+	case celast.CallKind:
+	default:
+		return nil, false
+	}
+
+	call := e.AsCall()
+	args := call.Args()
+	switch call.FunctionName() {
+	case operators.LogicalNot:
+		return t.translate(args[0], !negate)
+	case operators.LogicalAnd, operators.LogicalOr:
+		conjunction := (call.FunctionName() == operators.LogicalAnd) != negate
+		result := [][]Condition{{}}
+		if !conjunction {
+			result = [][]Condition{}
+		}
+		for _, arg := range args {
+			match, ok := t.translate(arg, negate)
+			if !ok {
+				return nil, false
+			}
+			if conjunction {
+				if result, ok = and(result, match); !ok {
+					return nil, false
+				}
+			} else {
+				result = append(result, match...)
+			}
+		}
+		if len(result) > maxPrefilterTerms {
+			return nil, false
+		}
+		return result, true
+	case operators.Equals, operators.NotEquals:
+		field, values, ok := t.comparison(args[0], args[1])
+		if !ok {
+			field, values, ok = t.comparison(args[1], args[0])
+		}
+		if !ok {
+			return nil, false
+		}
+		condition := Condition{Field: field, Values: values, Negate: (call.FunctionName() == operators.NotEquals) != negate}
+		return [][]Condition{{condition}}, true
+	case operators.In:
+		field, values, ok := t.comparison(args[0], args[1])
+		if !ok {
+			return nil, false
+		}
+		return [][]Condition{{{Field: field, Values: values, Negate: negate}}}, true
+	}
+
+	return nil, false
+}
+
+// comparison resolves a prefilter field and a literal or a list of literals
+func (t translator) comparison(variable celast.Expr, literal celast.Expr) (Field, []uint32, bool) {
+	reference, ok := t.references[variable.ID()]
+	if !ok {
+		return "", nil, false
+	}
+	field := Field(reference.Name)
+	if field != FieldProtocol && field != FieldEventType && field != FieldMark {
+		return "", nil, false
+	}
+
+	literals := []celast.Expr{literal}
+	if literal.Kind() == celast.ListKind {
+		literals = literal.AsList().Elements()
+	}
+
+	values := make([]uint32, 0, len(literals))
+	for _, literal := range literals {
+		if literal.Kind() != celast.LiteralKind {
+			return "", nil, false
+		}
+
+		switch value := literal.AsLiteral().(type) {
+		case types.String:
+			number, ok := prefilterValues[field][string(value)]
+			if !ok {
+				return "", nil, false
+			}
+			values = append(values, number)
+		case types.Int:
+			if field != FieldMark || value < 0 || value > math.MaxUint32 {
+				return "", nil, false
+			}
+			values = append(values, uint32(value))
+		default:
+			return "", nil, false
+		}
+	}
+
+	return field, values, true
+}
+
+// and returns the conjunction of two disjunctions of conjunctions. Returns
+// false if the result exceeds maxPrefilterTerms.
+func and(lhs, rhs [][]Condition) ([][]Condition, bool) {
+	if len(lhs)*len(rhs) > maxPrefilterTerms {
+		return nil, false
+	}
+
+	product := make([][]Condition, 0, len(lhs)*len(rhs))
+	for _, l := range lhs {
+		for _, r := range rhs {
+			product = append(product, append(slices.Clone(l), r...))
+		}
+	}
+	return product, true
+}
@@ -0,0 +1,126 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package filter
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ti-mo/conntrack"
+)
+
+var (
+	condTCP     = Condition{Field: FieldProtocol, Values: []uint32{syscall.IPPROTO_TCP}}
+	condUDP     = Condition{Field: FieldProtocol, Values: []uint32{syscall.IPPROTO_UDP}}
+	condNew     = Condition{Field: FieldEventType, Values: []uint32{uint32(conntrack.EventNew)}}
+	condUpdate  = Condition{Field: FieldEventType, Values: []uint32{uint32(conntrack.EventUpdate)}}
+	condDestroy = Condition{Field: FieldEventType, Values: []uint32{uint32(conntrack.EventDestroy)}}
+)
+
+func TestPrefilter_Translation(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  string
+		match [][]Condition
+		exact bool
+	}{
+		{"any", `drop any`, [][]Condition{{}}, true},
+		{"false", `drop false`, [][]Condition{}, true},
+		{"protocol", `drop protocol == "TCP"`, [][]Condition{{condTCP}}, true},
+		{"reversed operands", `drop "UPDATE" == event.type`, [][]Condition{{condUpdate}}, true},
+		{"not equals", `drop event.type != "UPDATE"`, [][]Condition{{{Field: FieldEventType, Values: condUpdate.Values, Negate: true}}}, true},
+		{"mark list", `drop mark in [1, 2]`, [][]Condition{{{Field: FieldMark, Values: []uint32{1, 2}}}}, true},
+		{"conjunction", `drop protocol == "UDP" && event.type == "NEW"`, [][]Condition{{condUDP, condNew}}, true},
+		{"disjunction", `drop event.type == "NEW" || event.type == "DESTROY"`, [][]Condition{{condNew}, {condDestroy}}, true},
+		{"de morgan", `drop !(protocol == "TCP" || mark == 1)`, [][]Condition{{
+			{Field: FieldProtocol, Values: condTCP.Values, Negate: true},
+			{Field: FieldMark, Values: []uint32{1}, Negate: true},
+		}}, true},
+		{"guard", `drop event.type == "NEW" && destination.port == 53`, [][]Condition{{condNew}}, false},
+		{"no guard", `drop event.type == "NEW" || destination.port == 53`, [][]Condition{{}}, false},
+		{"unknown protocol", `drop protocol == "ICMP"`, [][]Condition{{}}, false},
+		{"mark out of range", `drop mark == -1`, [][]Condition{{}}, false},
+		{"ordering", `drop mark > 1`, [][]Condition{{}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewFilter([]string{tt.rule})
+			require.NoError(t, err)
+			assert.Equal(t, tt.match, filter.rules[0].guard)
+			assert.Equal(t, tt.exact, filter.rules[0].exact)
+		})
+	}
+}
+
+func TestPrefilter_RuleSets(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []string
+		fallback string
+		expected Prefilter
+	}{
+		{"no rules", nil, "", Prefilter{
+			Events: EventTypes, Accept: true, Complete: true,
+		}},
+		{"drop updates", []string{`drop event.type == "UPDATE"`}, "", Prefilter{
+			Events:   []string{"NEW", "DESTROY"},
+			Rules:    []PrefilterRule{{Name: "filter[0]", Match: [][]Condition{{condUpdate}}, Exact: true}},
+			Accept:   true,
+			Complete: true,
+		}},
+		{"log only new TCP", []string{`log protocol == "TCP" && event.type == "NEW"`}, "drop", Prefilter{
+			Events:   []string{"NEW"},
+			Rules:    []PrefilterRule{{Name: "filter[0]", Match: [][]Condition{{condTCP, condNew}}, Exact: true, Accept: true}},
+			Complete: true,
+		}},
+		{"tag rules are skipped", []string{`tag web destination.port == 443`, `drop event.type == "UPDATE"`, `log any`}, "drop", Prefilter{
+			Events: []string{"NEW", "DESTROY"},
+			Rules: []PrefilterRule{
+				{Name: "filter[1]", Match: [][]Condition{{condUpdate}}, Exact: true},
+				{Name: "filter[2]", Match: [][]Condition{{}}, Exact: true, Accept: true},
+			},
+			Accept:   true,
+			Complete: true,
+		}},
+		{"guarded rule", []string{`drop event.type == "UPDATE"`, `log event.type == "NEW" && destination.port == 53`}, "drop", Prefilter{
+			Events: []string{"NEW"},
+			Rules: []PrefilterRule{
+				{Name: "filter[0]", Match: [][]Condition{{condUpdate}}, Exact: true},
+				{Name: "filter[1]", Match: [][]Condition{{condNew}}, Accept: true},
+			},
+		}},
+		{"unguarded rule ends prefilter", []string{`drop protocol == "UDP"`, `drop destination.port == 53`, `drop event.type == "UPDATE"`}, "", Prefilter{
+			Events: []string{"NEW", "DESTROY"},
+			Rules:  []PrefilterRule{{Name: "filter[0]", Match: [][]Condition{{condUDP}}, Exact: true}},
+			Accept: true,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseRules(tt.rules)
+			require.NoError(t, err)
+			filter, err := NewFilterFromRules(rules, Options{Default: tt.fallback})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, filter.Prefilter())
+		})
+	}
+
+	var filter *Filter
+	assert.Equal(t, Prefilter{Events: EventTypes, Accept: true, Complete: true}, filter.Prefilter())
+}
+
+func TestCEL_MarkPredicate(t *testing.T) {
+	filter, err := NewFilter([]string{`log mark == 42`})
+	require.NoError(t, err)
+
+	event := createEvent(1, syscall.IPPROTO_TCP)
+	event.Flow.Mark = 42
+	matched, _, _ := filter.Evaluate(event)
+	assert.True(t, matched)
+}
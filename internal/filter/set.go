@@ -0,0 +1,268 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package filter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	celast "github.com/google/cel-go/common/ast"
+)
+
+// SetKind is the kind of entries of a set
+type SetKind string
+
+const (
+	// SetKindIP is a set of IP addresses and prefixes
+	SetKindIP SetKind = "ip"
+	// SetKindPort is a set of ports and port ranges
+	SetKindPort SetKind = "port"
+)
+
+// Set is a named set of IP prefixes or ports usable by in_set. IP sets are
+// backed by a prefix trie, port sets by a bitmap.
+type Set struct {
+	Name string
+	Kind SetKind
+	Len  int
+
+	prefixes prefixTrie
+	ports    []uint64
+}
+
+// NewSet creates a set from entries: IP addresses and CIDR prefixes or ports
+// and port ranges like 8000-8999. The kind is derived from the entries.
+func NewSet(name string, entries []string) (*Set, error) {
+	set := &Set{Name: name}
+	for i, entry := range entries {
+		if err := set.add(entry); err != nil {
+			return nil, fmt.Errorf("set %q: entry %d: %w", name, i+1, err)
+		}
+	}
+
+	return set, nil
+}
+
+// LoadSet loads a set from a file with one entry per line. Empty lines and
+// text after '#' are ignored.
+func LoadSet(name string, file string) (*Set, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("set %q: %w", name, err)
+	}
+
+	set := &Set{Name: name}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		entry, _, _ := strings.Cut(scanner.Text(), "#")
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if err := set.add(entry); err != nil {
+			return nil, fmt.Errorf("set %q: %s:%d: %w", name, file, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("set %q: %s: %w", name, file, err)
+	}
+
+	return set, nil
+}
+
+// ContainsAddr reports whether the address is in the IP set.
+func (s *Set) ContainsAddr(addr netip.Addr) bool {
+	return s.Kind == SetKindIP && s.prefixes.contains(addr)
+}
+
+// ContainsPort reports whether the port is in the port set.
+func (s *Set) ContainsPort(port uint16) bool {
+	return s.Kind == SetKindPort && s.ports[port/64]&(1<<(port%64)) != 0
+}
+
+// checkSetReferences checks that in_set calls with a literal set name refer to
+// a known set of the matching kind.
+func checkSetReferences(ast *cel.Ast, sets map[string]*Set) error {
+	var err error
+
+	native := ast.NativeRep()
+	celast.PreOrderVisit(native.Expr(), celast.NewExprVisitor(func(e celast.Expr) {
+		if err != nil || e.Kind() != celast.CallKind || e.AsCall().FunctionName() != "in_set" {
+			return
+		}
+
+		args := e.AsCall().Args()
+		name, ok := stringLiteral(args[1])
+		if !ok {
+			return
+		}
+
+		set, ok := sets[strings.ToLower(name)]
+		if !ok {
+			err = fmt.Errorf("unknown set %q", name)
+			return
+		}
+
+		kind := SetKindIP
+		if native.GetType(args[0].ID()).IsExactType(cel.IntType) {
+			kind = SetKindPort
+		}
+		if set.Kind != "" && set.Kind != kind {
+			err = fmt.Errorf("set %q has kind %s, expected %s", name, set.Kind, kind)
+		}
+	}))
+
+	return err
+}
+
+// add adds an entry to the set.
+func (s *Set) add(entry string) error {
+	entry = strings.TrimSpace(entry)
+
+	kind := SetKindIP
+	if entry != "" && strings.Trim(entry, "0123456789-") == "" {
+		kind = SetKindPort
+	}
+	if s.Kind != "" && s.Kind != kind {
+		return fmt.Errorf("%s entry %q in %s set", kind, entry, s.Kind)
+	}
+	s.Kind = kind
+
+	switch kind {
+	case SetKindPort:
+		first, last, err := parsePortRange(entry)
+		if err != nil {
+			return err
+		}
+		if s.ports == nil {
+			s.ports = make([]uint64, 65536/64)
+		}
+		for port := uint32(first); port <= uint32(last); port++ {
+			s.ports[port/64] |= 1 << (port % 64)
+		}
+	default:
+		prefix, err := parsePrefix(entry)
+		if err != nil {
+			return err
+		}
+		s.prefixes.insert(prefix)
+	}
+	s.Len++
+
+	return nil
+}
+
+// parsePortRange parses a port or a port range.
+func parsePortRange(entry string) (uint16, uint16, error) {
+	first, last, isRange := strings.Cut(entry, "-")
+	if !isRange {
+		last = first
+	}
+
+	from, err := strconv.ParseUint(first, 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port %q", entry)
+	}
+	to, err := strconv.ParseUint(last, 10, 16)
+	if err != nil || to < from {
+		return 0, 0, fmt.Errorf("invalid port range %q", entry)
+	}
+
+	return uint16(from), uint16(to), nil
+}
+
+// parsePrefix parses an IP address or a CIDR prefix.
+func parsePrefix(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", entry)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP address %q", entry)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// prefixTrie is a binary trie of IP prefixes. IPv4 prefixes are stored as
+// IPv4-mapped IPv6 prefixes.
+type prefixTrie struct {
+	root *trieNode
+}
+
+// trieNode is a node of the prefix trie, terminal nodes end a prefix.
+type trieNode struct {
+	children [2]*trieNode
+	terminal bool
+}
+
+// insert adds a prefix to the trie.
+func (t *prefixTrie) insert(prefix netip.Prefix) {
+	bits, length := mappedBits(prefix.Addr()), prefix.Bits()
+	if prefix.Addr().Is4() {
+		length += 96
+	}
+
+	if t.root == nil {
+		t.root = &trieNode{}
+	}
+
+	node := t.root
+	for i := 0; i < length && !node.terminal; i++ {
+		bit := bitAt(bits, i)
+		if node.children[bit] == nil {
+			node.children[bit] = &trieNode{}
+		}
+		node = node.children[bit]
+	}
+	node.terminal = true
+	node.children = [2]*trieNode{}
+}
+
+// contains reports whether the address is covered by a prefix of the trie.
+func (t *prefixTrie) contains(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+
+	bits := mappedBits(addr.Unmap())
+	node := t.root
+	for i := 0; node != nil; i++ {
+		if node.terminal {
+			return true
+		}
+		if i == 128 {
+			break
+		}
+		node = node.children[bitAt(bits, i)]
+	}
+
+	return false
+}
+
+// mappedBits returns the address bits in IPv6 form, IPv4 addresses mapped.
+func mappedBits(addr netip.Addr) [16]byte {
+	return addr.As16()
+}
+
+// bitAt returns the bit at position i, most significant first.
+func bitAt(bits [16]byte, i int) byte {
+	return bits[i/8] >> (7 - i%8) & 1
+}
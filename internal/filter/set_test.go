@@ -0,0 +1,187 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package filter
+
+import (
+	"net/netip"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSet_Addresses(t *testing.T) {
+	set, err := NewSet("blocklist", []string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32", "10.1.0.0/16"})
+	require.NoError(t, err)
+	assert.Equal(t, SetKindIP, set.Kind)
+	assert.Equal(t, 4, set.Len)
+
+	tests := []struct {
+		addr     string
+		expected bool
+	}{
+		{"10.0.0.1", true},
+		{"10.255.255.255", true},
+		{"11.0.0.1", false},
+		{"192.168.1.1", true},
+		{"192.168.1.2", false},
+		{"::ffff:10.0.0.1", true},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+		{"::a00:1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			assert.Equal(t, tt.expected, set.ContainsAddr(netip.MustParseAddr(tt.addr)))
+		})
+	}
+	assert.False(t, set.ContainsPort(80))
+}
+
+func TestSet_Ports(t *testing.T) {
+	set, err := NewSet("web", []string{"80", "443", "8000-8999"})
+	require.NoError(t, err)
+	assert.Equal(t, SetKindPort, set.Kind)
+
+	for port, expected := range map[uint16]bool{80: true, 443: true, 8000: true, 8500: true, 8999: true, 9000: false, 22: false} {
+		assert.Equal(t, expected, set.ContainsPort(port), "port %d", port)
+	}
+	assert.False(t, set.ContainsAddr(netip.MustParseAddr("10.0.0.1")))
+}
+
+func TestSet_InvalidEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		err     string
+	}{
+		{"invalid CIDR", []string{"10.0.0.0/33"}, `set "s": entry 1: invalid CIDR "10.0.0.0/33"`},
+		{"invalid address", []string{"10.0.0.1", "example.com"}, `set "s": entry 2: invalid IP address "example.com"`},
+		{"invalid port", []string{"65536"}, `set "s": entry 1: invalid port "65536"`},
+		{"invalid port range", []string{"9000-8000"}, `set "s": entry 1: invalid port range "9000-8000"`},
+		{"mixed kinds", []string{"10.0.0.1", "80"}, `set "s": entry 2: port entry "80" in ip set`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSet("s", tt.entries)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestLoadSet(t *testing.T) {
+	dir := t.TempDir()
+	path := writeRuleFile(t, dir, "blocklist.txt", `# known bad hosts
+198.51.100.7
+203.0.113.0/24  # scanner network
+
+`)
+
+	set, err := LoadSet("blocklist", path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, set.Len)
+	assert.True(t, set.ContainsAddr(netip.MustParseAddr("203.0.113.99")))
+
+	path = writeRuleFile(t, dir, "broken.txt", "198.51.100.7\n\n198.51.100.300\n")
+	_, err = LoadSet("broken", path)
+	assert.EqualError(t, err, `set "broken": `+path+`:3: invalid IP address "198.51.100.300"`)
+
+	_, err = LoadSet("missing", filepath.Join(dir, "missing.txt"))
+	assert.ErrorContains(t, err, `set "missing": open `)
+}
+
+func TestCELFilter_InSet(t *testing.T) {
+	blocklist, err := NewSet("blocklist", []string{"203.0.113.0/24"})
+	require.NoError(t, err)
+	web, err := NewSet("web", []string{"80", "443"})
+	require.NoError(t, err)
+	sets := map[string]*Set{"blocklist": blocklist, "web": web}
+
+	rules, err := ParseRules([]string{
+		`drop in_set(destination.address, "blocklist")`,
+		`log in_set(destination.port, "web")`,
+	})
+	require.NoError(t, err)
+
+	filter, err := NewFilterFromRules(rules, Options{Default: "drop", Sets: sets})
+	require.NoError(t, err)
+	assert.Len(t, filter.Sets(), 2)
+
+	tests := []struct {
+		name        string
+		dst         string
+		dport       uint16
+		expectedLog bool
+		expectedHit string
+	}{
+		{"blocked address", "203.0.113.5", 443, false, "filter[0]"},
+		{"web port", "198.51.100.1", 443, true, "filter[1]"},
+		{"no match", "198.51.100.1", 22, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := createEventWithAddrs(1, syscall.IPPROTO_TCP, "10.0.0.1", tt.dst, 1234, tt.dport)
+			_, shouldLog, rule := filter.Evaluate(event)
+			assert.Equal(t, tt.expectedLog, shouldLog)
+			assert.Equal(t, tt.expectedHit, rule)
+		})
+	}
+}
+
+func TestCELFilter_InSetIgnoresCase(t *testing.T) {
+	blocklist, err := NewSet("blockList", []string{"203.0.113.0/24"})
+	require.NoError(t, err)
+	sets := map[string]*Set{"blockList": blocklist}
+
+	rules, err := ParseRules([]string{
+		`drop in_set(destination.address, "blocklist")`,
+		`drop in_set(source.address, "BLOCKLIST")`,
+	})
+	require.NoError(t, err)
+
+	filter, err := NewFilterFromRules(rules, Options{Sets: sets})
+	require.NoError(t, err)
+	assert.Contains(t, filter.Sets(), "blocklist")
+
+	event := createEventWithAddrs(1, syscall.IPPROTO_TCP, "10.0.0.1", "203.0.113.5", 1234, 443)
+	_, shouldLog, rule := filter.Evaluate(event)
+	assert.False(t, shouldLog)
+	assert.Equal(t, "filter[0]", rule)
+
+	event = createEventWithAddrs(1, syscall.IPPROTO_TCP, "203.0.113.5", "10.0.0.1", 1234, 443)
+	_, shouldLog, rule = filter.Evaluate(event)
+	assert.False(t, shouldLog)
+	assert.Equal(t, "filter[1]", rule)
+}
+
+func TestCELFilter_InSetReferences(t *testing.T) {
+	web, err := NewSet("web", []string{"80", "443"})
+	require.NoError(t, err)
+	sets := map[string]*Set{"web": web}
+
+	tests := []struct {
+		name string
+		rule string
+		err  string
+	}{
+		{"unknown set", `drop in_set(destination.address, "blocklist")`, `unknown set "blocklist"`},
+		{"kind mismatch", `drop in_set(destination.address, "web")`, `set "web" has kind port, expected ip`},
+		{"kind mismatch ignoring case", `drop in_set(destination.address, "Web")`, `set "Web" has kind port, expected ip`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseRules([]string{tt.rule})
+			require.NoError(t, err)
+			_, err = NewFilterFromRules(rules, Options{Sets: sets})
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
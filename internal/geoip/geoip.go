@@ -8,14 +8,18 @@ import (
 	"fmt"
 	"net/netip"
 	"strings"
+	"time"
 
 	"github.com/oschwald/geoip2-golang/v2"
 )
 
-// GeoIP is a wrapper around the GeoIP2 City database reader.
+// GeoIP is a wrapper around the GeoIP2 City and the optional ASN database
+// reader.
 type GeoIP struct {
-	Reader   *geoip2.Reader
-	Database string
+	Reader      *geoip2.Reader
+	Database    string
+	ASNReader   *geoip2.Reader
+	ASNDatabase string
 }
 
 // Location represents geographical location information.
@@ -26,6 +30,19 @@ type Location struct {
 	Lon     float64
 }
 
+// ASN represents autonomous system information.
+type ASN struct {
+	Number       uint
+	Organization string
+}
+
+// Database describes an opened GeoIP database.
+type Database struct {
+	Path  string    `json:"path"`
+	Type  string    `json:"type"`
+	Built time.Time `json:"built"`
+}
+
 // NewGeoIP creates a new GeoIP instance by loading the specified GeoIP2 City
 // database file.
 func NewGeoIP(database string) (*GeoIP, error) {
@@ -46,8 +63,51 @@ func NewGeoIP(database string) (*GeoIP, error) {
 	}, nil
 }
 
-// Close closes the GeoIP database reader.
+// OpenASN opens the specified GeoIP2 or GeoLite2 ASN database file for ASN
+// lookups.
+func (g *GeoIP) OpenASN(database string) error {
+	reader, err := geoip2.Open(database)
+	if err != nil {
+		return err
+	}
+
+	metadata := reader.Metadata()
+	if !strings.HasSuffix(metadata.DatabaseType, "ASN") {
+		_ = reader.Close()
+		return fmt.Errorf("invalid GeoIP2 database type: %s, expected ASN", metadata.DatabaseType)
+	}
+
+	g.ASNReader = reader
+	g.ASNDatabase = database
+
+	return nil
+}
+
+// Databases returns the path, type and build time of the opened databases,
+// none if g is nil.
+func (g *GeoIP) Databases() []Database {
+	if g == nil {
+		return nil
+	}
+
+	databases := []Database{newDatabase(g.Database, g.Reader)}
+	if g.ASNReader != nil {
+		databases = append(databases, newDatabase(g.ASNDatabase, g.ASNReader))
+	}
+
+	return databases
+}
+
+func newDatabase(path string, reader *geoip2.Reader) Database {
+	metadata := reader.Metadata()
+	return Database{Path: path, Type: metadata.DatabaseType, Built: metadata.BuildTime()}
+}
+
+// Close closes the GeoIP database readers.
 func (g *GeoIP) Close() error {
+	if g.ASNReader != nil {
+		_ = g.ASNReader.Close()
+	}
 	return g.Reader.Close()
 }
 
@@ -88,3 +148,21 @@ func (g *GeoIP) Location(ip netip.Addr) *Location {
 		Lon:     lon,
 	}
 }
+
+// ASN retrieves the autonomous system information for the given IP address.
+// If no ASN database is opened or no data is found, it returns nil.
+func (g *GeoIP) ASN(ip netip.Addr) *ASN {
+	if g.ASNReader == nil {
+		return nil
+	}
+
+	record, err := g.ASNReader.ASN(ip)
+	if err != nil || !record.HasData() {
+		return nil
+	}
+
+	return &ASN{
+		Number:       record.AutonomousSystemNumber,
+		Organization: record.AutonomousSystemOrganization,
+	}
+}
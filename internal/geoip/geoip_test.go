@@ -30,6 +30,20 @@ func newReturnsInstanceIfDatabaseIsValid(t *testing.T) {
 	assert.IsType(t, geo.Reader, &geoip2.Reader{})
 }
 
+func databasesReturnsMetadata(t *testing.T) {
+	assert.Nil(t, (*GeoIP)(nil).Databases())
+
+	geo, err := NewGeoIP(geoDatabasePath)
+	assert.NoError(t, err)
+	defer geo.Close()
+
+	databases := geo.Databases()
+	assert.Len(t, databases, 1)
+	assert.Equal(t, geoDatabasePath, databases[0].Path)
+	assert.Contains(t, databases[0].Type, "City")
+	assert.False(t, databases[0].Built.IsZero())
+}
+
 func locationReturnsNilIfAddressIsUnresolved(t *testing.T) {
 	geo, err := NewGeoIP(geoDatabasePath)
 	assert.NoError(t, err)
@@ -60,6 +74,29 @@ func locationReturnsLocationIfAddressIsResolved(t *testing.T) {
 	assert.IsType(t, &Location{}, location, "location type")
 }
 
+func lookupCachesLocation(t *testing.T) {
+	geo, err := NewGeoIP(geoDatabasePath)
+	assert.NoError(t, err)
+	defer geo.Close()
+
+	ip, _ := netip.ParseAddr("63.176.75.230")
+	lookup := geo.Lookup()
+	location := lookup.Location(ip)
+	assert.NotNil(t, location, "resolved address")
+	assert.Same(t, location, lookup.Location(ip), "cached location")
+	assert.Nil(t, lookup.ASN(ip), "no ASN database")
+}
+
+func TestLookup(t *testing.T) {
+	var geo *GeoIP
+	lookup := geo.Lookup()
+	assert.Nil(t, lookup)
+
+	ip, _ := netip.ParseAddr("63.176.75.230")
+	assert.Nil(t, lookup.Location(ip))
+	assert.Nil(t, lookup.ASN(ip))
+}
+
 func TestGeoIP(t *testing.T) {
 	var ok bool
 	geoDatabasePath, ok = os.LookupEnv("CONNTRACKD_GEOIP_DATABASE")
@@ -72,6 +109,8 @@ func TestGeoIP(t *testing.T) {
 
 	t.Run("geoip.NewGeoIP returns error if database is invalid", newReturnsErrorIfDatabaseIsInvalid)
 	t.Run("geoip.NewGeoIP returns instance if database is valid", newReturnsInstanceIfDatabaseIsValid)
+	t.Run("geoip.Databases returns metadata", databasesReturnsMetadata)
 	t.Run("geoip.Location returns nil if IP is unresolved", locationReturnsNilIfAddressIsUnresolved)
 	t.Run("geoip.Location returns location if IP is resolved", locationReturnsLocationIfAddressIsResolved)
+	t.Run("geoip.Lookup caches location", lookupCachesLocation)
 }
@@ -0,0 +1,62 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package geoip
+
+import "net/netip"
+
+// Lookup caches the location and ASN lookups done while processing a single
+// event, so the filter and the record share them. A Lookup is not safe for
+// concurrent use.
+type Lookup struct {
+	geo       *GeoIP
+	locations map[netip.Addr]*Location
+	asns      map[netip.Addr]*ASN
+}
+
+// Lookup returns a new lookup cache. It returns nil if g is nil, a nil
+// Lookup yields no data.
+func (g *GeoIP) Lookup() *Lookup {
+	if g == nil {
+		return nil
+	}
+
+	return &Lookup{
+		geo:       g,
+		locations: make(map[netip.Addr]*Location, 2),
+		asns:      make(map[netip.Addr]*ASN, 2),
+	}
+}
+
+// Location returns the geographical location of the given IP address, looked
+// up on first use.
+func (l *Lookup) Location(ip netip.Addr) *Location {
+	if l == nil {
+		return nil
+	}
+
+	location, ok := l.locations[ip]
+	if !ok {
+		location = l.geo.Location(ip)
+		l.locations[ip] = location
+	}
+
+	return location
+}
+
+// ASN returns the autonomous system information of the given IP address,
+// looked up on first use.
+func (l *Lookup) ASN(ip netip.Addr) *ASN {
+	if l == nil {
+		return nil
+	}
+
+	asn, ok := l.asns[ip]
+	if !ok {
+		asn = l.geo.ASN(ip)
+		l.asns[ip] = asn
+	}
+
+	return asn
+}
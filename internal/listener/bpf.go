@@ -0,0 +1,272 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package listener
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"syscall"
+
+	"github.com/ti-mo/conntrack"
+	"github.com/tschaefer/conntrackd/internal/filter"
+	"golang.org/x/net/bpf"
+)
+
+const (
+	// headerLen is the length of the netlink and netfilter headers
+	headerLen = 20
+	// ctaTupleOrig, ctaTupleProto, ctaProtoNum and ctaMark are the
+	// ctnetlink attributes of the protocol and the mark
+	ctaTupleOrig  = 1
+	ctaTupleProto = 2
+	ctaProtoNum   = 1
+	ctaMark       = 8
+	// ctDelete is the ctnetlink message type of DESTROY events
+	ctDelete = 2
+	// createExcl are NLM_F_CREATE and NLM_F_EXCL in the high byte of the
+	// netlink flags, set for NEW events only
+	createExcl = 0x06
+	// maxInstructions is the maximum length of a socket filter
+	maxInstructions = 4096
+)
+
+const (
+	verdictDrop   = 0
+	verdictAccept = math.MaxUint32
+)
+
+// scratch are the scratch memory slots of the fields
+var scratch = map[filter.Field]int{
+	filter.FieldEventType: 0,
+	filter.FieldProtocol:  1,
+	filter.FieldMark:      2,
+}
+
+// typeOffset and flagsOffset are the offsets of the message type and the
+// high byte of the flags in the netlink header, which is in host byte order
+var typeOffset, flagsOffset uint32 = 4, 7
+
+func init() {
+	if binary.NativeEndian.Uint16([]byte{0, 1}) == 1 {
+		typeOffset, flagsOffset = 5, 6
+	}
+}
+
+// compile compiles the prefilter to a socket filter. TCP and UDP events are
+// passed to the rules, all other events are dropped as the service ignores
+// them anyway, unless allProtocols is set. Events without protocol are
+// accepted.
+func compile(prefilter filter.Prefilter, allProtocols bool) ([]bpf.Instruction, error) {
+	p := &program{}
+
+	store := p.label()
+	destroy, update, create := p.label(), p.label(), p.label()
+	p.emit(bpf.LoadAbsolute{Off: typeOffset, Size: 1})
+	p.jumpIf(bpf.JumpEqual, ctDelete, destroy, p.next())
+	p.emit(bpf.LoadAbsolute{Off: flagsOffset, Size: 1})
+	p.jumpIf(bpf.JumpBitsSet, createExcl, create, update)
+	p.mark(update)
+	p.emit(bpf.LoadConstant{Dst: bpf.RegA, Val: uint32(conntrack.EventUpdate)})
+	p.jump(store)
+	p.mark(create)
+	p.emit(bpf.LoadConstant{Dst: bpf.RegA, Val: uint32(conntrack.EventNew)})
+	p.jump(store)
+	p.mark(destroy)
+	p.emit(bpf.LoadConstant{Dst: bpf.RegA, Val: uint32(conntrack.EventDestroy)})
+	p.mark(store)
+	p.emit(bpf.StoreScratch{Src: bpf.RegA, N: scratch[filter.FieldEventType]})
+
+	p.emit(bpf.LoadConstant{Dst: bpf.RegA, Val: headerLen})
+	for i, attribute := range []uint32{ctaTupleOrig, ctaTupleProto, ctaProtoNum} {
+		extension := bpf.ExtNetlinkAttrNested
+		if i == 0 {
+			extension = bpf.ExtNetlinkAttr
+		}
+		p.emit(bpf.LoadConstant{Dst: bpf.RegX, Val: attribute})
+		p.emit(bpf.LoadExtension{Num: extension})
+		p.returnIf(bpf.JumpEqual, 0, verdictAccept)
+	}
+	p.emit(bpf.TAX{})
+	p.emit(bpf.LoadIndirect{Off: 4, Size: 1})
+	p.emit(bpf.StoreScratch{Src: bpf.RegA, N: scratch[filter.FieldProtocol]})
+	if !allProtocols {
+		accepted := p.label()
+		p.jumpIf(bpf.JumpEqual, syscall.IPPROTO_TCP, accepted, p.next())
+		p.jumpIf(bpf.JumpEqual, syscall.IPPROTO_UDP, accepted, p.next())
+		p.emit(bpf.RetConstant{Val: verdictDrop})
+		p.mark(accepted)
+	}
+
+	if usesMark(prefilter) {
+		absent := p.label()
+		p.emit(bpf.LoadConstant{Dst: bpf.RegA, Val: headerLen})
+		p.emit(bpf.LoadConstant{Dst: bpf.RegX, Val: ctaMark})
+		p.emit(bpf.LoadExtension{Num: bpf.ExtNetlinkAttr})
+		p.jumpIf(bpf.JumpEqual, 0, absent, p.next())
+		p.emit(bpf.TAX{})
+		p.emit(bpf.LoadIndirect{Off: 4, Size: 4})
+		p.mark(absent)
+		p.emit(bpf.StoreScratch{Src: bpf.RegA, N: scratch[filter.FieldMark]})
+	}
+
+	for _, rule := range prefilter.Rules {
+		verdict := uint32(verdictDrop)
+		if rule.Accept {
+			verdict = verdictAccept
+		}
+
+		for _, term := range rule.Match {
+			nextTerm := p.label()
+			for _, condition := range term {
+				matched, unmatched := p.label(), nextTerm
+				if condition.Negate {
+					matched, unmatched = unmatched, matched
+				}
+
+				p.emit(bpf.LoadScratch{Dst: bpf.RegA, N: scratch[condition.Field]})
+				if len(condition.Values) == 0 {
+					p.jump(unmatched)
+				}
+				for i, value := range condition.Values {
+					next := unmatched
+					if i < len(condition.Values)-1 {
+						next = p.next()
+					}
+					p.jumpIf(bpf.JumpEqual, value, matched, next)
+				}
+
+				if condition.Negate {
+					p.mark(unmatched)
+				} else {
+					p.mark(matched)
+				}
+			}
+			p.emit(bpf.RetConstant{Val: verdict})
+			p.mark(nextTerm)
+		}
+	}
+
+	verdict := uint32(verdictDrop)
+	if prefilter.Accept {
+		verdict = verdictAccept
+	}
+	p.emit(bpf.RetConstant{Val: verdict})
+
+	return p.resolve()
+}
+
+// usesMark reports whether a rule of the prefilter checks the mark
+func usesMark(prefilter filter.Prefilter) bool {
+	for _, rule := range prefilter.Rules {
+		for _, term := range rule.Match {
+			for _, condition := range term {
+				if condition.Field == filter.FieldMark {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// program assembles a socket filter with forward jumps to labels
+type program struct {
+	instructions []bpf.Instruction
+	labels       []int
+	jumps        map[int][2]int
+	gotos        map[int]int
+}
+
+// label returns a new label, placed with mark
+func (p *program) label() int {
+	p.labels = append(p.labels, -1)
+	return len(p.labels) - 1
+}
+
+// next returns a label placed at the next instruction
+func (p *program) next() int {
+	label := p.label()
+	p.labels[label] = len(p.instructions) + 1
+	return label
+}
+
+// mark places the label at the next instruction
+func (p *program) mark(label int) {
+	p.labels[label] = len(p.instructions)
+}
+
+// emit appends an instruction
+func (p *program) emit(instruction bpf.Instruction) {
+	p.instructions = append(p.instructions, instruction)
+}
+
+// jumpIf appends a conditional jump comparing register A with the value
+func (p *program) jumpIf(cond bpf.JumpTest, value uint32, onTrue, onFalse int) {
+	if p.jumps == nil {
+		p.jumps = make(map[int][2]int)
+	}
+	p.jumps[len(p.instructions)] = [2]int{onTrue, onFalse}
+	p.emit(bpf.JumpIf{Cond: cond, Val: value})
+}
+
+// jump appends an unconditional jump
+func (p *program) jump(label int) {
+	if p.gotos == nil {
+		p.gotos = make(map[int]int)
+	}
+	p.gotos[len(p.instructions)] = label
+	p.emit(bpf.Jump{})
+}
+
+// returnIf appends a return of the verdict if the condition holds
+func (p *program) returnIf(cond bpf.JumpTest, value uint32, verdict uint32) {
+	skip := p.label()
+	p.jumpIf(cond, value, p.next(), skip)
+	p.emit(bpf.RetConstant{Val: verdict})
+	p.mark(skip)
+}
+
+// resolve returns the instructions with the jump offsets to the labels
+func (p *program) resolve() ([]bpf.Instruction, error) {
+	if len(p.instructions) > maxInstructions {
+		return nil, fmt.Errorf("program has %d instructions, at most %d are allowed", len(p.instructions), maxInstructions)
+	}
+
+	offset := func(index int, label int) (int, error) {
+		target := p.labels[label]
+		if target <= index {
+			return 0, fmt.Errorf("invalid jump from %d to %d", index, target)
+		}
+		return target - index - 1, nil
+	}
+
+	for index, labels := range p.jumps {
+		skipTrue, err := offset(index, labels[0])
+		if err != nil {
+			return nil, err
+		}
+		skipFalse, err := offset(index, labels[1])
+		if err != nil {
+			return nil, err
+		}
+		if skipTrue > math.MaxUint8 || skipFalse > math.MaxUint8 {
+			return nil, fmt.Errorf("jump from %d exceeds %d instructions", index, math.MaxUint8)
+		}
+
+		jump := p.instructions[index].(bpf.JumpIf)
+		jump.SkipTrue, jump.SkipFalse = uint8(skipTrue), uint8(skipFalse)
+		p.instructions[index] = jump
+	}
+	for index, label := range p.gotos {
+		skip, err := offset(index, label)
+		if err != nil {
+			return nil, err
+		}
+		p.instructions[index] = bpf.Jump{Skip: uint32(skip)}
+	}
+
+	return p.instructions, nil
+}
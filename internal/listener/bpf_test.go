@@ -0,0 +1,256 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package listener
+
+import (
+	"encoding/binary"
+	"syscall"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ti-mo/conntrack"
+	"github.com/ti-mo/netfilter"
+	"github.com/tschaefer/conntrackd/internal/filter"
+	"golang.org/x/net/bpf"
+)
+
+// __createMessage returns a ctnetlink event message as received from the
+// kernel, mark 0 omits the mark attribute
+func __createMessage(t *testing.T, eventType uint8, proto uint8, mark uint32) []byte {
+	header := netfilter.Header{SubsystemID: netfilter.NFSubsysCTNetlink, Family: netfilter.ProtoIPv4}
+	switch eventType {
+	case uint8(conntrack.EventNew):
+		header.Flags = netlink.Create | netlink.Excl
+	case uint8(conntrack.EventDestroy):
+		header.MessageType = ctDelete
+	}
+
+	attrs := []netfilter.Attribute{{
+		Type:   ctaTupleOrig,
+		Nested: true,
+		Children: []netfilter.Attribute{
+			{Type: 1, Nested: true, Children: []netfilter.Attribute{
+				{Type: 1, Data: []byte{10, 0, 0, 1}},
+				{Type: 2, Data: []byte{10, 0, 0, 2}},
+			}},
+			{Type: ctaTupleProto, Nested: true, Children: []netfilter.Attribute{
+				{Type: ctaProtoNum, Data: []byte{proto}},
+				{Type: 2, Data: []byte{0x12, 0x67}, NetByteOrder: true},
+				{Type: 3, Data: []byte{0x01, 0xbb}, NetByteOrder: true},
+			}},
+		},
+	}}
+	if mark != 0 {
+		attrs = append(attrs, netfilter.Attribute{Type: ctaMark, Data: binary.BigEndian.AppendUint32(nil, mark), NetByteOrder: true})
+	}
+
+	message, err := netfilter.MarshalNetlink(header, attrs)
+	require.NoError(t, err)
+	message.Header.Length = uint32(16 + len(message.Data))
+	data, err := message.MarshalBinary()
+	require.NoError(t, err)
+
+	var event conntrack.Event
+	require.NoError(t, event.Unmarshal(message))
+	require.Equal(t, eventType, uint8(event.Type))
+
+	return data
+}
+
+// __run runs a socket filter on a packet like the kernel, including the
+// netlink attribute extensions
+func __run(t *testing.T, instructions []bpf.Instruction, packet []byte) uint32 {
+	_, err := bpf.Assemble(instructions)
+	require.NoError(t, err)
+
+	findAttribute := func(offset uint32, length uint32, attrType uint32) uint32 {
+		end := offset + length
+		for offset+4 <= end {
+			attrLen := uint32(binary.NativeEndian.Uint16(packet[offset:]))
+			if attrLen < 4 || offset+attrLen > end {
+				return 0
+			}
+			if uint32(binary.NativeEndian.Uint16(packet[offset+2:]))&0x3fff == attrType {
+				return offset
+			}
+			offset += (attrLen + 3) &^ 3
+		}
+		return 0
+	}
+
+	var a, x uint32
+	var memory [16]uint32
+	for pc := 0; pc < len(instructions); pc++ {
+		switch ins := instructions[pc].(type) {
+		case bpf.LoadAbsolute:
+			require.Equal(t, 1, ins.Size)
+			a = uint32(packet[ins.Off])
+		case bpf.LoadIndirect:
+			switch ins.Size {
+			case 1:
+				a = uint32(packet[x+ins.Off])
+			case 4:
+				a = binary.BigEndian.Uint32(packet[x+ins.Off:])
+			}
+		case bpf.LoadConstant:
+			if ins.Dst == bpf.RegA {
+				a = ins.Val
+			} else {
+				x = ins.Val
+			}
+		case bpf.LoadExtension:
+			switch ins.Num {
+			case bpf.ExtNetlinkAttr:
+				a = findAttribute(a, uint32(len(packet))-a, x)
+			case bpf.ExtNetlinkAttrNested:
+				attrLen := uint32(binary.NativeEndian.Uint16(packet[a:]))
+				a = findAttribute(a+4, attrLen-4, x)
+			default:
+				t.Fatalf("unexpected extension %d", ins.Num)
+			}
+		case bpf.StoreScratch:
+			memory[ins.N] = a
+		case bpf.LoadScratch:
+			a = memory[ins.N]
+		case bpf.TAX:
+			x = a
+		case bpf.Jump:
+			pc += int(ins.Skip)
+		case bpf.JumpIf:
+			var result bool
+			switch ins.Cond {
+			case bpf.JumpEqual:
+				result = a == ins.Val
+			case bpf.JumpBitsSet:
+				result = a&ins.Val != 0
+			default:
+				t.Fatalf("unexpected jump condition %d", ins.Cond)
+			}
+			if result {
+				pc += int(ins.SkipTrue)
+			} else {
+				pc += int(ins.SkipFalse)
+			}
+		case bpf.RetConstant:
+			return ins.Val
+		default:
+			t.Fatalf("unexpected instruction %T", ins)
+		}
+	}
+
+	t.Fatal("program did not return")
+	return 0
+}
+
+func __prefilter(t *testing.T, rules []string, fallback string) filter.Prefilter {
+	parsed, err := filter.ParseRules(rules)
+	require.NoError(t, err)
+	f, err := filter.NewFilterFromRules(parsed, filter.Options{Default: fallback})
+	require.NoError(t, err)
+	return f.Prefilter()
+}
+
+func compileDropsOtherProtocols(t *testing.T) {
+	instructions, err := compile(__prefilter(t, nil, ""), false)
+	require.NoError(t, err)
+
+	assert.Equal(t, uint32(verdictAccept), __run(t, instructions, __createMessage(t, uint8(conntrack.EventNew), syscall.IPPROTO_TCP, 0)))
+	assert.Equal(t, uint32(verdictAccept), __run(t, instructions, __createMessage(t, uint8(conntrack.EventNew), syscall.IPPROTO_UDP, 0)))
+	assert.Equal(t, uint32(verdictDrop), __run(t, instructions, __createMessage(t, uint8(conntrack.EventNew), syscall.IPPROTO_ICMP, 0)))
+}
+
+func compileAcceptsAllProtocols(t *testing.T) {
+	instructions, err := compile(__prefilter(t, nil, ""), true)
+	require.NoError(t, err)
+
+	assert.Equal(t, uint32(verdictAccept), __run(t, instructions, __createMessage(t, uint8(conntrack.EventNew), syscall.IPPROTO_TCP, 0)))
+	assert.Equal(t, uint32(verdictAccept), __run(t, instructions, __createMessage(t, uint8(conntrack.EventNew), syscall.IPPROTO_ICMP, 0)))
+
+	instructions, err = compile(__prefilter(t, []string{`drop protocol == "TCP"`}, ""), true)
+	require.NoError(t, err)
+
+	assert.Equal(t, uint32(verdictDrop), __run(t, instructions, __createMessage(t, uint8(conntrack.EventNew), syscall.IPPROTO_TCP, 0)))
+	assert.Equal(t, uint32(verdictAccept), __run(t, instructions, __createMessage(t, uint8(conntrack.EventNew), syscall.IPPROTO_ICMP, 0)))
+}
+
+func compileEvaluatesRules(t *testing.T) {
+	prefilter := __prefilter(t, []string{
+		`drop event.type == "UPDATE"`,
+		`drop mark in [1, 2] && protocol != "TCP"`,
+		`log event.type == "NEW" && destination.port == 53`,
+		`log protocol == "TCP" || mark == 3`,
+	}, "drop")
+
+	instructions, err := compile(prefilter, false)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		eventType uint8
+		proto     uint8
+		mark      uint32
+		expected  uint32
+	}{
+		{"update dropped", uint8(conntrack.EventUpdate), syscall.IPPROTO_TCP, 0, verdictDrop},
+		{"marked UDP dropped", uint8(conntrack.EventDestroy), syscall.IPPROTO_UDP, 2, verdictDrop},
+		{"marked TCP accepted", uint8(conntrack.EventDestroy), syscall.IPPROTO_TCP, 2, verdictAccept},
+		{"guard accepted", uint8(conntrack.EventNew), syscall.IPPROTO_UDP, 0, verdictAccept},
+		{"mark accepted", uint8(conntrack.EventDestroy), syscall.IPPROTO_UDP, 3, verdictAccept},
+		{"default", uint8(conntrack.EventDestroy), syscall.IPPROTO_UDP, 0, verdictDrop},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, __run(t, instructions, __createMessage(t, tt.eventType, tt.proto, tt.mark)))
+		})
+	}
+}
+
+func compileAppliesDefault(t *testing.T) {
+	prefilter := __prefilter(t, []string{`log protocol == "TCP" && event.type == "NEW"`}, "drop")
+	require.True(t, prefilter.Complete)
+
+	instructions, err := compile(prefilter, false)
+	require.NoError(t, err)
+
+	assert.Equal(t, uint32(verdictAccept), __run(t, instructions, __createMessage(t, uint8(conntrack.EventNew), syscall.IPPROTO_TCP, 0)))
+	assert.Equal(t, uint32(verdictDrop), __run(t, instructions, __createMessage(t, uint8(conntrack.EventNew), syscall.IPPROTO_UDP, 0)))
+	assert.Equal(t, uint32(verdictDrop), __run(t, instructions, __createMessage(t, uint8(conntrack.EventDestroy), syscall.IPPROTO_TCP, 0)))
+}
+
+func compileLongestLeavesRulesToUserspace(t *testing.T) {
+	values := make([]uint32, 300)
+	for i := range values {
+		values[i] = uint32(i)
+	}
+	prefilter := filter.Prefilter{
+		Events: filter.EventTypes,
+		Rules: []filter.PrefilterRule{
+			{Name: "small", Match: [][]filter.Condition{{{Field: filter.FieldMark, Values: []uint32{1}}}}, Exact: true},
+			{Name: "large", Match: [][]filter.Condition{{{Field: filter.FieldMark, Values: values, Negate: true}}}, Exact: true},
+		},
+		Complete: true,
+	}
+
+	_, err := compile(prefilter, false)
+	require.Error(t, err)
+
+	compiled, instructions, err := compileLongest(prefilter, false)
+	require.NoError(t, err)
+	assert.Len(t, compiled.Rules, 1)
+	assert.True(t, compiled.Accept)
+	assert.False(t, compiled.Complete)
+	assert.Equal(t, uint32(verdictDrop), __run(t, instructions, __createMessage(t, uint8(conntrack.EventNew), syscall.IPPROTO_TCP, 1)))
+}
+
+func TestBPF(t *testing.T) {
+	t.Run("bpf.compile drops other protocols", compileDropsOtherProtocols)
+	t.Run("bpf.compile accepts all protocols", compileAcceptsAllProtocols)
+	t.Run("bpf.compile evaluates rules", compileEvaluatesRules)
+	t.Run("bpf.compile applies default", compileAppliesDefault)
+	t.Run("bpf.compileLongest leaves rules to userspace", compileLongestLeavesRulesToUserspace)
+}
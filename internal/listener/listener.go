@@ -0,0 +1,433 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package listener
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/mdlayher/netlink"
+	"github.com/ti-mo/conntrack"
+	"github.com/ti-mo/netfilter"
+	"github.com/tschaefer/conntrackd/internal/filter"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// groups are the netfilter groups of the event types
+var groups = map[string]netfilter.NetlinkGroup{
+	"NEW":     netfilter.GroupCTNew,
+	"UPDATE":  netfilter.GroupCTUpdate,
+	"DESTROY": netfilter.GroupCTDestroy,
+}
+
+// NSIDUnknown is the network namespace id of messages received without it,
+// the kernel omits the id for the own network namespace and namespaces
+// without assigned id
+const NSIDUnknown = -1
+
+// messageBuffer is the size of the buffer receiving raw messages
+const messageBuffer = 64 * 1024
+
+// DefaultWorkers is the number of workers decoding events if not configured
+const DefaultWorkers = 4
+
+// Config is the configuration of the netlink socket
+type Config struct {
+	// ReadBuffer is the socket receive buffer size in bytes, 0 keeps the
+	// system default
+	ReadBuffer int
+	// Workers is the number of workers decoding events, 0 uses
+	// DefaultWorkers
+	Workers uint8
+	// ReportLoss keeps ENOBUFS errors enabled to count receive buffer
+	// overruns, otherwise lost events pass unnoticed
+	ReportLoss bool
+	// AllProtocols passes events of all protocols to the prefilter,
+	// otherwise only TCP and UDP events are received
+	AllProtocols bool
+}
+
+// Loss describes receive buffer overruns, each overrun lost an unknown
+// number of events
+type Loss struct {
+	// Overruns is the number of receive buffer overruns
+	Overruns uint64
+	// First and Last are the times of the first and last overrun
+	First time.Time
+	Last  time.Time
+}
+
+// Listener receives conntrack events from a netlink socket. Only the event
+// groups needed by the filter are subscribed and leading filter rules are
+// evaluated in the kernel by a socket filter.
+type Listener struct {
+	conn    *netlink.Conn
+	config  Config
+	joined  map[netfilter.NetlinkGroup]bool
+	workers sync.WaitGroup
+	closing atomic.Bool
+	mu      sync.Mutex
+
+	loss   Loss
+	lossMu sync.Mutex
+}
+
+// Message is a raw netlink message of a conntrack event
+type Message struct {
+	// Message is the netlink message as received from the kernel
+	Message netlink.Message
+	// Received is the receive time of the message
+	Received time.Time
+	// NSID is the id of the network namespace of the event as assigned in
+	// the network namespace of the listener, NSIDUnknown if not given
+	NSID int32
+}
+
+// Report describes the parts of a filter evaluated in the kernel
+type Report struct {
+	// Events are the subscribed event types
+	Events []string
+	// Rules are the names of the rules evaluated in the kernel
+	Rules []string
+	// Guards are the names of the rules whose conditions on protocol, event
+	// type and mark are pre-checked in the kernel
+	Guards []string
+	// Complete is set if no rule is left to the userspace filter
+	Complete bool
+	// Instructions is the length of the socket filter
+	Instructions int
+	// Fallback is the error attaching the socket filter, all rules are
+	// evaluated in userspace then
+	Fallback error
+}
+
+// Dial opens a netlink socket for conntrack events of all network
+// namespaces
+func Dial(config Config) (*Listener, error) {
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setup(conn, config); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if config.Workers == 0 {
+		config.Workers = DefaultWorkers
+	}
+
+	return &Listener{conn: conn, config: config, joined: make(map[netfilter.NetlinkGroup]bool)}, nil
+}
+
+// setup sets the socket options of the configuration
+func setup(conn *netlink.Conn, config Config) error {
+	if err := conn.SetOption(netlink.ListenAllNSID, true); err != nil {
+		return err
+	}
+	if !config.ReportLoss {
+		if err := conn.SetOption(netlink.NoENOBUFS, true); err != nil {
+			return err
+		}
+	}
+
+	if config.ReadBuffer > 0 {
+		if err := conn.SetReadBuffer(config.ReadBuffer); err != nil {
+			return fmt.Errorf("failed to set receive buffer size: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ReadBuffer returns the socket receive buffer size in bytes as reported by
+// the kernel, which doubles the configured size for bookkeeping overhead
+func (l *Listener) ReadBuffer() (int, error) {
+	return l.conn.ReadBuffer()
+}
+
+// Workers returns the number of workers decoding events
+func (l *Listener) Workers() uint8 {
+	return l.config.Workers
+}
+
+// TakeLoss returns the receive buffer overruns since the last call
+func (l *Listener) TakeLoss() Loss {
+	l.lossMu.Lock()
+	defer l.lossMu.Unlock()
+
+	loss := l.loss
+	l.loss = Loss{}
+	return loss
+}
+
+// countLoss counts a receive buffer overrun
+func (l *Listener) countLoss() {
+	l.lossMu.Lock()
+	defer l.lossMu.Unlock()
+
+	now := time.Now()
+	if l.loss.Overruns == 0 {
+		l.loss.First = now
+	}
+	l.loss.Overruns++
+	l.loss.Last = now
+}
+
+// Apply subscribes the event groups of the prefilter and attaches its
+// socket filter, replacing the previous ones. If the socket filter cannot
+// be compiled or attached, events are filtered in userspace only.
+func (l *Listener) Apply(prefilter filter.Prefilter) (Report, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	report := Report{Events: prefilter.Events, Complete: prefilter.Complete}
+
+	needed := make(map[netfilter.NetlinkGroup]bool)
+	for _, event := range prefilter.Events {
+		needed[groups[event]] = true
+	}
+	for _, group := range netfilter.GroupsCT {
+		switch {
+		case needed[group] && !l.joined[group]:
+			if err := l.conn.JoinGroup(uint32(group)); err != nil {
+				return report, fmt.Errorf("failed to join group %d: %w", group, err)
+			}
+		case !needed[group] && l.joined[group]:
+			if err := l.conn.LeaveGroup(uint32(group)); err != nil {
+				return report, fmt.Errorf("failed to leave group %d: %w", group, err)
+			}
+		}
+		l.joined[group] = needed[group]
+	}
+
+	prefilter, instructions, err := compileLongest(prefilter, l.config.AllProtocols)
+	if err == nil {
+		var raw []bpf.RawInstruction
+		raw, err = bpf.Assemble(instructions)
+		if err == nil {
+			err = l.conn.SetBPF(raw)
+		}
+	}
+	if err != nil {
+		_ = l.conn.RemoveBPF()
+		report.Complete, report.Fallback = false, err
+		return report, nil
+	}
+
+	for _, rule := range prefilter.Rules {
+		if rule.Exact {
+			report.Rules = append(report.Rules, rule.Name)
+		} else {
+			report.Guards = append(report.Guards, rule.Name)
+		}
+	}
+	report.Complete = prefilter.Complete
+	report.Instructions = len(instructions)
+
+	return report, nil
+}
+
+// compileLongest compiles the prefilter, leaving trailing rules to the
+// userspace filter as long as the socket filter is too large. Returns the
+// compiled prefilter.
+func compileLongest(prefilter filter.Prefilter, allProtocols bool) (filter.Prefilter, []bpf.Instruction, error) {
+	instructions, err := compile(prefilter, allProtocols)
+	for err != nil && len(prefilter.Rules) > 0 {
+		prefilter.Rules = prefilter.Rules[:len(prefilter.Rules)-1]
+		prefilter.Accept, prefilter.Complete = true, false
+		instructions, err = compile(prefilter, allProtocols)
+	}
+
+	return prefilter, instructions, err
+}
+
+// Listen starts the workers decoding events to evCh. Errors end the
+// workers and are sent on the returned channel. Closing the listener ends
+// the workers silently.
+func (l *Listener) Listen(evCh chan<- conntrack.Event) chan error {
+	errCh := make(chan error, l.config.Workers)
+	for id := range l.config.Workers {
+		l.workers.Add(1)
+		go l.receive(id, evCh, errCh)
+	}
+
+	return errCh
+}
+
+// closedBy reports whether the receive error is caused by closing the
+// socket. Reads blocked while closing fail with an internal error of the
+// runtime poller, these are told apart by the closing flag.
+func (l *Listener) closedBy(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	return l.closing.Load() || errors.Is(err, os.ErrClosed) || errors.Is(err, unix.EBADF)
+}
+
+// receive decodes netlink messages to events until the socket is closed
+func (l *Listener) receive(id uint8, evCh chan<- conntrack.Event, errCh chan<- error) {
+	defer l.workers.Done()
+
+	for {
+		messages, err := l.conn.Receive()
+
+		if l.closedBy(err) {
+			return
+		}
+		if l.config.ReportLoss && errors.Is(err, unix.ENOBUFS) {
+			l.countLoss()
+			continue
+		}
+		if err != nil {
+			errCh <- fmt.Errorf("receive netlink messages, closing worker %d: %w", id, err)
+			return
+		}
+
+		for _, message := range messages {
+			var event conntrack.Event
+			if err := event.Unmarshal(message); err != nil {
+				errCh <- fmt.Errorf("decode conntrack event: %w", err)
+				return
+			}
+			evCh <- event
+		}
+	}
+}
+
+// ListenMessages starts the workers receiving raw messages to msgCh, like
+// Listen. Messages are received bypassing the netlink connection to get the
+// network namespace id of the ancillary data, which is dropped by the
+// netlink library.
+func (l *Listener) ListenMessages(msgCh chan<- Message) chan error {
+	errCh := make(chan error, l.config.Workers)
+
+	raw, err := l.conn.SyscallConn()
+	if err != nil {
+		errCh <- fmt.Errorf("access netlink socket: %w", err)
+		return errCh
+	}
+
+	for id := range l.config.Workers {
+		l.workers.Add(1)
+		go l.receiveMessages(id, raw, msgCh, errCh)
+	}
+
+	return errCh
+}
+
+// receiveMessages receives raw messages until the socket is closed
+func (l *Listener) receiveMessages(id uint8, raw syscall.RawConn, msgCh chan<- Message, errCh chan<- error) {
+	defer l.workers.Done()
+
+	buf := make([]byte, messageBuffer)
+	oob := make([]byte, unix.CmsgSpace(4))
+	for {
+		var n, oobn int
+		var recvErr error
+		err := raw.Read(func(fd uintptr) bool {
+			n, oobn, _, _, recvErr = unix.Recvmsg(int(fd), buf, oob, unix.MSG_DONTWAIT)
+			return !errors.Is(recvErr, unix.EAGAIN)
+		})
+		received := time.Now()
+		if err == nil {
+			err = recvErr
+		}
+
+		if l.closedBy(err) {
+			return
+		}
+		if l.config.ReportLoss && errors.Is(err, unix.ENOBUFS) {
+			l.countLoss()
+			continue
+		}
+		if err != nil {
+			errCh <- fmt.Errorf("receive netlink messages, closing worker %d: %w", id, err)
+			return
+		}
+
+		messages, err := parseMessages(buf[:n], oob[:oobn], received)
+		if err != nil {
+			errCh <- fmt.Errorf("decode netlink messages: %w", err)
+			return
+		}
+		for _, message := range messages {
+			msgCh <- message
+		}
+	}
+}
+
+// parseMessages splits the received data into messages, copying the data.
+// The network namespace id is taken from the ancillary data.
+func parseMessages(data, oob []byte, received time.Time) ([]Message, error) {
+	nsid := int32(NSIDUnknown)
+	cmsgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	for _, cmsg := range cmsgs {
+		if cmsg.Header.Level == unix.SOL_NETLINK && cmsg.Header.Type == unix.NETLINK_LISTEN_ALL_NSID && len(cmsg.Data) >= 4 {
+			nsid = int32(binary.NativeEndian.Uint32(cmsg.Data))
+		}
+	}
+
+	nlmsgs, err := syscall.ParseNetlinkMessage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, 0, len(nlmsgs))
+	for _, nlmsg := range nlmsgs {
+		messages = append(messages, Message{
+			Message: netlink.Message{
+				Header: netlink.Header{
+					Length:   nlmsg.Header.Len,
+					Type:     netlink.HeaderType(nlmsg.Header.Type),
+					Flags:    netlink.HeaderFlags(nlmsg.Header.Flags),
+					Sequence: nlmsg.Header.Seq,
+					PID:      nlmsg.Header.Pid,
+				},
+				Data: bytes.Clone(nlmsg.Data),
+			},
+			Received: received,
+			NSID:     nsid,
+		})
+	}
+
+	return messages, nil
+}
+
+// Snapshot returns the flows of the conntrack table of the current network
+// namespace
+func Snapshot() ([]conntrack.Flow, error) {
+	conn, err := conntrack.Dial(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	return conn.Dump(nil)
+}
+
+// Close closes the socket and waits for the workers to end
+func (l *Listener) Close() error {
+	l.closing.Store(true)
+	err := l.conn.Close()
+	l.workers.Wait()
+
+	return err
+}
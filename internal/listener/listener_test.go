@@ -0,0 +1,178 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package listener
+
+import (
+	"encoding/binary"
+	"errors"
+	"iter"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ti-mo/conntrack"
+	"golang.org/x/sys/unix"
+)
+
+// socket is a netlink socket returning the queued results, EBADF when
+// drained like a closed socket
+type socket struct {
+	results []any
+}
+
+func (s *socket) Close() error                           { return nil }
+func (s *socket) Send(m netlink.Message) error           { return nil }
+func (s *socket) SendMessages(m []netlink.Message) error { return nil }
+
+func (s *socket) Receive() ([]netlink.Message, error) {
+	if len(s.results) == 0 {
+		return nil, unix.EBADF
+	}
+
+	result := s.results[0]
+	s.results = s.results[1:]
+	if err, ok := result.(error); ok {
+		return nil, err
+	}
+	return result.([]netlink.Message), nil
+}
+
+func (s *socket) ReceiveIter() iter.Seq2[netlink.Message, error] {
+	return func(yield func(netlink.Message, error) bool) {
+		messages, err := s.Receive()
+		if err != nil {
+			yield(netlink.Message{}, err)
+			return
+		}
+		for _, message := range messages {
+			if !yield(message, nil) {
+				return
+			}
+		}
+	}
+}
+
+func __createListener(t *testing.T, config Config, results ...any) *Listener {
+	data := __createMessage(t, uint8(conntrack.EventNew), syscall.IPPROTO_TCP, 0)
+	var message netlink.Message
+	require.NoError(t, message.UnmarshalBinary(data))
+
+	for i, result := range results {
+		if result == nil {
+			results[i] = []netlink.Message{message}
+		}
+	}
+
+	config.Workers = 1
+	return &Listener{conn: netlink.NewConn(&socket{results: results}, 0), config: config}
+}
+
+func receiveCountsLoss(t *testing.T) {
+	l := __createListener(t, Config{ReportLoss: true}, nil, unix.ENOBUFS, unix.ENOBUFS, nil)
+
+	evCh := make(chan conntrack.Event, 2)
+	errCh := l.Listen(evCh)
+	l.workers.Wait()
+
+	assert.Len(t, evCh, 2)
+	assert.Len(t, errCh, 0)
+
+	loss := l.TakeLoss()
+	assert.Equal(t, uint64(2), loss.Overruns)
+	assert.False(t, loss.First.After(loss.Last))
+	assert.Equal(t, Loss{}, l.TakeLoss())
+}
+
+func receiveFailsOnLossIfNotReported(t *testing.T) {
+	l := __createListener(t, Config{}, nil, unix.ENOBUFS, nil)
+
+	evCh := make(chan conntrack.Event, 2)
+	errCh := l.Listen(evCh)
+	l.workers.Wait()
+
+	assert.Len(t, evCh, 1)
+	require.Len(t, errCh, 1)
+	assert.ErrorIs(t, <-errCh, unix.ENOBUFS)
+	assert.Equal(t, Loss{}, l.TakeLoss())
+}
+
+func receiveEndsSilentlyOnClose(t *testing.T) {
+	closing := errors.New("use of closed file")
+	l := __createListener(t, Config{}, nil, closing)
+	require.NoError(t, l.Close())
+
+	evCh := make(chan conntrack.Event, 1)
+	errCh := l.Listen(evCh)
+	l.workers.Wait()
+
+	assert.Len(t, evCh, 1)
+	assert.Empty(t, errCh)
+}
+
+// __createNSID returns the ancillary data carrying the network namespace id
+func __createNSID(nsid int32) []byte {
+	oob := make([]byte, unix.CmsgSpace(4))
+	header := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	header.Level = unix.SOL_NETLINK
+	header.Type = unix.NETLINK_LISTEN_ALL_NSID
+	header.SetLen(unix.CmsgLen(4))
+	binary.NativeEndian.PutUint32(oob[unix.CmsgLen(0):], uint32(nsid))
+	return oob
+}
+
+func parseMessagesSplitsData(t *testing.T) {
+	data := append(
+		__createMessage(t, uint8(conntrack.EventNew), syscall.IPPROTO_TCP, 0),
+		__createMessage(t, uint8(conntrack.EventDestroy), syscall.IPPROTO_UDP, 0)...,
+	)
+	received := time.Now()
+
+	messages, err := parseMessages(data, __createNSID(7), received)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+
+	clear(data)
+	var events [2]conntrack.Event
+	for i, message := range messages {
+		assert.Equal(t, int32(7), message.NSID)
+		assert.Equal(t, received, message.Received)
+		require.NoError(t, events[i].Unmarshal(message.Message))
+	}
+	assert.Equal(t, conntrack.EventNew, events[0].Type)
+	assert.Equal(t, conntrack.EventDestroy, events[1].Type)
+}
+
+func parseMessagesWithoutNSID(t *testing.T) {
+	data := __createMessage(t, uint8(conntrack.EventNew), syscall.IPPROTO_TCP, 0)
+
+	messages, err := parseMessages(data, nil, time.Now())
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, int32(NSIDUnknown), messages[0].NSID)
+
+	_, err = parseMessages(data[:20], nil, time.Now())
+	assert.Error(t, err)
+}
+
+func listenMessagesFailsWithoutSocket(t *testing.T) {
+	l := __createListener(t, Config{})
+
+	errCh := l.ListenMessages(make(chan Message))
+	require.Len(t, errCh, 1)
+	assert.ErrorContains(t, <-errCh, "access netlink socket")
+}
+
+func TestListener(t *testing.T) {
+	t.Run("listener.receive counts loss", receiveCountsLoss)
+	t.Run("listener.receive fails on loss if not reported", receiveFailsOnLossIfNotReported)
+	t.Run("listener.receive ends silently on close", receiveEndsSilentlyOnClose)
+	t.Run("listener.parseMessages splits data", parseMessagesSplitsData)
+	t.Run("listener.parseMessages without network namespace id", parseMessagesWithoutNSID)
+	t.Run("listener.ListenMessages fails without socket", listenMessagesFailsWithoutSocket)
+}
@@ -19,29 +19,51 @@ type Logger struct {
 var (
 	// Supported log levels
 	Levels = []string{"debug", "info", "warn", "error"}
-	// Current log level
-	level slog.Level
+	// Current log level, shared by all loggers and changed by SetLevel
+	level slog.LevelVar
 )
 
 // NewLogger creates a new Logger with the specified log level.
 func NewLogger(levelStr string) (*Logger, error) {
-	err := level.UnmarshalText([]byte(levelStr))
+	l, err := parseLevel(levelStr)
 	if err != nil {
-		return nil, fmt.Errorf("unknown log level: %q", levelStr)
+		return nil, err
 	}
+	level.Set(l)
 
-	o := &slog.HandlerOptions{Level: level}
-	if level == slog.LevelDebug {
+	o := &slog.HandlerOptions{Level: &level}
+	if l == slog.LevelDebug {
 		o.AddSource = true
 	}
 
 	return &Logger{
 		Logger: slog.New(slog.NewJSONHandler(os.Stderr, o)),
-		Level:  level,
+		Level:  l,
 	}, nil
 }
 
+// SetLevel changes the log level of all loggers at runtime.
+func SetLevel(levelStr string) error {
+	l, err := parseLevel(levelStr)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+
+	return nil
+}
+
 // Level returns the current log level.
 func Level() slog.Level {
-	return level
+	return level.Level()
+}
+
+// parseLevel parses a log level name.
+func parseLevel(levelStr string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(levelStr)); err != nil {
+		return l, fmt.Errorf("unknown log level: %q", levelStr)
+	}
+
+	return l, nil
 }
@@ -5,6 +5,7 @@ Licensed under the MIT License, see LICENSE file in the project root for details
 package logger
 
 import (
+	"context"
 	"log/slog"
 	"strings"
 	"testing"
@@ -42,8 +43,22 @@ func levelReturnsCorrectLogLevel(t *testing.T) {
 	}
 }
 
+func setLevelChangesLogLevel(t *testing.T) {
+	logger, err := NewLogger("info")
+	assert.NoError(t, err)
+	assert.False(t, logger.Logger.Enabled(context.Background(), slog.LevelDebug))
+
+	assert.NoError(t, SetLevel("debug"))
+	assert.Equal(t, slog.LevelDebug, Level())
+	assert.True(t, logger.Logger.Enabled(context.Background(), slog.LevelDebug))
+
+	assert.EqualError(t, SetLevel("verbose"), `unknown log level: "verbose"`)
+	assert.Equal(t, slog.LevelDebug, Level())
+}
+
 func TestLogger(t *testing.T) {
 	t.Run("logger.New returns error if log level is invalid", newReturnsErrorIfLogLevelIsInvalid)
 	t.Run("logger.New returns logger if log level is valid", newReturnsLoggerIfLogLevelIsValid)
 	t.Run("logger.Level returns correct log level", levelReturnsCorrectLogLevel)
+	t.Run("logger.SetLevel changes log level", setLevelChangesLogLevel)
 }
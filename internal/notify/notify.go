@@ -0,0 +1,76 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package notify
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	"golang.org/x/sys/unix"
+)
+
+// Enabled reports whether the service is started by systemd with a
+// notification socket. Without it all notifications are skipped.
+func Enabled() bool {
+	return os.Getenv("NOTIFY_SOCKET") != ""
+}
+
+// Ready notifies systemd that the service is up, after startup or a reload.
+func Ready() {
+	send(daemon.SdNotifyReady)
+}
+
+// Reloading notifies systemd that the service reloads its configuration.
+// Ready follows when done.
+func Reloading() {
+	send(fmt.Sprintf("%s\nMONOTONIC_USEC=%d", daemon.SdNotifyReloading, monotonic()))
+}
+
+// Stopping notifies systemd that the service shuts down.
+func Stopping() {
+	send(daemon.SdNotifyStopping)
+}
+
+// Status sends a single line status shown by systemctl status.
+func Status(status string) {
+	send("STATUS=" + status)
+}
+
+// Watchdog notifies the watchdog of systemd that the service is alive.
+func Watchdog() {
+	send(daemon.SdNotifyWatchdog)
+}
+
+// WatchdogInterval returns the interval of watchdog notifications, half of
+// the watchdog timeout of systemd, 0 if the watchdog is disabled.
+func WatchdogInterval() time.Duration {
+	timeout, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		slog.Warn("Invalid systemd watchdog configuration.", "error", err)
+		return 0
+	}
+
+	return timeout / 2
+}
+
+// send sends the state to the notification socket, if any.
+func send(state string) {
+	if _, err := daemon.SdNotify(false, state); err != nil {
+		slog.Debug("Failed to notify systemd.", "state", state, "error", err)
+	}
+}
+
+// monotonic returns the time of the monotonic clock in microseconds.
+func monotonic() int64 {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0
+	}
+
+	return ts.Nano() / int64(time.Microsecond)
+}
@@ -0,0 +1,92 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package notify
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// __listen sets NOTIFY_SOCKET to a fake notification socket and returns it.
+func __listen(t *testing.T) *net.UnixConn {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	return conn
+}
+
+func __receive(t *testing.T, conn *net.UnixConn) string {
+	buf := make([]byte, 4096)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+
+	return string(buf[:n])
+}
+
+func notificationsAreSent(t *testing.T) {
+	conn := __listen(t)
+	assert.True(t, Enabled())
+
+	Ready()
+	assert.Equal(t, "READY=1", __receive(t, conn))
+	Status("Processing 1.0 events/s")
+	assert.Equal(t, "STATUS=Processing 1.0 events/s", __receive(t, conn))
+	Watchdog()
+	assert.Equal(t, "WATCHDOG=1", __receive(t, conn))
+	Stopping()
+	assert.Equal(t, "STOPPING=1", __receive(t, conn))
+
+	Reloading()
+	lines := strings.Split(__receive(t, conn), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, "RELOADING=1", lines[0])
+	usec, err := strconv.ParseInt(strings.TrimPrefix(lines[1], "MONOTONIC_USEC="), 10, 64)
+	assert.NoError(t, err)
+	assert.Positive(t, usec)
+}
+
+func notificationsAreSkippedWithoutSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	assert.False(t, Enabled())
+
+	Ready()
+	Watchdog()
+}
+
+func watchdogIntervalIsHalfTimeout(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	assert.Zero(t, WatchdogInterval())
+
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	assert.Equal(t, 15*time.Second, WatchdogInterval())
+
+	t.Setenv("WATCHDOG_PID", "1")
+	assert.Zero(t, WatchdogInterval(), "watchdog of another process")
+
+	t.Setenv("WATCHDOG_PID", "")
+	t.Setenv("WATCHDOG_USEC", "invalid")
+	assert.Zero(t, WatchdogInterval())
+}
+
+func TestNotify(t *testing.T) {
+	t.Run("notify sends notifications", notificationsAreSent)
+	t.Run("notify skips notifications without socket", notificationsAreSkippedWithoutSocket)
+	t.Run("notify.WatchdogInterval is half the timeout", watchdogIntervalIsHalfTimeout)
+}
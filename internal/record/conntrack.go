@@ -0,0 +1,98 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package record
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"syscall"
+
+	"github.com/ti-mo/conntrack"
+)
+
+// Conntrack returns the conntrack event described by the record, e.g. to
+// evaluate recorded events against filter rules. Geolocation and summary
+// fields have no conntrack representation and are ignored.
+func (e *Event) Conntrack() (conntrack.Event, error) {
+	var event conntrack.Event
+
+	switch e.Type {
+	case "NEW":
+		event.Type = conntrack.EventNew
+	case "UPDATE":
+		event.Type = conntrack.EventUpdate
+	case "DESTROY":
+		event.Type = conntrack.EventDestroy
+	default:
+		return event, fmt.Errorf("invalid event type %q", e.Type)
+	}
+
+	flow, err := e.FlowEvent()
+	flow.Type = event.Type
+	return flow, err
+}
+
+// FlowEvent returns the conntrack event of the flow described by the record
+// without event type, e.g. to evaluate summaries against filter rules.
+func (e *Event) FlowEvent() (conntrack.Event, error) {
+	var event conntrack.Event
+
+	var proto uint8
+	switch e.Protocol {
+	case "TCP":
+		proto = syscall.IPPROTO_TCP
+	case "UDP":
+		proto = syscall.IPPROTO_UDP
+	default:
+		return event, fmt.Errorf("invalid protocol %q", e.Protocol)
+	}
+
+	src, err := netip.ParseAddr(e.SrcAddr)
+	if err != nil {
+		return event, fmt.Errorf("invalid source address %q", e.SrcAddr)
+	}
+	dst, err := netip.ParseAddr(e.DstAddr)
+	if err != nil {
+		return event, fmt.Errorf("invalid destination address %q", e.DstAddr)
+	}
+
+	flow := conntrack.NewFlow(proto, 0, src, dst, e.SrcPort, e.DstPort, 0, 0)
+	flow.ID = e.Flow
+
+	if e.TCPState != "" {
+		state := slices.Index(tcpStates, e.TCPState)
+		if state < 0 {
+			return event, fmt.Errorf("invalid TCP state %q", e.TCPState)
+		}
+		flow.ProtoInfo.TCP = &conntrack.ProtoInfoTCP{State: uint8(state)}
+	}
+
+	if e.NATSrcAddr != "" {
+		addr, err := netip.ParseAddr(e.NATSrcAddr)
+		if err != nil {
+			return event, fmt.Errorf("invalid NAT source address %q", e.NATSrcAddr)
+		}
+		flow.TupleReply.IP.DestinationAddress = addr
+		flow.TupleReply.Proto.DestinationPort = e.NATSrcPort
+		flow.Status |= conntrack.StatusSrcNAT
+	}
+	if e.NATDstAddr != "" {
+		addr, err := netip.ParseAddr(e.NATDstAddr)
+		if err != nil {
+			return event, fmt.Errorf("invalid NAT destination address %q", e.NATDstAddr)
+		}
+		flow.TupleReply.IP.SourceAddress = addr
+		flow.TupleReply.Proto.SourcePort = e.NATDstPort
+		flow.Status |= conntrack.StatusDstNAT
+	}
+
+	flow.CountersOrig = conntrack.Counter{Packets: e.OrigPackets, Bytes: e.OrigBytes}
+	flow.CountersReply = conntrack.Counter{Direction: true, Packets: e.ReplyPackets, Bytes: e.ReplyBytes}
+
+	event.Flow = &flow
+
+	return event, nil
+}
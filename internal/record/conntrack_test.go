@@ -0,0 +1,91 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package record
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ti-mo/conntrack"
+)
+
+func conntrackRoundTripsEvent(t *testing.T) {
+	e := &Event{
+		SchemaVersion: SchemaVersion,
+		Type:          "DESTROY",
+		Flow:          1234,
+		Protocol:      "TCP",
+		SrcAddr:       "10.19.80.100",
+		DstAddr:       "2.2.2.2",
+		SrcPort:       4711,
+		DstPort:       443,
+		TCPState:      "TIME_WAIT",
+		NATSrcAddr:    "192.0.2.1",
+		NATSrcPort:    61000,
+		NATDstAddr:    "10.19.80.200",
+		NATDstPort:    8443,
+		OrigPackets:   10,
+		OrigBytes:     1000,
+		ReplyPackets:  8,
+		ReplyBytes:    4000,
+	}
+
+	event, err := e.Conntrack()
+	assert.NoError(t, err)
+
+	roundTrip := NewEvent(event, nil)
+	roundTrip.CommunityID = ""
+	assert.Equal(t, e, roundTrip)
+
+	e = NewEvent(__createEvent(syscall.IPPROTO_UDP, "2003:cf::1", "2a01:4f8::2"), nil)
+	e.TCPState = ""
+	event, err = e.Conntrack()
+	assert.NoError(t, err)
+	assert.Equal(t, e, NewEvent(event, nil))
+}
+
+func conntrackReturnsErrorOnInvalidFields(t *testing.T) {
+	valid := Event{Type: "NEW", Protocol: "TCP", SrcAddr: "10.0.0.1", DstAddr: "8.8.8.8"}
+
+	tests := []struct {
+		name   string
+		modify func(e *Event)
+		err    string
+	}{
+		{"type", func(e *Event) { e.Type = "SUMMARY" }, `invalid event type "SUMMARY"`},
+		{"protocol", func(e *Event) { e.Protocol = "ICMP" }, `invalid protocol "ICMP"`},
+		{"source address", func(e *Event) { e.SrcAddr = "10.0.0" }, `invalid source address "10.0.0"`},
+		{"destination address", func(e *Event) { e.DstAddr = "" }, `invalid destination address ""`},
+		{"tcp state", func(e *Event) { e.TCPState = "OPEN" }, `invalid TCP state "OPEN"`},
+	}
+
+	for _, tt := range tests {
+		e := valid
+		tt.modify(&e)
+		_, err := e.Conntrack()
+		assert.EqualError(t, err, tt.err, tt.name)
+	}
+}
+
+func flowEventAcceptsSummary(t *testing.T) {
+	e := Event{Type: "SUMMARY", Flow: 7, Protocol: "UDP", SrcAddr: "10.0.0.1", DstAddr: "8.8.8.8", SrcPort: 4711, DstPort: 53, Events: 3}
+
+	event, err := e.FlowEvent()
+	assert.NoError(t, err)
+	assert.Equal(t, conntrack.EventUnknown, event.Type)
+	assert.Equal(t, uint32(7), event.Flow.ID)
+	assert.Equal(t, uint16(53), event.Flow.TupleOrig.Proto.DestinationPort)
+
+	e.Protocol = "ICMP"
+	_, err = e.FlowEvent()
+	assert.EqualError(t, err, `invalid protocol "ICMP"`)
+}
+
+func TestConntrack(t *testing.T) {
+	t.Run("event.Conntrack round trips event", conntrackRoundTripsEvent)
+	t.Run("event.Conntrack returns error on invalid fields", conntrackReturnsErrorOnInvalidFields)
+	t.Run("event.FlowEvent accepts summary", flowEventAcceptsSummary)
+}
@@ -0,0 +1,196 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package record
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ti-mo/conntrack"
+	"github.com/tschaefer/conntrackd/internal/communityid"
+	"github.com/tschaefer/conntrackd/internal/geoip"
+)
+
+// SchemaVersion is the version of the event schema, see
+// docs/schema/event.v2.json. It is increased on breaking changes only, new
+// optional fields may be added within a version. Version 2 writes the fields
+// flat in all sinks, version 1 nested them under event in syslog and prefixed
+// them with EVENT_ in journald.
+const SchemaVersion = 2
+
+// Event is a conntrack event record. Its fields define the record schema, all
+// sinks serialize records from this struct.
+type Event struct {
+	SchemaVersion int    `json:"schema_version"`
+	Type          string `json:"type"`
+	Flow          uint32 `json:"flow"`
+	Protocol      string `json:"prot"`
+	SrcAddr       string `json:"src_addr"`
+	DstAddr       string `json:"dst_addr"`
+	SrcPort       uint16 `json:"src_port"`
+	DstPort       uint16 `json:"dst_port"`
+	TCPState      string `json:"tcp_state,omitempty"`
+	CommunityID   string `json:"community_id,omitempty"`
+
+	SrcCity    string   `json:"src_city,omitempty"`
+	SrcCountry string   `json:"src_country,omitempty"`
+	SrcLat     *float64 `json:"src_lat,omitempty"`
+	SrcLon     *float64 `json:"src_lon,omitempty"`
+	DstCity    string   `json:"dst_city,omitempty"`
+	DstCountry string   `json:"dst_country,omitempty"`
+	DstLat     *float64 `json:"dst_lat,omitempty"`
+	DstLon     *float64 `json:"dst_lon,omitempty"`
+
+	SrcASN   uint   `json:"src_asn,omitempty"`
+	SrcASOrg string `json:"src_as_org,omitempty"`
+	DstASN   uint   `json:"dst_asn,omitempty"`
+	DstASOrg string `json:"dst_as_org,omitempty"`
+
+	NATSrcAddr string `json:"nat_src_addr,omitempty"`
+	NATSrcPort uint16 `json:"nat_src_port,omitempty"`
+	NATDstAddr string `json:"nat_dst_addr,omitempty"`
+	NATDstPort uint16 `json:"nat_dst_port,omitempty"`
+
+	OrigPackets  uint64 `json:"orig_packets,omitempty"`
+	OrigBytes    uint64 `json:"orig_bytes,omitempty"`
+	ReplyPackets uint64 `json:"reply_packets,omitempty"`
+	ReplyBytes   uint64 `json:"reply_bytes,omitempty"`
+
+	FirstSeen time.Time `json:"first_seen,omitzero"`
+	LastSeen  time.Time `json:"last_seen,omitzero"`
+	Duration  *float64  `json:"duration,omitempty"`
+	Events    uint64    `json:"events,omitempty"`
+	TCPStates []string  `json:"tcp_states,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+
+	Tags []string `json:"tags,omitempty"`
+
+	// Level is the log level of the record, raised to warn by alerts.
+	Level slog.Level `json:"-"`
+	// Sinks are the sinks the record is routed to, empty for all sinks.
+	Sinks []string `json:"-"`
+}
+
+// Policy is the handling of a record decided by the filter.
+type Policy struct {
+	Tags  []string
+	Alert bool
+	Sinks []string
+}
+
+// Apply applies a policy to the event record. Tags are added, alerts raise the
+// level and the sinks replace the previous ones, if given.
+func (e *Event) Apply(policy Policy) {
+	for _, tag := range policy.Tags {
+		if !slices.Contains(e.Tags, tag) {
+			e.Tags = append(e.Tags, tag)
+		}
+	}
+	if policy.Alert {
+		e.Level = slog.LevelWarn
+	}
+	if len(policy.Sinks) > 0 {
+		e.Sinks = policy.Sinks
+	}
+}
+
+// NewEvent creates an event record from a conntrack event with optional
+// geolocation and ASN data.
+func NewEvent(event conntrack.Event, geo *geoip.Lookup) *Event {
+	e := &Event{
+		SchemaVersion: SchemaVersion,
+		Type:          getType(event),
+		Flow:          event.Flow.ID,
+		Protocol:      getProtocol(event),
+		SrcAddr:       event.Flow.TupleOrig.IP.SourceAddress.String(),
+		DstAddr:       event.Flow.TupleOrig.IP.DestinationAddress.String(),
+		SrcPort:       event.Flow.TupleOrig.Proto.SourcePort,
+		DstPort:       event.Flow.TupleOrig.Proto.DestinationPort,
+	}
+
+	e.SetFlowState(event.Flow)
+
+	if event.Flow.TupleOrig.IP.SourceAddress.IsValid() && event.Flow.TupleOrig.IP.DestinationAddress.IsValid() {
+		e.CommunityID = communityid.FlowHash(event.Flow)
+	}
+
+	setLocation(e, event, geo)
+
+	return e
+}
+
+// SetFlowState sets the fields changing during the lifetime of a flow: TCP
+// state, NAT mapping and counters.
+func (e *Event) SetFlowState(flow *conntrack.Flow) {
+	if state, ok := getTCPState(flow); ok {
+		e.TCPState = state
+	}
+
+	setNAT(e, flow)
+
+	e.OrigPackets, e.OrigBytes = flow.CountersOrig.Packets, flow.CountersOrig.Bytes
+	e.ReplyPackets, e.ReplyBytes = flow.CountersReply.Packets, flow.CountersReply.Bytes
+}
+
+// Message returns the human-readable record message.
+func (e *Event) Message() string {
+	return fmt.Sprintf("%s %s connection from %s to %s",
+		e.Type, e.Protocol,
+		formatAddrPort(e.SrcAddr, e.SrcPort),
+		formatAddrPort(e.DstAddr, e.DstPort),
+	)
+}
+
+// Attrs returns the event fields as flat slog attributes in schema order.
+// Keys are the JSON field names, empty optional fields are omitted.
+func (e *Event) Attrs() []slog.Attr {
+	return structAttrs(reflect.ValueOf(e).Elem())
+}
+
+// structAttrs returns the fields of a record struct as flat slog attributes
+// in field order, keyed by their JSON field names.
+func structAttrs(v reflect.Value) []slog.Attr {
+	t := v.Type()
+
+	attrs := make([]slog.Attr, 0, t.NumField())
+	for i := range t.NumField() {
+		name, opts, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		field := v.Field(i)
+		if opts != "" && isEmpty(field) {
+			continue
+		}
+		if field.Kind() == reflect.Pointer {
+			field = field.Elem()
+		}
+
+		attrs = append(attrs, slog.Any(name, field.Interface()))
+	}
+
+	return attrs
+}
+
+// isEmpty reports whether a field is omitted by omitempty or omitzero.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
+
+// Log writes the event record to the given logger.
+func (e *Event) Log(logger *slog.Logger) {
+	logger.LogAttrs(context.Background(), e.Level, e.Message(), e.Attrs()...)
+}
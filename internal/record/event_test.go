@@ -0,0 +1,242 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package record
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net/netip"
+	"os"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ti-mo/conntrack"
+)
+
+func __createEvent(proto uint8, src, dst string) conntrack.Event {
+	flow := conntrack.NewFlow(
+		proto,
+		conntrack.StatusAssured,
+		netip.MustParseAddr(src), netip.MustParseAddr(dst),
+		4711, 443,
+		60, 0,
+	)
+	flow.ID = 1234
+	flow.ProtoInfo.TCP = &conntrack.ProtoInfoTCP{State: 3}
+
+	return conntrack.Event{
+		Type: conntrack.EventNew,
+		Flow: &flow,
+	}
+}
+
+func __createFullEvent() *Event {
+	lat := 1.0
+	e := NewEvent(__createEvent(syscall.IPPROTO_TCP, "10.19.80.100", "78.47.60.169"), nil)
+	e.SrcCity, e.SrcCountry, e.SrcLat, e.SrcLon = "city", "country", &lat, &lat
+	e.DstCity, e.DstCountry, e.DstLat, e.DstLon = "city", "country", &lat, &lat
+	e.SrcASN, e.SrcASOrg, e.DstASN, e.DstASOrg = 1, "org", 2, "org"
+	e.Tags = []string{"tag"}
+	e.NATSrcAddr, e.NATSrcPort, e.NATDstAddr, e.NATDstPort = "1.2.3.4", 1, "5.6.7.8", 2
+	e.OrigPackets, e.OrigBytes, e.ReplyPackets, e.ReplyBytes = 1, 2, 3, 4
+	e.FirstSeen, e.LastSeen, e.Duration, e.Events = time.Now(), time.Now(), &lat, 3
+	e.TCPStates, e.Reason = []string{"SYN_SENT", "ESTABLISHED"}, "destroy"
+	return e
+}
+
+func newEventReturnsEvent(t *testing.T) {
+	e := NewEvent(__createEvent(syscall.IPPROTO_TCP, "10.19.80.100", "78.47.60.169"), nil)
+
+	assert.Equal(t, &Event{
+		SchemaVersion: SchemaVersion,
+		Type:          "NEW",
+		Flow:          1234,
+		Protocol:      "TCP",
+		SrcAddr:       "10.19.80.100",
+		DstAddr:       "78.47.60.169",
+		SrcPort:       4711,
+		DstPort:       443,
+		TCPState:      "ESTABLISHED",
+		CommunityID:   "1:L+EFPIdwYJq4V31P+Rx2klPMbik=",
+	}, e)
+}
+
+func newEventSetsNATAndCounters(t *testing.T) {
+	event := __createEvent(syscall.IPPROTO_TCP, "10.19.80.100", "78.47.60.169")
+	event.Flow.TupleReply.IP.DestinationAddress = netip.MustParseAddr("192.0.2.1")
+	event.Flow.TupleReply.Proto.DestinationPort = 61000
+	event.Flow.CountersOrig = conntrack.Counter{Packets: 10, Bytes: 1000}
+	event.Flow.CountersReply = conntrack.Counter{Direction: true, Packets: 8, Bytes: 4000}
+
+	e := NewEvent(event, nil)
+	assert.Equal(t, "192.0.2.1", e.NATSrcAddr)
+	assert.Equal(t, uint16(61000), e.NATSrcPort)
+	assert.Empty(t, e.NATDstAddr)
+	assert.Zero(t, e.NATDstPort)
+	assert.Equal(t, []uint64{10, 1000, 8, 4000}, []uint64{e.OrigPackets, e.OrigBytes, e.ReplyPackets, e.ReplyBytes})
+
+	event = __createEvent(syscall.IPPROTO_TCP, "10.19.80.100", "192.0.2.1")
+	event.Flow.TupleReply.IP.SourceAddress = netip.MustParseAddr("10.19.80.200")
+	event.Flow.TupleReply.Proto.SourcePort = 8443
+
+	e = NewEvent(event, nil)
+	assert.Empty(t, e.NATSrcAddr)
+	assert.Equal(t, "10.19.80.200", e.NATDstAddr)
+	assert.Equal(t, uint16(8443), e.NATDstPort)
+}
+
+func messageFormatsAddresses(t *testing.T) {
+	e := NewEvent(__createEvent(syscall.IPPROTO_TCP, "10.19.80.100", "78.47.60.169"), nil)
+	assert.Equal(t, "NEW TCP connection from 10.19.80.100:4711 to 78.47.60.169:443", e.Message())
+
+	e = NewEvent(__createEvent(syscall.IPPROTO_UDP, "2003:cf::1", "2a01:4f8::2"), nil)
+	assert.Equal(t, "NEW UDP connection from [2003:cf::1]:4711 to [2a01:4f8::2]:443", e.Message())
+}
+
+func attrsReturnsFieldsInSchemaOrder(t *testing.T) {
+	lat, lon := 0.0, 11.1026
+	e := &Event{
+		SchemaVersion: SchemaVersion,
+		Type:          "NEW",
+		Flow:          1234,
+		Protocol:      "UDP",
+		SrcAddr:       "10.19.80.100",
+		DstAddr:       "78.47.60.169",
+		SrcPort:       4711,
+		DstPort:       53,
+		DstCountry:    "Germany",
+		DstLat:        &lat,
+		DstLon:        &lon,
+	}
+
+	var keys []string
+	for _, attr := range e.Attrs() {
+		keys = append(keys, attr.Key)
+	}
+	assert.Equal(t, []string{
+		"schema_version", "type", "flow", "prot",
+		"src_addr", "dst_addr", "src_port", "dst_port",
+		"dst_country", "dst_lat", "dst_lon",
+	}, keys)
+
+	attrs := e.Attrs()
+	assert.Equal(t, slog.KindUint64, attrs[2].Value.Kind())
+	assert.Equal(t, slog.KindFloat64, attrs[9].Value.Kind())
+	assert.Equal(t, 0.0, attrs[9].Value.Float64())
+}
+
+func attrsMatchJSONEncoding(t *testing.T) {
+	e := __createFullEvent()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	e.Log(logger)
+
+	var logged map[string]any
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &logged))
+	delete(logged, "time")
+	delete(logged, "level")
+	delete(logged, "msg")
+
+	data, err := json.Marshal(e)
+	assert.NoError(t, err)
+	var marshaled map[string]any
+	assert.NoError(t, json.Unmarshal(data, &marshaled))
+
+	assert.Equal(t, marshaled, logged)
+}
+
+// schemaDef is a record definition of the event schema
+type schemaDef struct {
+	Required   []string                  `json:"required"`
+	Properties map[string]map[string]any `json:"properties"`
+}
+
+// __readSchema returns the record definitions of the event schema by name
+func __readSchema(t *testing.T) map[string]schemaDef {
+	data, err := os.ReadFile("../../docs/schema/event.v2.json")
+	assert.NoError(t, err)
+
+	var schema struct {
+		Defs map[string]schemaDef `json:"$defs"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &schema))
+	return schema.Defs
+}
+
+// __validate checks the JSON encoding of the record against the definition:
+// required and declared properties, const, enum and type of the values.
+func __validate(t *testing.T, def schemaDef, r any) {
+	data, err := json.Marshal(r)
+	assert.NoError(t, err)
+	var encoded map[string]any
+	assert.NoError(t, json.Unmarshal(data, &encoded))
+
+	for _, name := range def.Required {
+		assert.Contains(t, encoded, name, "required property")
+	}
+	for name, value := range encoded {
+		property, ok := def.Properties[name]
+		if !assert.True(t, ok, "undeclared property %q", name) {
+			continue
+		}
+		if expected, ok := property["const"]; ok {
+			assert.Equal(t, expected, value, name)
+		}
+		if enum, ok := property["enum"].([]any); ok {
+			assert.Contains(t, enum, value, name)
+		}
+		switch property["type"] {
+		case "string":
+			assert.IsType(t, "", value, name)
+		case "integer":
+			if assert.IsType(t, float64(0), value, name) {
+				assert.Equal(t, float64(int64(value.(float64))), value, name)
+			}
+		case "number":
+			assert.IsType(t, float64(0), value, name)
+		case "array":
+			assert.IsType(t, []any{}, value, name)
+		}
+	}
+}
+
+func schemaDescribesAllFields(t *testing.T) {
+	schema := __readSchema(t)["event"]
+	assert.Equal(t, float64(SchemaVersion), schema.Properties["schema_version"]["const"])
+
+	e := __createFullEvent()
+	__validate(t, schema, e)
+
+	var fields []string
+	for _, attr := range e.Attrs() {
+		fields = append(fields, attr.Key)
+	}
+	assert.ElementsMatch(t, fields, slices.Collect(maps.Keys(schema.Properties)))
+
+	required := NewEvent(__createEvent(syscall.IPPROTO_UDP, "10.19.80.100", "78.47.60.169"), nil)
+	required.TCPState = ""
+	__validate(t, schema, required)
+	fields = nil
+	for _, attr := range required.Attrs() {
+		fields = append(fields, attr.Key)
+	}
+	assert.Subset(t, fields, schema.Required)
+	assert.ElementsMatch(t, fields, append(schema.Required, "community_id"), "required fields and Community ID")
+}
+
+func TestEvent(t *testing.T) {
+	t.Run("event.NewEvent returns event", newEventReturnsEvent)
+	t.Run("event.NewEvent sets NAT and counters", newEventSetsNATAndCounters)
+	t.Run("event.Message formats addresses", messageFormatsAddresses)
+	t.Run("event.Attrs returns fields in schema order", attrsReturnsFieldsInSchemaOrder)
+	t.Run("event.Attrs match JSON encoding", attrsMatchJSONEncoding)
+	t.Run("event schema describes all fields", schemaDescribesAllFields)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package record
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"time"
+)
+
+const (
+	// GapOverrun is the gap reason of events lost on receive buffer
+	// overruns.
+	GapOverrun = "overrun"
+	// GapReconnect is the gap reason of events missed while reconnecting
+	// after a listener error.
+	GapReconnect = "reconnect"
+)
+
+// Gap is a record of conntrack events possibly missing in between first_seen
+// and last_seen. It is always logged at warn level to all sinks.
+type Gap struct {
+	SchemaVersion int       `json:"schema_version"`
+	Type          string    `json:"type"`
+	Reason        string    `json:"reason"`
+	FirstSeen     time.Time `json:"first_seen"`
+	LastSeen      time.Time `json:"last_seen"`
+	Overruns      uint64    `json:"overruns,omitempty"`
+	Attempts      int       `json:"attempts,omitempty"`
+}
+
+// NewGap creates a gap record.
+func NewGap(reason string, first, last time.Time) *Gap {
+	return &Gap{
+		SchemaVersion: SchemaVersion,
+		Type:          "GAP",
+		Reason:        reason,
+		FirstSeen:     first,
+		LastSeen:      last,
+	}
+}
+
+// Message returns the human-readable record message.
+func (g *Gap) Message() string {
+	switch {
+	case g.Overruns > 0:
+		return fmt.Sprintf("GAP in conntrack events, %d receive buffer overruns", g.Overruns)
+	case g.Attempts > 0:
+		return fmt.Sprintf("GAP in conntrack events, reconnected after %d attempts", g.Attempts)
+	}
+	return fmt.Sprintf("GAP in conntrack events, %s", g.Reason)
+}
+
+// Attrs returns the gap fields as flat slog attributes, see Event.Attrs.
+func (g *Gap) Attrs() []slog.Attr {
+	return structAttrs(reflect.ValueOf(g).Elem())
+}
+
+// Log writes the gap record to the given logger.
+func (g *Gap) Log(logger *slog.Logger) {
+	logger.LogAttrs(context.Background(), slog.LevelWarn, g.Message(), g.Attrs()...)
+}
@@ -0,0 +1,100 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package record
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func __createGap() *Gap {
+	first := time.Date(2025, 11, 25, 12, 35, 11, 0, time.UTC)
+	gap := NewGap(GapOverrun, first, first.Add(3*time.Second))
+	gap.Overruns = 3
+	return gap
+}
+
+func gapLogsWarning(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	__createGap().Log(logger)
+
+	var logged map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &logged))
+	assert.Equal(t, "WARN", logged["level"])
+	assert.Equal(t, "GAP in conntrack events, 3 receive buffer overruns", logged["msg"])
+	assert.Equal(t, "GAP", logged["type"])
+	assert.Equal(t, GapOverrun, logged["reason"])
+	assert.Equal(t, float64(3), logged["overruns"])
+}
+
+func gapAttrsMatchJSONEncoding(t *testing.T) {
+	gap := __createGap()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	gap.Log(logger)
+
+	var logged map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &logged))
+	delete(logged, "time")
+	delete(logged, "level")
+	delete(logged, "msg")
+
+	data, err := json.Marshal(gap)
+	require.NoError(t, err)
+	var marshaled map[string]any
+	require.NoError(t, json.Unmarshal(data, &marshaled))
+
+	assert.Equal(t, marshaled, logged)
+}
+
+func gapMessageNamesReason(t *testing.T) {
+	gap := NewGap(GapReconnect, time.Now(), time.Now())
+	assert.Equal(t, "GAP in conntrack events, reconnect", gap.Message())
+
+	gap.Attempts = 2
+	assert.Equal(t, "GAP in conntrack events, reconnected after 2 attempts", gap.Message())
+}
+
+func gapSchemaDescribesAllFields(t *testing.T) {
+	schema := __readSchema(t)["gap"]
+	assert.Equal(t, float64(SchemaVersion), schema.Properties["schema_version"]["const"])
+
+	overrun := __createGap()
+	__validate(t, schema, overrun)
+
+	reconnect := NewGap(GapReconnect, time.Now(), time.Now())
+	reconnect.Attempts = 2
+	__validate(t, schema, reconnect)
+
+	var fields []string
+	for _, attr := range NewGap(GapOverrun, time.Now(), time.Now()).Attrs() {
+		fields = append(fields, attr.Key)
+	}
+	assert.ElementsMatch(t, fields, schema.Required)
+
+	overrun.Attempts = 1
+	fields = nil
+	for _, attr := range overrun.Attrs() {
+		fields = append(fields, attr.Key)
+	}
+	assert.ElementsMatch(t, fields, slices.Collect(maps.Keys(schema.Properties)))
+}
+
+func TestGap(t *testing.T) {
+	t.Run("gap.Log logs warning", gapLogsWarning)
+	t.Run("gap.Attrs match JSON encoding", gapAttrsMatchJSONEncoding)
+	t.Run("gap.Message names reason", gapMessageNamesReason)
+	t.Run("gap schema describes all fields", gapSchemaDescribesAllFields)
+}
@@ -7,50 +7,21 @@ package record
 import (
 	"fmt"
 	"log/slog"
-	"net/netip"
+	"strings"
 	"syscall"
 
 	"github.com/ti-mo/conntrack"
 	"github.com/tschaefer/conntrackd/internal/geoip"
 )
 
-// Record logs a conntrack event with optional geolocation data.
-func Record(event conntrack.Event, geo *geoip.GeoIP, logger *slog.Logger) {
+// Record logs a conntrack event with optional geolocation and ASN data and
+// the policy decided by the filter applied.
+func Record(event conntrack.Event, geo *geoip.Lookup, policy Policy, logger *slog.Logger) {
 	slog.Debug("Conntrack Event", "data", event)
 
-	prot := getProtocol(event)
-	eType := getType(event)
-
-	record := []any{
-		slog.String("type", eType),
-		slog.Uint64("flow", uint64(event.Flow.ID)),
-		slog.String("prot", prot),
-		slog.String("src_addr", event.Flow.TupleOrig.IP.SourceAddress.String()),
-		slog.String("dst_addr", event.Flow.TupleOrig.IP.DestinationAddress.String()),
-		slog.Uint64("src_port", uint64(event.Flow.TupleOrig.Proto.SourcePort)),
-		slog.Uint64("dst_port", uint64(event.Flow.TupleOrig.Proto.DestinationPort)),
-	}
-
-	state, ok := getTCPState(event)
-	if ok {
-		record = append(record, slog.String("tcp_state", state))
-	}
-
-	location := getLocation(event, geo)
-
-	msg := fmt.Sprintf("%s %s connection from %s to %s",
-		eType, prot,
-		formatAddrPort(
-			event.Flow.TupleOrig.IP.SourceAddress,
-			event.Flow.TupleOrig.Proto.SourcePort,
-		),
-		formatAddrPort(
-			event.Flow.TupleOrig.IP.DestinationAddress,
-			event.Flow.TupleOrig.Proto.DestinationPort,
-		),
-	)
-
-	logger.Info(msg, append(record, location...)...)
+	e := NewEvent(event, geo)
+	e.Apply(policy)
+	e.Log(logger)
 }
 
 // getProtocol returns the protocol name for the given conntrack event.
@@ -79,64 +50,84 @@ func getType(event conntrack.Event) string {
 	}
 }
 
+// tcpStates are the TCP connection state names indexed by state value.
+var tcpStates = []string{
+	"NONE",
+	"SYN_SENT",
+	"SYN_RECV",
+	"ESTABLISHED",
+	"FIN_WAIT",
+	"CLOSE_WAIT",
+	"LAST_ACK",
+	"TIME_WAIT",
+	"CLOSE",
+}
+
 // getTCPState returns the TCP state as a string if applicable.
-func getTCPState(event conntrack.Event) (string, bool) {
-	if event.Flow.ProtoInfo.TCP == nil {
+func getTCPState(flow *conntrack.Flow) (string, bool) {
+	if flow.ProtoInfo.TCP == nil {
 		return "", false
 	}
 
-	state := event.Flow.ProtoInfo.TCP.State
-	states := map[uint8]string{
-		0: "NONE",
-		1: "SYN_SENT",
-		2: "SYN_RECV",
-		3: "ESTABLISHED",
-		4: "FIN_WAIT",
-		5: "CLOSE_WAIT",
-		6: "LAST_ACK",
-		7: "TIME_WAIT",
-		8: "CLOSE",
-	}
-	if s, ok := states[state]; ok {
-		return s, true
+	state := flow.ProtoInfo.TCP.State
+	if int(state) < len(tcpStates) {
+		return tcpStates[state], true
 	}
 
 	return "", true
 }
 
-// getLocation retrieves geolocation data for source and destination IPs.
-func getLocation(event conntrack.Event, geo *geoip.GeoIP) []any {
+// setLocation sets geolocation and ASN data for source and destination IPs.
+// Lookups already done by the filter are reused.
+func setLocation(e *Event, event conntrack.Event, geo *geoip.Lookup) {
 	if geo == nil {
-		return nil
+		return
+	}
+
+	src, dst := event.Flow.TupleOrig.IP.SourceAddress, event.Flow.TupleOrig.IP.DestinationAddress
+
+	if loc := geo.Location(src); loc != nil {
+		e.SrcCity, e.SrcCountry = loc.City, loc.Country
+		e.SrcLat, e.SrcLon = &loc.Lat, &loc.Lon
 	}
 
-	var location []any
-	for dir, addr := range map[string]netip.Addr{
-		"src_": event.Flow.TupleOrig.IP.SourceAddress,
-		"dst_": event.Flow.TupleOrig.IP.DestinationAddress,
-	} {
-		if loc := geo.Location(addr); loc != nil {
-			data := []any{
-				slog.String(dir+"city", loc.City),
-				slog.String(dir+"country", loc.Country),
-				slog.Float64(dir+"lat", loc.Lat),
-				slog.Float64(dir+"lon", loc.Lon),
-			}
-			location = append(location, data...)
-		}
+	if loc := geo.Location(dst); loc != nil {
+		e.DstCity, e.DstCountry = loc.City, loc.Country
+		e.DstLat, e.DstLon = &loc.Lat, &loc.Lon
 	}
 
-	if len(location) == 0 {
-		return nil
+	if asn := geo.ASN(src); asn != nil {
+		e.SrcASN, e.SrcASOrg = asn.Number, asn.Organization
 	}
 
-	return location
+	if asn := geo.ASN(dst); asn != nil {
+		e.DstASN, e.DstASOrg = asn.Number, asn.Organization
+	}
+}
+
+// setNAT sets the translated source and destination if the reply tuple
+// differs from the inverted original tuple.
+func setNAT(e *Event, flow *conntrack.Flow) {
+	orig, reply := flow.TupleOrig, flow.TupleReply
+	if !reply.IP.SourceAddress.IsValid() || !reply.IP.DestinationAddress.IsValid() {
+		return
+	}
+
+	if reply.IP.DestinationAddress != orig.IP.SourceAddress || reply.Proto.DestinationPort != orig.Proto.SourcePort {
+		e.NATSrcAddr = reply.IP.DestinationAddress.String()
+		e.NATSrcPort = reply.Proto.DestinationPort
+	}
+
+	if reply.IP.SourceAddress != orig.IP.DestinationAddress || reply.Proto.SourcePort != orig.Proto.DestinationPort {
+		e.NATDstAddr = reply.IP.SourceAddress.String()
+		e.NATDstPort = reply.Proto.SourcePort
+	}
 }
 
 // formatAddrPort formats an IP address and port into a string.
-func formatAddrPort(addr netip.Addr, port uint16) string {
-	if addr.Is6() {
-		return fmt.Sprintf("[%s]:%d", addr.String(), port)
+func formatAddrPort(addr string, port uint16) string {
+	if strings.Contains(addr, ":") {
+		return fmt.Sprintf("[%s]:%d", addr, port)
 	}
-	return fmt.Sprintf("%s:%d", addr.String(), port)
+	return fmt.Sprintf("%s:%d", addr, port)
 }
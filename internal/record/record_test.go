@@ -54,12 +54,12 @@ func recordLogsBasicDataIfNoLocationIsGiven(t *testing.T) {
 	}
 
 	var geo *geoip.GeoIP
-	Record(event, geo, logger)
+	Record(event, geo.Lookup(), Policy{}, logger)
 	var result map[string]any
 	err := json.Unmarshal(log.Bytes(), &result)
 	assert.NoError(t, err)
 
-	wanted := []string{"level", "time",
+	wanted := []string{"level", "time", "schema_version",
 		"type", "flow", "prot",
 		"src_addr", "dst_addr", "src_port", "dst_port"}
 	got := slices.Sorted(maps.Keys(result))
@@ -90,12 +90,12 @@ func recordLogsAllDataIfLocationIsGiven(t *testing.T) {
 		_ = geo.Close()
 	}()
 
-	Record(event, geo, logger)
+	Record(event, geo.Lookup(), Policy{}, logger)
 	var result map[string]any
 	err = json.Unmarshal(log.Bytes(), &result)
 	assert.NoError(t, err)
 
-	wanted := []string{"level", "time",
+	wanted := []string{"level", "time", "schema_version",
 		"type", "flow", "prot",
 		"src_addr", "dst_addr", "src_port", "dst_port",
 		"dst_country", "dst_city", "dst_lat", "dst_lon",
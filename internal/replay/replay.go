@@ -0,0 +1,263 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ti-mo/conntrack"
+	"github.com/tschaefer/conntrackd/internal/capture"
+	"github.com/tschaefer/conntrackd/internal/filter"
+	"github.com/tschaefer/conntrackd/internal/listener"
+	"github.com/tschaefer/conntrackd/internal/record"
+)
+
+// Config is the configuration of a replay.
+type Config struct {
+	// Path is the file of recorded events, either a capture file or JSON
+	// records one per line.
+	Path string
+	// Speed is the replay speed relative to the recorded one, 0 replays as
+	// fast as possible.
+	Speed float64
+}
+
+// reader returns the recorded events with their receive time, io.EOF at the
+// end of the file.
+type reader interface {
+	Next() (conntrack.Event, time.Time, error)
+}
+
+// Source replays recorded events as event source of the service. Events are
+// delivered at the recorded pace scaled by the speed, the source is
+// exhausted at the end of the file.
+type Source struct {
+	config Config
+	file   *os.File
+	reader reader
+
+	events []string
+	mu     sync.Mutex
+
+	done    chan struct{}
+	closed  sync.Once
+	workers sync.WaitGroup
+}
+
+// Open opens the file of recorded events.
+func Open(config Config) (*Source, error) {
+	if config.Speed < 0 {
+		return nil, fmt.Errorf("invalid replay speed %v", config.Speed)
+	}
+
+	file, err := os.Open(config.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bufio.NewReader(file)
+	magic, _ := buf.Peek(len(capture.Magic))
+
+	var r reader
+	if capture.IsCapture(magic) {
+		var captured *capture.Reader
+		if captured, err = capture.NewReader(buf); err == nil {
+			r = &captureReader{captured}
+		}
+	} else {
+		r = newJSONReader(buf)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return &Source{
+		config: config,
+		file:   file,
+		reader: r,
+		events: filter.EventTypes,
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// Apply restricts the replayed events to the event types of the prefilter,
+// like subscribing the event groups of the netlink listener. Rules are left
+// to the filter.
+func (s *Source) Apply(prefilter filter.Prefilter) (listener.Report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = prefilter.Events
+	return listener.Report{Events: prefilter.Events}, nil
+}
+
+// Listen starts replaying the events to evCh. A read error is sent on the
+// returned channel, it is closed when the source is exhausted.
+func (s *Source) Listen(evCh chan<- conntrack.Event) chan error {
+	errCh := make(chan error, 1)
+
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		defer close(errCh)
+
+		if err := s.replay(evCh); err != nil {
+			errCh <- err
+		}
+	}()
+
+	return errCh
+}
+
+// replay delivers the events until the end of the file or the source is
+// closed.
+func (s *Source) replay(evCh chan<- conntrack.Event) error {
+	var first, started time.Time
+	for {
+		event, received, err := s.reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if s.config.Speed > 0 && !received.IsZero() {
+			if first.IsZero() {
+				first, started = received, time.Now()
+			}
+			due := started.Add(time.Duration(float64(received.Sub(first)) / s.config.Speed))
+			select {
+			case <-s.done:
+				return nil
+			case <-time.After(time.Until(due)):
+			}
+		}
+
+		if !s.subscribed(event) {
+			continue
+		}
+
+		select {
+		case <-s.done:
+			return nil
+		case evCh <- event:
+		}
+	}
+}
+
+// subscribed reports whether the type of the event is applied.
+func (s *Source) subscribed(event conntrack.Event) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch event.Type {
+	case conntrack.EventNew:
+		return slices.Contains(s.events, "NEW")
+	case conntrack.EventUpdate:
+		return slices.Contains(s.events, "UPDATE")
+	case conntrack.EventDestroy:
+		return slices.Contains(s.events, "DESTROY")
+	}
+	return true
+}
+
+// TakeLoss returns no loss, replayed events are never lost.
+func (s *Source) TakeLoss() listener.Loss {
+	return listener.Loss{}
+}
+
+// Close stops the replay and closes the file.
+func (s *Source) Close() error {
+	s.closed.Do(func() {
+		close(s.done)
+	})
+	s.workers.Wait()
+
+	return s.file.Close()
+}
+
+// captureReader reads the events of a capture file.
+type captureReader struct {
+	reader *capture.Reader
+}
+
+func (r *captureReader) Next() (conntrack.Event, time.Time, error) {
+	captured, err := r.reader.Next()
+	if err != nil {
+		return conntrack.Event{}, time.Time{}, err
+	}
+
+	event, err := captured.Event()
+	if err != nil {
+		return conntrack.Event{}, time.Time{}, fmt.Errorf("decode captured event: %w", err)
+	}
+	return event, captured.Received, nil
+}
+
+// jsonReader reads JSON records, one per line, as written by the stream
+// sink. Records nested under `event` as written by the syslog sink are
+// accepted as well. Flow summaries and gap records are skipped.
+type jsonReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newJSONReader(r io.Reader) *jsonReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	return &jsonReader{scanner: scanner}
+}
+
+func (r *jsonReader) Next() (conntrack.Event, time.Time, error) {
+	for r.scanner.Scan() {
+		r.line++
+
+		data := strings.TrimSpace(r.scanner.Text())
+		if data == "" {
+			continue
+		}
+
+		var line struct {
+			Time  time.Time       `json:"time"`
+			Event json.RawMessage `json:"event"`
+		}
+		if err := json.Unmarshal([]byte(data), &line); err != nil {
+			return conntrack.Event{}, time.Time{}, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		if len(line.Event) > 0 && line.Event[0] == '{' {
+			data = string(line.Event)
+		}
+
+		var e record.Event
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return conntrack.Event{}, time.Time{}, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		if e.Type == "SUMMARY" || e.Type == "GAP" {
+			continue
+		}
+
+		event, err := e.Conntrack()
+		if err != nil {
+			return conntrack.Event{}, time.Time{}, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		return event, line.Time, nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return conntrack.Event{}, time.Time{}, err
+	}
+	return conntrack.Event{}, time.Time{}, io.EOF
+}
@@ -0,0 +1,184 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package replay
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ti-mo/conntrack"
+	"github.com/ti-mo/netfilter"
+	"github.com/tschaefer/conntrackd/internal/capture"
+	"github.com/tschaefer/conntrackd/internal/filter"
+)
+
+const records = `{"time":"2025-11-25T12:35:11Z","level":"INFO","msg":"NEW","schema_version":1,"type":"NEW","flow":1,"prot":"TCP","src_addr":"10.0.0.1","dst_addr":"10.0.0.2","src_port":4711,"dst_port":443}
+{"time":"2025-11-25T12:35:11.5Z","level":"WARN","msg":"GAP","schema_version":1,"type":"GAP","reason":"overrun","first_seen":"2025-11-25T12:35:11Z","last_seen":"2025-11-25T12:35:11Z","overruns":1}
+
+{"time":"2025-11-25T12:35:12Z","event":{"schema_version":1,"type":"DESTROY","flow":1,"prot":"TCP","src_addr":"10.0.0.1","dst_addr":"10.0.0.2","src_port":4711,"dst_port":443}}
+`
+
+func __writeFile(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "events")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+	return path
+}
+
+func __writeCapture(t *testing.T, received ...time.Time) string {
+	path := filepath.Join(t.TempDir(), "events.cap")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer func() {
+		_ = file.Close()
+	}()
+
+	w, err := capture.NewWriter(file, capture.Header{Host: "gateway"})
+	require.NoError(t, err)
+	for i, at := range received {
+		message, err := netfilter.MarshalNetlink(
+			netfilter.Header{SubsystemID: netfilter.NFSubsysCTNetlink, Family: netfilter.ProtoIPv4, Flags: netlink.Create | netlink.Excl},
+			[]netfilter.Attribute{
+				{Type: 1, Nested: true, Children: []netfilter.Attribute{
+					{Type: 1, Nested: true, Children: []netfilter.Attribute{
+						{Type: 1, Data: []byte{10, 0, 0, 1}},
+						{Type: 2, Data: []byte{10, 0, 0, byte(i + 2)}},
+					}},
+					{Type: 2, Nested: true, Children: []netfilter.Attribute{
+						{Type: 1, Data: []byte{syscall.IPPROTO_UDP}},
+					}},
+				}},
+			},
+		)
+		require.NoError(t, err)
+		message.Header.Length = uint32(16 + len(message.Data))
+		require.NoError(t, w.Write(capture.Record{Received: at, NSID: capture.NSIDUnknown, Message: message}))
+	}
+	require.NoError(t, w.Flush())
+
+	return path
+}
+
+// __replay replays all events of the source
+func __replay(t *testing.T, source *Source) []conntrack.Event {
+	evCh := make(chan conntrack.Event, 16)
+	errCh := source.Listen(evCh)
+
+	err, ok := <-errCh
+	require.NoError(t, err)
+	require.False(t, ok, "source expected to be exhausted")
+	require.NoError(t, source.Close())
+
+	close(evCh)
+	var events []conntrack.Event
+	for event := range evCh {
+		events = append(events, event)
+	}
+	return events
+}
+
+func replaysJSONRecords(t *testing.T) {
+	source, err := Open(Config{Path: __writeFile(t, records)})
+	require.NoError(t, err)
+
+	events := __replay(t, source)
+	require.Len(t, events, 2)
+	assert.Equal(t, conntrack.EventNew, events[0].Type)
+	assert.Equal(t, conntrack.EventDestroy, events[1].Type)
+	assert.Equal(t, uint16(443), events[1].Flow.TupleOrig.Proto.DestinationPort)
+}
+
+func replaysCapture(t *testing.T) {
+	now := time.Now()
+	source, err := Open(Config{Path: __writeCapture(t, now, now.Add(time.Millisecond))})
+	require.NoError(t, err)
+
+	events := __replay(t, source)
+	require.Len(t, events, 2)
+	assert.Equal(t, "10.0.0.3", events[1].Flow.TupleOrig.IP.DestinationAddress.String())
+	assert.Equal(t, uint8(syscall.IPPROTO_UDP), events[1].Flow.TupleOrig.Proto.Protocol)
+}
+
+func replaysAtSpeed(t *testing.T) {
+	now := time.Now()
+	path := __writeCapture(t, now, now.Add(2*time.Second))
+
+	source, err := Open(Config{Path: path, Speed: 10})
+	require.NoError(t, err)
+	started := time.Now()
+	__replay(t, source)
+	assert.GreaterOrEqual(t, time.Since(started), 200*time.Millisecond)
+
+	source, err = Open(Config{Path: path})
+	require.NoError(t, err)
+	started = time.Now()
+	__replay(t, source)
+	assert.Less(t, time.Since(started), 200*time.Millisecond)
+}
+
+func applyRestrictsEventTypes(t *testing.T) {
+	source, err := Open(Config{Path: __writeFile(t, records)})
+	require.NoError(t, err)
+
+	report, err := source.Apply(filter.Prefilter{Events: []string{"DESTROY"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"DESTROY"}, report.Events)
+
+	events := __replay(t, source)
+	require.Len(t, events, 1)
+	assert.Equal(t, conntrack.EventDestroy, events[0].Type)
+}
+
+func listenFailsOnInvalidRecord(t *testing.T) {
+	source, err := Open(Config{Path: __writeFile(t, records+`{"type":"NEW","prot":"ICMP"}`+"\n")})
+	require.NoError(t, err)
+
+	evCh := make(chan conntrack.Event, 16)
+	err = <-source.Listen(evCh)
+	assert.EqualError(t, err, `line 5: invalid protocol "ICMP"`)
+	assert.Len(t, evCh, 2)
+	require.NoError(t, source.Close())
+}
+
+func closeStopsReplay(t *testing.T) {
+	now := time.Now()
+	source, err := Open(Config{Path: __writeCapture(t, now, now.Add(time.Hour)), Speed: 1})
+	require.NoError(t, err)
+
+	evCh := make(chan conntrack.Event, 16)
+	errCh := source.Listen(evCh)
+	<-evCh
+
+	require.NoError(t, source.Close())
+	_, ok := <-errCh
+	assert.False(t, ok)
+}
+
+func openFailsOnInvalidFile(t *testing.T) {
+	_, err := Open(Config{Path: filepath.Join(t.TempDir(), "missing")})
+	assert.Error(t, err)
+
+	_, err = Open(Config{Path: __writeFile(t, string(capture.Magic)+"\x02\x00")})
+	assert.EqualError(t, err, "unsupported capture version 2")
+
+	_, err = Open(Config{Path: __writeFile(t, records), Speed: -1})
+	assert.True(t, strings.HasPrefix(err.Error(), "invalid replay speed"))
+}
+
+func TestReplay(t *testing.T) {
+	t.Run("replay.Source replays JSON records", replaysJSONRecords)
+	t.Run("replay.Source replays capture", replaysCapture)
+	t.Run("replay.Source replays at speed", replaysAtSpeed)
+	t.Run("replay.Source.Apply restricts event types", applyRestrictsEventTypes)
+	t.Run("replay.Source.Listen fails on invalid record", listenFailsOnInvalidRecord)
+	t.Run("replay.Source.Close stops replay", closeStopsReplay)
+	t.Run("replay.Open fails on invalid file", openFailsOnInvalidFile)
+}
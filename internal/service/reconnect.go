@@ -0,0 +1,128 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/ti-mo/conntrack"
+	"github.com/tschaefer/conntrackd/internal/listener"
+	"github.com/tschaefer/conntrackd/internal/notify"
+	"github.com/tschaefer/conntrackd/internal/record"
+)
+
+// stableInterval is the time a listener must run without error to reset the
+// consecutive failures.
+const stableInterval = time.Minute
+
+// Reconnect configures reconnecting the listener after netlink errors.
+type Reconnect struct {
+	// MaxFailures is the number of consecutive failures after which the
+	// service gives up, 0 and 1 give up on the first error.
+	MaxFailures int
+	// Backoff is the delay before the first attempt, doubled after each
+	// failed attempt up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Snapshot processes the flows of the conntrack table as UPDATE events
+	// after reconnecting.
+	Snapshot bool
+}
+
+// retry counts consecutive failures and computes the backoff.
+type retry struct {
+	config   Reconnect
+	failures int
+	delay    time.Duration
+}
+
+// fail counts a failure. Returns the delay before the next attempt, false if
+// giving up.
+func (r *retry) fail() (time.Duration, bool) {
+	r.failures++
+	if r.failures >= r.config.MaxFailures {
+		return 0, false
+	}
+
+	switch {
+	case r.delay == 0:
+		r.delay = r.config.Backoff
+	case r.config.MaxBackoff > 0:
+		r.delay = min(2*r.delay, r.config.MaxBackoff)
+	default:
+		r.delay *= 2
+	}
+	return r.delay, true
+}
+
+// reset clears the failures and the backoff.
+func (r *retry) reset() {
+	r.failures, r.delay = 0, 0
+}
+
+// reconnect opens the event source again after an error of the source
+// connected at the given time. A gap record is written once reconnected.
+// Returns false if giving up, a nil source on shutdown.
+func (s *Service) reconnect(ctx context.Context, retry *retry, connected time.Time, evCh chan conntrack.Event) (EventSource, bool) {
+	lost := time.Now()
+	if lost.Sub(connected) >= stableInterval {
+		retry.reset()
+	}
+
+	for attempt := 1; ; attempt++ {
+		delay, ok := retry.fail()
+		if !ok {
+			slog.Error("Giving up reconnecting to conntrack.", "failures", retry.failures)
+			return nil, false
+		}
+
+		slog.Info("Reconnecting to conntrack.", "attempt", attempt, "delay", delay)
+		notify.Status(fmt.Sprintf("Reconnecting to conntrack, attempt %d.", attempt))
+		select {
+		case <-ctx.Done():
+			return nil, true
+		case <-time.After(delay):
+		}
+
+		source, err := s.setupSource()
+		if err != nil {
+			continue
+		}
+		notify.Status("Listening for conntrack events.")
+
+		gap := record.NewGap(record.GapReconnect, lost, time.Now())
+		gap.Attempts = attempt
+		s.logGap(gap)
+		s.snapshot(ctx, evCh)
+
+		return source, true
+	}
+}
+
+// snapshot processes the flows of the conntrack table as UPDATE events, if
+// enabled.
+func (s *Service) snapshot(ctx context.Context, evCh chan conntrack.Event) {
+	if !s.Reconnect.Snapshot {
+		return
+	}
+
+	flows, err := listener.Snapshot()
+	if err != nil {
+		slog.Warn("Failed to snapshot conntrack table.", "error", err)
+		return
+	}
+	slog.Info("Processing conntrack table snapshot.", "flows", len(flows))
+
+	for _, flow := range flows {
+		select {
+		case <-ctx.Done():
+			return
+		case evCh <- conntrack.Event{Type: conntrack.EventUpdate, Flow: &flow}:
+		}
+	}
+}
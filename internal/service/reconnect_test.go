@@ -0,0 +1,95 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT license, see LICENSE in the project root for details.
+*/
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ti-mo/conntrack"
+)
+
+func retryBacksOffUntilGivingUp(t *testing.T) {
+	r := &retry{config: Reconnect{MaxFailures: 5, Backoff: time.Second, MaxBackoff: 3 * time.Second}}
+
+	var delays []time.Duration
+	for {
+		delay, ok := r.fail()
+		if !ok {
+			break
+		}
+		delays = append(delays, delay)
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}, delays)
+
+	r.reset()
+	delay, ok := r.fail()
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, delay)
+}
+
+func retryGivesUpOnFirstErrorIfDisabled(t *testing.T) {
+	for _, maxFailures := range []int{0, 1} {
+		r := &retry{config: Reconnect{MaxFailures: maxFailures}}
+		_, ok := r.fail()
+		assert.False(t, ok)
+	}
+}
+
+func reconnectGivesUp(t *testing.T) {
+	sink, logger, _ := __setupSinkAndLogger(t)
+	svc, err := NewService(logger, nil, nil, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	r := &retry{config: Reconnect{MaxFailures: 1}}
+	con, ok := svc.reconnect(context.Background(), r, time.Now(), make(chan conntrack.Event))
+	assert.Nil(t, con)
+	assert.False(t, ok)
+}
+
+func reconnectEndsOnShutdown(t *testing.T) {
+	sink, logger, record := __setupSinkAndLogger(t)
+	svc, err := NewService(logger, nil, nil, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	r := &retry{config: Reconnect{MaxFailures: 5, Backoff: time.Hour}}
+	con, ok := svc.reconnect(ctx, r, time.Now(), make(chan conntrack.Event))
+	assert.Nil(t, con)
+	assert.True(t, ok)
+	assert.Len(t, record.String(), 0, "No gap record expected if not reconnected")
+}
+
+func reconnectResetsFailuresOfStableListener(t *testing.T) {
+	sink, logger, _ := __setupSinkAndLogger(t)
+	svc, err := NewService(logger, nil, nil, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	r := &retry{config: Reconnect{MaxFailures: 2, Backoff: time.Hour}, failures: 1}
+	_, ok := svc.reconnect(ctx, r, time.Now().Add(-stableInterval), make(chan conntrack.Event))
+	assert.True(t, ok)
+	assert.Equal(t, 1, r.failures)
+}
+
+func TestReconnect(t *testing.T) {
+	t.Run("retry.fail backs off until giving up", retryBacksOffUntilGivingUp)
+	t.Run("retry.fail gives up on first error if disabled", retryGivesUpOnFirstErrorIfDisabled)
+	t.Run("service.reconnect gives up", reconnectGivesUp)
+	t.Run("service.reconnect ends on shutdown", reconnectEndsOnShutdown)
+	t.Run("service.reconnect resets failures of stable listener", reconnectResetsFailuresOfStableListener)
+}
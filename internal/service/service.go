@@ -6,27 +6,91 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
-	"github.com/mdlayher/netlink"
 	"github.com/ti-mo/conntrack"
-	"github.com/ti-mo/netfilter"
+	"github.com/tschaefer/conntrackd/internal/aggregator"
+	"github.com/tschaefer/conntrackd/internal/dedup"
 	"github.com/tschaefer/conntrackd/internal/filter"
 	"github.com/tschaefer/conntrackd/internal/geoip"
+	"github.com/tschaefer/conntrackd/internal/listener"
 	"github.com/tschaefer/conntrackd/internal/logger"
+	"github.com/tschaefer/conntrackd/internal/notify"
 	"github.com/tschaefer/conntrackd/internal/record"
 	"github.com/tschaefer/conntrackd/internal/sink"
+	"github.com/tschaefer/conntrackd/internal/tail"
 	"github.com/tschaefer/conntrackd/internal/version"
 	"golang.org/x/sync/errgroup"
 )
 
-// Service represents the conntrack service.
+// expireInterval is the interval to expire idle and active aggregated flows.
+const expireInterval = 5 * time.Second
+
+// reportInterval is the interval to report suppressed UPDATE events.
+const reportInterval = time.Minute
+
+// lossInterval is the interval to report lost events.
+const lossInterval = 5 * time.Second
+
+// queueSize is the number of received events waiting to be processed.
+const queueSize = 1024
+
+// statusInterval is the interval to report event rates to systemd.
+const statusInterval = 10 * time.Second
+
+// EventSource delivers conntrack events to the service.
+type EventSource interface {
+	// Apply restricts the delivered events to the prefilter of the filter,
+	// replacing the previous one.
+	Apply(prefilter filter.Prefilter) (listener.Report, error)
+	// Listen starts delivering events to evCh. Errors are sent on the
+	// returned channel, the channel is closed when the source is exhausted.
+	Listen(evCh chan<- conntrack.Event) chan error
+	// TakeLoss returns the events lost since the last call.
+	TakeLoss() listener.Loss
+	// Close stops delivering events.
+	Close() error
+}
+
+// Service represents the conntrack service. Filter, GeoIP and Sink are
+// replaced on Reload while the service is running. Events are received from
+// the netlink listener unless Dial opens another event source.
 type Service struct {
-	Filter *filter.Filter
-	GeoIP  *geoip.GeoIP
-	Sink   *sink.Sink
-	Logger *slog.Logger
+	Filter       *filter.Filter
+	GeoIP        *geoip.GeoIP
+	Sink         *sink.Sink
+	Logger       *slog.Logger
+	Aggregator   *aggregator.Aggregator
+	Deduplicator *dedup.Deduplicator
+	Netlink      listener.Config
+	Reconnect    Reconnect
+	Dial         func() (EventSource, error)
+
+	mu         sync.RWMutex
+	source     EventSource
+	events     chan conntrack.Event
+	started    time.Time
+	pending    sync.WaitGroup
+	processing atomic.Int64
+	probe      chan chan struct{}
+	busy       []atomic.Int64
+
+	received, recorded, dropped atomic.Uint64
+
+	subscribers tail.Hub
+	unfiltered  bool
+}
+
+// eventCounts are the numbers of received TCP and UDP events, of events
+// recorded or aggregated and of events dropped by the filter.
+type eventCounts struct {
+	received, recorded, dropped uint64
 }
 
 // NewService creates a new conntrack service.
@@ -41,80 +105,560 @@ func NewService(logger *logger.Logger, geoip *geoip.GeoIP, filter *filter.Filter
 	}, nil
 }
 
+// Subscribe subscribes to the records of events matching the CEL
+// expression, see tail.Hub. The expression may look up the sets of the
+// current filter. While subscribers of dropped events are active the kernel
+// pre-filter accepts all events, dropped events are not visible otherwise.
+func (s *Service) Subscribe(expr string, dropped bool) (*tail.Subscription, error) {
+	s.mu.RLock()
+	sets := s.Filter.Sets()
+	s.mu.RUnlock()
+
+	sub, err := s.subscribers.Subscribe(expr, dropped, sets)
+	if err != nil {
+		return nil, err
+	}
+	s.updatePrefilter()
+
+	return sub, nil
+}
+
+// Unsubscribe ends the subscription.
+func (s *Service) Unsubscribe(sub *tail.Subscription) {
+	s.subscribers.Unsubscribe(sub)
+	s.updatePrefilter()
+}
+
+// updatePrefilter re-applies the kernel pre-filter if subscribers of
+// dropped events came or went.
+func (s *Service) updatePrefilter() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.source == nil || s.subscribers.Dropped() == s.unfiltered {
+		return
+	}
+	_ = s.applyPrefilter(s.source)
+}
+
+// Reload atomically replaces filter, GeoIP database and sink. Events in
+// flight are processed with the previous ones, the previous sink is closed
+// once they are done. Returns the previous GeoIP database to be closed by the
+// caller, if it differs from the new one.
+func (s *Service) Reload(filter *filter.Filter, geoip *geoip.GeoIP, sink *sink.Sink) *geoip.GeoIP {
+	s.mu.Lock()
+	previous, previousSink := s.GeoIP, s.Sink
+	s.Filter, s.GeoIP, s.Sink = filter, geoip, sink
+	if s.source != nil {
+		_ = s.applyPrefilter(s.source)
+	}
+	s.mu.Unlock()
+
+	if previousSink != nil && previousSink != sink {
+		if err := previousSink.Close(); err != nil {
+			slog.Warn("Failed to close previous sink.", "error", err)
+		}
+	}
+
+	if previous == geoip {
+		return nil
+	}
+	return previous
+}
+
 // Run starts the conntrack listener service.
 func (s *Service) Run(ctx context.Context) bool {
 	slog.Info("Starting conntrack listener.",
 		"release", version.Release(), "commit", version.Commit(),
 	)
 
-	con, err := s.setupConntrack()
+	source, err := s.setupSource()
 	if err != nil {
 		return false
 	}
+	notify.Ready()
+	notify.Status("Listening for conntrack events.")
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	evCh, errCh, err := s.startEventListener(con)
+	evCh := make(chan conntrack.Event, queueSize)
+	s.mu.Lock()
+	s.events, s.started = evCh, time.Now()
+	s.mu.Unlock()
+
+	g := s.startEventProcessor(context.WithoutCancel(ctx), evCh)
+	s.startAggregatorExpiry(ctx, g)
+	s.startSuppressionReport(ctx, g)
+	s.startLossReport(ctx, g)
+	s.startStatusReport(ctx, g)
+	s.startWatchdog(ctx, g)
+
+	tranquil := s.handleShutdown(ctx, cancel, source, g, evCh)
+	s.flushDeduplicator()
+	s.flushAggregator()
+	s.reportSuppressed()
+
+	return tranquil
+}
+
+// setupSource opens the event source and applies the kernel pre-filter of
+// the filter.
+func (s *Service) setupSource() (EventSource, error) {
+	source, err := s.dial()
 	if err != nil {
-		_ = con.Close()
-		cancel()
-		return false
+		return nil, err
 	}
 
-	g := s.startEventProcessor(ctx, evCh)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	return s.handleShutdown(ctx, cancel, con, g, errCh)
+	if err := s.applyPrefilter(source); err != nil {
+		_ = source.Close()
+		return nil, err
+	}
+	s.source = source
+
+	return source, nil
 }
 
-// setupConntrack initializes the conntrack connection and sets options.
-func (s *Service) setupConntrack() (*conntrack.Conn, error) {
-	con, err := conntrack.Dial(nil)
+// dial opens the event source, the netlink listener unless Dial is set.
+func (s *Service) dial() (EventSource, error) {
+	if s.Dial != nil {
+		source, err := s.Dial()
+		if err != nil {
+			slog.Error("Failed to open event source.", "error", err)
+		}
+		return source, err
+	}
+
+	con, err := listener.Dial(s.Netlink)
 	if err != nil {
 		slog.Error("Failed to dial conntrack.", "error", err)
 		return nil, err
 	}
 
-	if err := con.SetOption(netlink.ListenAllNSID|netlink.NoENOBUFS, true); err != nil {
-		_ = con.Close()
-		slog.Error("Failed to set conntrack listen options.", "error", err)
-		return nil, err
+	buffer, err := con.ReadBuffer()
+	if err != nil {
+		slog.Warn("Failed to get netlink receive buffer size.", "error", err)
 	}
+	slog.Info("Opened netlink socket.",
+		"buffer", buffer, "workers", con.Workers(), "report_loss", s.Netlink.ReportLoss,
+	)
 
 	return con, nil
 }
 
-// startEventListener starts listening for conntrack events.
-func (s *Service) startEventListener(con *conntrack.Conn) (chan conntrack.Event, chan error, error) {
-	evCh := make(chan conntrack.Event, 1024)
-	errCh, err := con.Listen(evCh, 4, netfilter.GroupsCT)
+// applyPrefilter subscribes the event groups needed by the filter and
+// attaches its kernel pre-filter. While subscribers of dropped events are
+// active all event groups are subscribed and all events accepted instead.
+// The caller must hold the write lock.
+func (s *Service) applyPrefilter(source EventSource) error {
+	unfiltered := s.subscribers.Dropped()
+	prefilter := s.Filter.Prefilter()
+	if unfiltered {
+		prefilter = filter.Prefilter{Events: filter.EventTypes, Accept: true}
+	}
+
+	report, err := source.Apply(prefilter)
 	if err != nil {
-		slog.Error("Failed to listen to conntrack events.", "error", err)
-		return nil, nil, err
+		slog.Error("Failed to subscribe to conntrack events.", "error", err)
+		return err
 	}
+	s.unfiltered = unfiltered
 
-	return evCh, errCh, nil
+	if report.Fallback != nil {
+		slog.Warn("Failed to attach kernel pre-filter, filtering in userspace.", "error", report.Fallback)
+	}
+	slog.Info("Applied kernel pre-filter.",
+		"events", report.Events, "rules", report.Rules, "guards", report.Guards,
+		"userspace", !report.Complete, "instructions", report.Instructions,
+		"tail_dropped", unfiltered,
+	)
+
+	return nil
 }
 
-// startEventProcessor starts the event processing goroutine.
+// closeSource closes the event source and records its lost events.
+func (s *Service) closeSource(source EventSource) {
+	s.mu.Lock()
+	s.source = nil
+	s.mu.Unlock()
+
+	_ = source.Close()
+	s.recordLoss(source.TakeLoss())
+}
+
+// startEventProcessor starts the event processing goroutines. The
+// dispatcher distributes the events to the workers by flow, so the events of
+// a flow are processed in order. It ends when evCh is closed or the context
+// is done, the workers end once their events are processed. It answers
+// liveness probes of the watchdog once it may take the read lock, the
+// workers note since when they are busy for the watchdog.
 func (s *Service) startEventProcessor(ctx context.Context, evCh chan conntrack.Event) *errgroup.Group {
+	s.probe = make(chan chan struct{})
+	probe := s.probe
+
+	workers := make([]chan conntrack.Event, runtime.GOMAXPROCS(0))
+	s.busy = make([]atomic.Int64, len(workers))
+	shard := func(flow uint32) int {
+		return int(flow % uint32(len(workers)))
+	}
+	for i := range workers {
+		workers[i] = make(chan conntrack.Event, queueSize/len(workers)+1)
+		s.pending.Add(1)
+		go func() {
+			defer s.pending.Done()
+			s.processEvents(workers[i], func(flow uint32) bool { return shard(flow) == i }, &s.busy[i])
+		}()
+	}
+
 	var g errgroup.Group
 	g.Go(func() error {
+		defer func() {
+			for _, worker := range workers {
+				close(worker)
+			}
+		}()
+
 		for {
 			select {
 			case <-ctx.Done():
 				return nil
+			case reply := <-probe:
+				s.mu.RLock()
+				s.mu.RUnlock()
+				close(reply)
 			case event, ok := <-evCh:
 				if !ok {
 					return nil
 				}
-				go s.processEvent(event)
+				s.processing.Add(1)
+				workers[shard(event.Flow.ID)] <- event
 			}
 		}
 	})
 	return &g
 }
 
+// processEvents processes the events of a worker until the channel is
+// closed. UPDATE events held by the deduplicator are recorded once their
+// coalescing window closed, for the flows owned by the worker only to keep
+// the events of a flow in order. busy is the start time of the current work
+// in Unix nanoseconds, 0 while idle.
+func (s *Service) processEvents(events chan conntrack.Event, owns func(flow uint32) bool, busy *atomic.Int64) {
+	var expire <-chan time.Time
+	if s.Deduplicator != nil && s.Deduplicator.Window() > 0 {
+		ticker := time.NewTicker(s.Deduplicator.Window())
+		defer ticker.Stop()
+		expire = ticker.C
+	}
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			busy.Store(time.Now().UnixNano())
+			s.processEvent(event)
+			busy.Store(0)
+			s.processing.Add(-1)
+		case <-expire:
+			busy.Store(time.Now().UnixNano())
+			s.recordHeld(s.Deduplicator.Expire(owns))
+			busy.Store(0)
+		}
+	}
+}
+
+// startAggregatorExpiry starts the goroutine emitting summaries of idle and
+// long-lived flows.
+func (s *Service) startAggregatorExpiry(ctx context.Context, g *errgroup.Group) {
+	if s.Aggregator == nil {
+		return
+	}
+
+	g.Go(func() error {
+		ticker := time.NewTicker(expireInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				s.mu.RLock()
+				s.logSummaries(s.Aggregator.Expire())
+				s.mu.RUnlock()
+			}
+		}
+	})
+}
+
+// flushDeduplicator records the UPDATE events still held by the
+// deduplicator.
+func (s *Service) flushDeduplicator() {
+	if s.Deduplicator == nil {
+		return
+	}
+
+	s.recordHeld(s.Deduplicator.Flush())
+}
+
+// recordHeld records UPDATE events held by the deduplicator with their
+// filter policy.
+func (s *Service) recordHeld(held []dedup.Held) {
+	if len(held) == 0 {
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	geo := s.GeoIP.Lookup()
+	for _, h := range held {
+		s.recordEvent(h.Event, geo, h.Policy)
+	}
+}
+
+// flushAggregator emits partial summaries of all still tracked flows.
+func (s *Service) flushAggregator() {
+	if s.Aggregator == nil {
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	s.logSummaries(s.Aggregator.Flush())
+}
+
+// startSuppressionReport starts the goroutine reporting suppressed UPDATE
+// events.
+func (s *Service) startSuppressionReport(ctx context.Context, g *errgroup.Group) {
+	if s.Deduplicator == nil {
+		return
+	}
+
+	g.Go(func() error {
+		ticker := time.NewTicker(reportInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				s.reportSuppressed()
+			}
+		}
+	})
+}
+
+// reportSuppressed logs the number of suppressed UPDATE events, if any.
+func (s *Service) reportSuppressed() {
+	if s.Deduplicator == nil {
+		return
+	}
+
+	stats := s.Deduplicator.Stats()
+	if stats.Unchanged == 0 && stats.Coalesced == 0 {
+		return
+	}
+
+	slog.Info("Suppressed UPDATE events.",
+		"unchanged", stats.Unchanged, "coalesced", stats.Coalesced,
+		"flows", s.Deduplicator.Len(),
+	)
+}
+
+// startStatusReport starts the goroutine reporting event rates to systemd,
+// if started by systemd.
+func (s *Service) startStatusReport(ctx context.Context, g *errgroup.Group) {
+	if !notify.Enabled() {
+		return
+	}
+
+	g.Go(func() error {
+		ticker := time.NewTicker(statusInterval)
+		defer ticker.Stop()
+
+		previous, reported := s.eventCounts(), time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case now := <-ticker.C:
+				current := s.eventCounts()
+				notify.Status(rateStatus(previous, current, now.Sub(reported)))
+				previous, reported = current, now
+			}
+		}
+	})
+}
+
+// eventCounts returns the current event counts.
+func (s *Service) eventCounts() eventCounts {
+	return eventCounts{
+		received: s.received.Load(),
+		recorded: s.recorded.Load(),
+		dropped:  s.dropped.Load(),
+	}
+}
+
+// rateStatus returns the status line of the event rates between the counts.
+func rateStatus(previous, current eventCounts, elapsed time.Duration) string {
+	seconds := elapsed.Seconds()
+	if seconds <= 0 {
+		seconds = 1
+	}
+
+	return fmt.Sprintf("Processing %.1f events/s, recorded %.1f/s, dropped %.1f/s.",
+		float64(current.received-previous.received)/seconds,
+		float64(current.recorded-previous.recorded)/seconds,
+		float64(current.dropped-previous.dropped)/seconds,
+	)
+}
+
+// startWatchdog starts the goroutine notifying the systemd watchdog, if
+// enabled. Notifications are sent while the event processor makes progress
+// only, so systemd restarts a wedged service.
+func (s *Service) startWatchdog(ctx context.Context, g *errgroup.Group) {
+	interval := notify.WatchdogInterval()
+	if interval <= 0 {
+		return
+	}
+	slog.Info("Enabled systemd watchdog.", "interval", interval)
+
+	g.Go(func() error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				if busy := s.busySince(); busy > interval {
+					slog.Warn("Event processor stalled, skipping watchdog notification.", "busy", busy)
+					continue
+				}
+				if !s.alive(ctx, interval) {
+					slog.Warn("Event processor unresponsive, skipping watchdog notification.")
+					continue
+				}
+				notify.Watchdog()
+			}
+		}
+	})
+}
+
+// busySince returns for how long the longest busy worker is working on its
+// current event, 0 if all workers are idle.
+func (s *Service) busySince() time.Duration {
+	now := time.Now().UnixNano()
+
+	var longest time.Duration
+	for i := range s.busy {
+		if started := s.busy[i].Load(); started != 0 {
+			longest = max(longest, time.Duration(now-started))
+		}
+	}
+
+	return longest
+}
+
+// alive reports whether the event processor answers a liveness probe within
+// the timeout.
+func (s *Service) alive(ctx context.Context, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	reply := make(chan struct{})
+	select {
+	case s.probe <- reply:
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+
+	select {
+	case <-reply:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// startLossReport starts the goroutine recording lost events.
+func (s *Service) startLossReport(ctx context.Context, g *errgroup.Group) {
+	if !s.Netlink.ReportLoss {
+		return
+	}
+
+	g.Go(func() error {
+		ticker := time.NewTicker(lossInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				s.mu.RLock()
+				source := s.source
+				s.mu.RUnlock()
+				if source != nil {
+					s.recordLoss(source.TakeLoss())
+				}
+			}
+		}
+	})
+}
+
+// recordLoss logs receive buffer overruns, if any, and writes a gap record
+// to all sinks.
+func (s *Service) recordLoss(loss listener.Loss) {
+	if loss.Overruns == 0 {
+		return
+	}
+
+	slog.Warn("Lost conntrack events, netlink receive buffer overrun.",
+		"overruns", loss.Overruns, "first", loss.First, "last", loss.Last,
+	)
+
+	gap := record.NewGap(record.GapOverrun, loss.First, loss.Last)
+	gap.Overruns = loss.Overruns
+	s.logGap(gap)
+}
+
+// logGap writes the gap record to all sinks.
+func (s *Service) logGap(gap *record.Gap) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	gap.Log(s.Sink.Route(nil))
+}
+
+// logSummaries writes the flow summaries to the sink and publishes them to
+// subscribers. The caller must hold the read lock.
+func (s *Service) logSummaries(summaries []*record.Event) {
+	for _, summary := range summaries {
+		summary.Log(s.Sink.Route(summary.Sinks))
+
+		if !s.subscribers.Active() {
+			continue
+		}
+		if event, err := summary.FlowEvent(); err == nil {
+			s.subscribers.Publish(event, s.GeoIP.Lookup(), func() *record.Event { return summary }, "")
+		}
+	}
+}
+
 // processEvent processes a single conntrack event.
 func (s *Service) processEvent(event conntrack.Event) {
 	// Only process TCP and UDP events, ignore all other protocols (ICMP, etc.)
@@ -123,43 +667,95 @@ func (s *Service) processEvent(event conntrack.Event) {
 		return
 	}
 
-	shouldRecord := true
+	s.received.Add(1)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	geo := s.GeoIP.Lookup()
+
+	var policy record.Policy
 	if s.Filter != nil {
-		_, shouldLog, _ := s.Filter.Evaluate(event)
-		shouldRecord = shouldLog
+		verdict := s.Filter.Decide(event, geo)
+		if verdict.Matched {
+			slog.Debug("Filter rule matched.", "rule", verdict.Rule, "action", verdict.Action, "record", verdict.Log)
+		}
+		if !verdict.Log {
+			s.dropped.Add(1)
+			droppedBy := verdict.Rule
+			if !verdict.Matched {
+				droppedBy = tail.DefaultAction
+			}
+			s.subscribers.Publish(event, geo, func() *record.Event {
+				return record.NewEvent(event, geo)
+			}, droppedBy)
+			return
+		}
+		policy = record.Policy{Tags: verdict.Tags, Alert: verdict.Alert, Sinks: verdict.Sinks}
 	}
 
-	if shouldRecord {
-		record.Record(event, s.GeoIP, s.Sink.Logger)
+	if s.Deduplicator != nil && !s.Deduplicator.Allow(event, policy) {
+		return
 	}
+
+	s.recordEvent(event, geo, policy)
 }
 
-// handleShutdown manages graceful shutdown of the service.
-func (s *Service) handleShutdown(ctx context.Context, cancel context.CancelFunc, con *conntrack.Conn, g *errgroup.Group, errCh chan error) bool {
-	select {
-	case err := <-errCh:
-		if err != nil {
-			cancel()
-			slog.Error("Conntrack listener error.", "error", err)
-			_ = con.Close()
-			if gErr := g.Wait(); gErr != nil {
-				slog.Error("Event loop returned error during shutdown.", "error", gErr)
+// recordEvent records the event allowed by filter and deduplicator, or adds
+// it to its aggregated flow. The caller must hold the read lock.
+func (s *Service) recordEvent(event conntrack.Event, geo *geoip.Lookup, policy record.Policy) {
+	s.recorded.Add(1)
+
+	if s.Aggregator != nil {
+		slog.Debug("Conntrack Event", "data", event)
+		s.logSummaries(s.Aggregator.Add(event, geo, policy))
+		return
+	}
+
+	record.Record(event, geo, policy, s.Sink.Route(policy.Sinks))
+	s.subscribers.Publish(event, geo, func() *record.Event {
+		e := record.NewEvent(event, geo)
+		e.Apply(policy)
+		return e
+	}, "")
+}
+
+// handleShutdown manages graceful shutdown of the service. Listener errors
+// are followed by reconnects, the service shuts down if reconnecting fails
+// or the event source is exhausted. Received events are processed before
+// returning.
+func (s *Service) handleShutdown(ctx context.Context, cancel context.CancelFunc, source EventSource, g *errgroup.Group, evCh chan conntrack.Event) bool {
+	tranquil := true
+	retry := &retry{config: s.Reconnect}
+	for source != nil {
+		connected := time.Now()
+		errCh := source.Listen(evCh)
+
+		select {
+		case err, ok := <-errCh:
+			if !ok {
+				slog.Info("Event source exhausted.")
+				s.closeSource(source)
+				source = nil
+				continue
 			}
-			return false
-		}
-		cancel()
-		_ = con.Close()
-		if gErr := g.Wait(); gErr != nil {
-			slog.Error("Event loop returned error during shutdown.", "error", gErr)
-		}
-		return true
-	case <-ctx.Done():
-		slog.Info("Shutting down conntrack listener.")
-		cancel()
-		_ = con.Close()
-		if gErr := g.Wait(); gErr != nil {
-			slog.Error("Event loop returned error during shutdown.", "error", gErr)
+			slog.Error("Conntrack listener error.", "error", err)
+			s.closeSource(source)
+			source, tranquil = s.reconnect(ctx, retry, connected, evCh)
+		case <-ctx.Done():
+			slog.Info("Shutting down conntrack listener.")
+			notify.Stopping()
+			s.closeSource(source)
+			source = nil
 		}
-		return true
 	}
+
+	close(evCh)
+	cancel()
+	if gErr := g.Wait(); gErr != nil {
+		slog.Error("Event loop returned error during shutdown.", "error", gErr)
+	}
+	s.pending.Wait()
+
+	return tranquil
 }
@@ -7,16 +7,26 @@ package service
 import (
 	"bytes"
 	"context"
+	"io"
 	"log/slog"
+	"net"
 	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/ti-mo/conntrack"
+	"github.com/tschaefer/conntrackd/internal/aggregator"
+	"github.com/tschaefer/conntrackd/internal/dedup"
 	"github.com/tschaefer/conntrackd/internal/filter"
+	"github.com/tschaefer/conntrackd/internal/listener"
 	"github.com/tschaefer/conntrackd/internal/logger"
+	"github.com/tschaefer/conntrackd/internal/replay"
 	"github.com/tschaefer/conntrackd/internal/sink"
 )
 
@@ -104,6 +114,160 @@ func processEventDoesNotRecordIfFilteredOut(t *testing.T) {
 	assert.Len(t, record.String(), 0, "No log output expected for filtered out event")
 }
 
+func processEventDoesRecordAlertWithTags(t *testing.T) {
+	sink, logger, record := __setupSinkAndLogger(t)
+	filter, err := filter.NewFilter([]string{"tag web destination.port == 80", "alert true"})
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+	svc, err := NewService(logger, nil, filter, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	event := __createEvent(syscall.IPPROTO_TCP)
+	svc.processEvent(event)
+	assert.Contains(t, record.String(), "level=WARN")
+	assert.Contains(t, record.String(), "tags=[web]")
+}
+
+func processEventDoesRecordSummaryIfAggregated(t *testing.T) {
+	sink, logger, record := __setupSinkAndLogger(t)
+	svc, err := NewService(logger, nil, nil, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	svc.Aggregator = aggregator.NewAggregator(aggregator.Config{})
+
+	event := __createEvent(syscall.IPPROTO_TCP)
+	event.Type = conntrack.EventNew
+	svc.processEvent(event)
+	assert.Len(t, record.String(), 0, "No log output expected for aggregated event")
+
+	event.Type = conntrack.EventDestroy
+	svc.processEvent(event)
+	assert.Contains(t, record.String(), "type=SUMMARY")
+	assert.Contains(t, record.String(), "reason=destroy")
+	assert.Contains(t, record.String(), "events=2")
+
+	record.Reset()
+	svc.processEvent(__createEvent(syscall.IPPROTO_UDP))
+	svc.flushAggregator()
+	assert.Contains(t, record.String(), "reason=shutdown")
+}
+
+func processEventDoesNotRecordUnchangedUpdate(t *testing.T) {
+	sink, logger, record := __setupSinkAndLogger(t)
+	svc, err := NewService(logger, nil, nil, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	svc.Deduplicator = dedup.NewDeduplicator(dedup.Config{StateChanges: true})
+
+	event := __createEvent(syscall.IPPROTO_TCP)
+	event.Type = conntrack.EventNew
+	svc.processEvent(event)
+	assert.Greater(t, len(record.String()), 0, "Log output expected for NEW event")
+
+	record.Reset()
+	event.Type = conntrack.EventUpdate
+	svc.processEvent(event)
+	assert.Len(t, record.String(), 0, "No log output expected for unchanged UPDATE event")
+	assert.Equal(t, dedup.Stats{Unchanged: 1}, svc.Deduplicator.Stats())
+}
+
+func reloadReplacesFilterAndSink(t *testing.T) {
+	sink, logger, record := __setupSinkAndLogger(t)
+	svc, err := NewService(logger, nil, nil, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	drop, err := filter.NewFilter([]string{"drop true"})
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+	assert.Nil(t, svc.Reload(drop, nil, sink))
+
+	event := __createEvent(syscall.IPPROTO_TCP)
+	svc.processEvent(event)
+	assert.Len(t, record.String(), 0, "No log output expected for filtered out event")
+
+	reloaded, _, reloadedRecord := __setupSinkAndLogger(t)
+	assert.Nil(t, svc.Reload(nil, nil, reloaded))
+
+	svc.processEvent(event)
+	assert.Len(t, record.String(), 0, "No log output expected on previous sink")
+	assert.Greater(t, len(reloadedRecord.String()), 0, "Log output expected on reloaded sink")
+}
+
+func statusReportsRulesAndSinks(t *testing.T) {
+	_, logger, _ := __setupSinkAndLogger(t)
+	filter, err := filter.NewFilter([]string{"drop destination.port == 80", "log true"})
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+	sink, err := sink.NewSink(&sink.Config{Stream: sink.Stream{Enable: true, Writer: "discard"}})
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	svc, err := NewService(logger, nil, filter, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	svc.processEvent(__createEvent(syscall.IPPROTO_TCP))
+	assert.NoError(t, svc.SetSink("stream", false))
+	assert.Error(t, svc.SetSink("loki", false))
+
+	status := svc.Status()
+	assert.Equal(t, "INFO", status.LogLevel)
+	assert.Equal(t, "log", status.Filter.Default)
+	assert.Equal(t, "skip", status.Filter.OnError)
+	assert.Len(t, status.Filter.Rules, 2)
+	assert.Equal(t, "drop", status.Filter.Rules[0].Action)
+	assert.Equal(t, "destination.port == 80", status.Filter.Rules[0].Expr)
+	assert.Equal(t, uint64(1), status.Filter.Rules[0].Matches)
+	assert.Equal(t, uint64(0), status.Filter.Rules[1].Matches)
+	assert.Equal(t, "stream", status.Sinks[0].Name)
+	assert.False(t, status.Sinks[0].Enabled)
+	assert.Empty(t, status.GeoIP)
+	assert.Zero(t, status.Uptime, "not running")
+}
+
+func processEventPublishesToSubscribers(t *testing.T) {
+	sink, logger, _ := __setupSinkAndLogger(t)
+	filter, err := filter.NewFilter([]string{"drop destination.port == 80"})
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+	svc, err := NewService(logger, nil, filter, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	recorded, err := svc.Subscribe("source.port == 12344", false)
+	assert.NoError(t, err)
+	dropped, err := svc.Subscribe("", true)
+	assert.NoError(t, err)
+	defer svc.Unsubscribe(recorded)
+	defer svc.Unsubscribe(dropped)
+
+	svc.processEvent(__createEvent(syscall.IPPROTO_TCP))
+	assert.Empty(t, recorded.Records(), "dropped events only for subscribers of dropped events")
+	if assert.Len(t, dropped.Records(), 1) {
+		r := <-dropped.Records()
+		assert.Equal(t, "filter[0]", r.DroppedBy)
+		assert.Equal(t, "7.8.8.8", r.DstAddr)
+	}
+
+	assert.Nil(t, svc.Reload(nil, nil, sink))
+	svc.processEvent(__createEvent(syscall.IPPROTO_TCP))
+	assert.Len(t, recorded.Records(), 1)
+	assert.Len(t, dropped.Records(), 1)
+	assert.Empty(t, (<-recorded.Records()).DroppedBy)
+}
+
 func startEventProcessorStartsGoroutine(t *testing.T) {
 	sink, logger, _ := __setupSinkAndLogger(t)
 	svc, err := NewService(logger, nil, nil, sink)
@@ -136,15 +300,404 @@ func startEventProcessorDoesRecordOnEvent(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 	cancel()
 	g.Wait()
+	svc.pending.Wait()
 
 	assert.Greater(t, len(record.String()), 0, "Log output expected for processed event")
 }
 
+func recordLossDoesRecordGap(t *testing.T) {
+	sink, logger, record := __setupSinkAndLogger(t)
+	svc, err := NewService(logger, nil, nil, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	svc.recordLoss(listener.Loss{})
+	assert.Len(t, record.String(), 0, "No log output expected without loss")
+
+	now := time.Now()
+	svc.recordLoss(listener.Loss{Overruns: 2, First: now, Last: now})
+	assert.Contains(t, record.String(), "level=WARN")
+	assert.Contains(t, record.String(), "type=GAP")
+	assert.Contains(t, record.String(), "overruns=2")
+}
+
+// prefilterSource records the applied prefilters
+type prefilterSource struct {
+	EventSource
+	applied []filter.Prefilter
+}
+
+func (p *prefilterSource) Apply(prefilter filter.Prefilter) (listener.Report, error) {
+	p.applied = append(p.applied, prefilter)
+	return listener.Report{Events: prefilter.Events, Complete: prefilter.Complete}, nil
+}
+
+func subscribeAcceptsAllEventsForDropped(t *testing.T) {
+	sink, logger, _ := __setupSinkAndLogger(t)
+	f, err := filter.NewFilter([]string{`drop event.type == "UPDATE"`, `drop destination.port == 22`})
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+	svc, err := NewService(logger, nil, f, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	source := &prefilterSource{}
+	svc.source = source
+
+	recorded, err := svc.Subscribe("", false)
+	assert.NoError(t, err)
+	assert.Empty(t, source.applied)
+
+	dropped, err := svc.Subscribe("", true)
+	assert.NoError(t, err)
+	if assert.Len(t, source.applied, 1) {
+		assert.Equal(t, filter.Prefilter{Events: filter.EventTypes, Accept: true}, source.applied[0])
+	}
+
+	svc.Unsubscribe(recorded)
+	assert.Len(t, source.applied, 1)
+
+	svc.Unsubscribe(dropped)
+	if assert.Len(t, source.applied, 2) {
+		assert.Equal(t, f.Prefilter(), source.applied[1])
+	}
+}
+
+// __replaySource returns a dial function replaying the JSON records
+func __replaySource(t *testing.T, records ...string) func() (EventSource, error) {
+	path := filepath.Join(t.TempDir(), "events.json")
+	assert.NoError(t, os.WriteFile(path, []byte(strings.Join(records, "\n")), 0o600))
+
+	return func() (EventSource, error) {
+		return replay.Open(replay.Config{Path: path})
+	}
+}
+
+const (
+	recordNew     = `{"type":"NEW","flow":1,"prot":"TCP","src_addr":"10.0.0.1","dst_addr":"10.0.0.2","src_port":4711,"dst_port":443}`
+	recordUpdate  = `{"type":"UPDATE","flow":1,"prot":"TCP","src_addr":"10.0.0.1","dst_addr":"10.0.0.2","src_port":4711,"dst_port":443,"tcp_state":"ESTABLISHED"}`
+	recordDestroy = `{"type":"DESTROY","flow":1,"prot":"TCP","src_addr":"10.0.0.1","dst_addr":"10.0.0.2","src_port":4711,"dst_port":443}`
+	recordDNS     = `{"type":"NEW","flow":2,"prot":"UDP","src_addr":"10.0.0.1","dst_addr":"10.0.0.53","src_port":4711,"dst_port":53}`
+)
+
+func runRecordsReplayedEvents(t *testing.T) {
+	sink, logger, record := __setupSinkAndLogger(t)
+	f, err := filter.NewFilter([]string{`drop event.type == "UPDATE"`, `alert destination.port == 53`})
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+	svc, err := NewService(logger, nil, f, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	svc.Dial = __replaySource(t, recordNew, recordUpdate, recordDNS, recordDestroy)
+
+	assert.True(t, svc.Run(context.Background()))
+
+	lines := strings.Split(strings.TrimSpace(record.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.Contains(t, record.String(), "type=NEW")
+	assert.Contains(t, record.String(), "type=DESTROY")
+	assert.NotContains(t, record.String(), "type=UPDATE")
+	assert.Contains(t, record.String(), "level=WARN msg=\"NEW UDP connection")
+}
+
+func runRecordsSummariesOfReplayedEvents(t *testing.T) {
+	sink, logger, record := __setupSinkAndLogger(t)
+	svc, err := NewService(logger, nil, nil, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	svc.Aggregator = aggregator.NewAggregator(aggregator.Config{IdleTimeout: time.Hour, ActiveTimeout: time.Hour, MaxFlows: 16})
+	svc.Dial = __replaySource(t, recordNew, recordDNS)
+
+	assert.True(t, svc.Run(context.Background()))
+
+	assert.Equal(t, 2, strings.Count(record.String(), "type=SUMMARY"))
+	assert.Equal(t, 2, strings.Count(record.String(), "reason=shutdown"))
+}
+
+func runPublishesSummariesToSubscribers(t *testing.T) {
+	sink, logger, _ := __setupSinkAndLogger(t)
+	svc, err := NewService(logger, nil, nil, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	svc.Aggregator = aggregator.NewAggregator(aggregator.Config{IdleTimeout: time.Hour, ActiveTimeout: time.Hour, MaxFlows: 16})
+	svc.Dial = __replaySource(t, recordNew, recordUpdate, recordDNS, recordDestroy)
+
+	sub, err := svc.Subscribe("destination.port == 443", false)
+	assert.NoError(t, err)
+	defer svc.Unsubscribe(sub)
+
+	assert.True(t, svc.Run(context.Background()))
+
+	if assert.Len(t, sub.Records(), 1) {
+		r := <-sub.Records()
+		assert.Equal(t, "SUMMARY", r.Type)
+		assert.Equal(t, "destroy", r.Reason)
+		assert.Equal(t, uint64(3), r.Events)
+	}
+}
+
+func runRecordsOneSummaryPerFlow(t *testing.T) {
+	sink, logger, record := __setupSinkAndLogger(t)
+	svc, err := NewService(logger, nil, nil, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	svc.Aggregator = aggregator.NewAggregator(aggregator.Config{IdleTimeout: time.Hour, ActiveTimeout: time.Hour})
+
+	flows := 100
+	var records []string
+	for flow := 1; flow <= flows; flow++ {
+		id := `"flow":` + strconv.Itoa(flow) + `,`
+		for _, r := range []string{recordNew, recordUpdate, recordDestroy} {
+			records = append(records, strings.Replace(r, `"flow":1,`, id, 1))
+		}
+	}
+	svc.Dial = __replaySource(t, records...)
+
+	assert.True(t, svc.Run(context.Background()))
+
+	assert.Equal(t, flows, strings.Count(record.String(), "type=SUMMARY"))
+	assert.Equal(t, flows, strings.Count(record.String(), "reason=destroy"))
+	assert.Equal(t, flows, strings.Count(record.String(), "events=3"))
+	assert.Zero(t, svc.Aggregator.Len())
+}
+
+func runRecordsLastCoalescedUpdate(t *testing.T) {
+	sink, logger, record := __setupSinkAndLogger(t)
+	svc, err := NewService(logger, nil, nil, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	svc.Deduplicator = dedup.NewDeduplicator(dedup.Config{Window: time.Hour})
+	finWait := strings.Replace(recordUpdate, "ESTABLISHED", "FIN_WAIT", 1)
+	svc.Dial = __replaySource(t, recordNew, recordUpdate, finWait)
+
+	assert.True(t, svc.Run(context.Background()))
+
+	lines := strings.Split(strings.TrimSpace(record.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], "type=NEW")
+	assert.Contains(t, lines[1], "type=UPDATE")
+	assert.Contains(t, lines[1], "tcp_state=FIN_WAIT")
+}
+
+func runGivesUpOnSourceError(t *testing.T) {
+	sink, logger, record := __setupSinkAndLogger(t)
+	svc, err := NewService(logger, nil, nil, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	svc.Dial = __replaySource(t, recordNew, `{"type":"INVALID"}`)
+
+	assert.False(t, svc.Run(context.Background()))
+	assert.Contains(t, record.String(), "type=NEW")
+}
+
+func runReconnectsAfterSourceError(t *testing.T) {
+	sink, logger, record := __setupSinkAndLogger(t)
+	svc, err := NewService(logger, nil, nil, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	failing := __replaySource(t, recordNew, `{"type":"INVALID"}`)
+	exhausted := __replaySource(t, recordDestroy)
+	dials := 0
+	svc.Dial = func() (EventSource, error) {
+		dials++
+		if dials == 1 {
+			return failing()
+		}
+		return exhausted()
+	}
+	svc.Reconnect = Reconnect{MaxFailures: 2, Backoff: time.Millisecond}
+
+	assert.True(t, svc.Run(context.Background()))
+	assert.Equal(t, 2, dials)
+	assert.Contains(t, record.String(), "type=GAP reason=reconnect")
+	assert.Contains(t, record.String(), "type=DESTROY")
+}
+
+// __notifySocket sets NOTIFY_SOCKET to a fake systemd notification socket
+// and returns a function receiving the next notification
+func __notifySocket(t *testing.T) func() string {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("failed to listen on notify socket: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	return func() string {
+		buf := make([]byte, 4096)
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return ""
+		}
+		return string(buf[:n])
+	}
+}
+
+func runNotifiesSystemd(t *testing.T) {
+	receive := __notifySocket(t)
+	sink, logger, _ := __setupSinkAndLogger(t)
+	svc, err := NewService(logger, nil, nil, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	failing := __replaySource(t, recordNew, `{"type":"INVALID"}`)
+	exhausted := __replaySource(t, recordDestroy)
+	dials := 0
+	svc.Dial = func() (EventSource, error) {
+		dials++
+		if dials == 1 {
+			return failing()
+		}
+		return exhausted()
+	}
+	svc.Reconnect = Reconnect{MaxFailures: 2, Backoff: time.Millisecond}
+
+	assert.True(t, svc.Run(context.Background()))
+	assert.Equal(t, "READY=1", receive())
+	assert.Equal(t, "STATUS=Listening for conntrack events.", receive())
+	assert.Equal(t, "STATUS=Reconnecting to conntrack, attempt 1.", receive())
+	assert.Equal(t, "STATUS=Listening for conntrack events.", receive())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	svc.Dial = func() (EventSource, error) {
+		cancel()
+		return exhausted()
+	}
+	assert.True(t, svc.Run(ctx))
+	assert.Equal(t, "READY=1", receive())
+	assert.Equal(t, "STATUS=Listening for conntrack events.", receive())
+	assert.Equal(t, "STOPPING=1", receive())
+}
+
+func watchdogRequiresEventProcessor(t *testing.T) {
+	receive := __notifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	sink, logger, _ := __setupSinkAndLogger(t)
+	svc, err := NewService(logger, nil, nil, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g := svc.startEventProcessor(ctx, make(chan conntrack.Event))
+	svc.startWatchdog(ctx, g)
+	assert.Equal(t, "WATCHDOG=1", receive())
+
+	svc.mu.Lock()
+	notification := receive()
+	for i := 0; i < 5 && notification == "WATCHDOG=1"; i++ {
+		notification = receive()
+	}
+	assert.Empty(t, notification, "no notification while event processor is wedged")
+	svc.mu.Unlock()
+	assert.Equal(t, "WATCHDOG=1", receive())
+
+	cancel()
+	_ = g.Wait()
+}
+
+func watchdogRequiresWorkerProgress(t *testing.T) {
+	receive := __notifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	_, logger, _ := __setupSinkAndLogger(t)
+	reader, writer := io.Pipe()
+	defer func() {
+		_ = reader.Close()
+	}()
+	sink := &sink.Sink{Logger: slog.New(slog.NewTextHandler(writer, nil))}
+	svc, err := NewService(logger, nil, nil, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	evCh := make(chan conntrack.Event, 1)
+	g := svc.startEventProcessor(ctx, evCh)
+	svc.startWatchdog(ctx, g)
+	assert.Equal(t, "WATCHDOG=1", receive())
+
+	evCh <- __createEvent(syscall.IPPROTO_TCP)
+	notification := receive()
+	for i := 0; i < 5 && notification == "WATCHDOG=1"; i++ {
+		notification = receive()
+	}
+	assert.Empty(t, notification, "no notification while worker is stuck")
+
+	go func() {
+		_, _ = io.Copy(io.Discard, reader)
+	}()
+	assert.Equal(t, "WATCHDOG=1", receive())
+
+	cancel()
+	_ = g.Wait()
+}
+
+func rateStatusReportsRates(t *testing.T) {
+	previous := eventCounts{received: 10, recorded: 5, dropped: 2}
+	current := eventCounts{received: 30, recorded: 20, dropped: 4}
+
+	assert.Equal(t, "Processing 2.0 events/s, recorded 1.5/s, dropped 0.2/s.", rateStatus(previous, current, 10*time.Second))
+	assert.Equal(t, "Processing 0.0 events/s, recorded 0.0/s, dropped 0.0/s.", rateStatus(current, current, 0))
+}
+
+func runFailsIfSourceNotOpened(t *testing.T) {
+	sink, logger, _ := __setupSinkAndLogger(t)
+	svc, err := NewService(logger, nil, nil, sink)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	svc.Dial = func() (EventSource, error) {
+		return replay.Open(replay.Config{Path: filepath.Join(t.TempDir(), "missing")})
+	}
+
+	assert.False(t, svc.Run(context.Background()))
+}
+
 func TestService(t *testing.T) {
 	t.Run("service.New returns service", newReturnsService)
 	t.Run("service.processEvent does not record if event not TCP or UDP", processEventDoesNotRecordIfEventNotTCPorUDP)
 	t.Run("service.processEvent does record if event TCP or UDP", processEventDoesRecordIfEventTCPorUDP)
 	t.Run("service.processEvent does not record if filtered out", processEventDoesNotRecordIfFilteredOut)
+	t.Run("service.processEvent does record alert with tags", processEventDoesRecordAlertWithTags)
+	t.Run("service.processEvent does record summary if aggregated", processEventDoesRecordSummaryIfAggregated)
+	t.Run("service.processEvent does not record unchanged update", processEventDoesNotRecordUnchangedUpdate)
+	t.Run("service.Reload replaces filter and sink", reloadReplacesFilterAndSink)
+	t.Run("service.Status reports rules and sinks", statusReportsRulesAndSinks)
+	t.Run("service.processEvent publishes to subscribers", processEventPublishesToSubscribers)
+	t.Run("service.Subscribe accepts all events for dropped", subscribeAcceptsAllEventsForDropped)
 	t.Run("service.startEventProcessor starts goroutine", startEventProcessorStartsGoroutine)
 	t.Run("service.startEventProcessor does record on event", startEventProcessorDoesRecordOnEvent)
+	t.Run("service.recordLoss does record gap", recordLossDoesRecordGap)
+	t.Run("service.Run records replayed events", runRecordsReplayedEvents)
+	t.Run("service.Run records summaries of replayed events", runRecordsSummariesOfReplayedEvents)
+	t.Run("service.Run publishes summaries to subscribers", runPublishesSummariesToSubscribers)
+	t.Run("service.Run records one summary per flow", runRecordsOneSummaryPerFlow)
+	t.Run("service.Run records last coalesced update", runRecordsLastCoalescedUpdate)
+	t.Run("service.Run gives up on source error", runGivesUpOnSourceError)
+	t.Run("service.Run reconnects after source error", runReconnectsAfterSourceError)
+	t.Run("service.Run notifies systemd", runNotifiesSystemd)
+	t.Run("service.startWatchdog requires event processor", watchdogRequiresEventProcessor)
+	t.Run("service.startWatchdog requires worker progress", watchdogRequiresWorkerProgress)
+	t.Run("service.rateStatus reports rates", rateStatusReportsRates)
+	t.Run("service.Run fails if source not opened", runFailsIfSourceNotOpened)
 }
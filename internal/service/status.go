@@ -0,0 +1,111 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/tschaefer/conntrackd/internal/geoip"
+	"github.com/tschaefer/conntrackd/internal/logger"
+	"github.com/tschaefer/conntrackd/internal/sink"
+	"github.com/tschaefer/conntrackd/internal/version"
+)
+
+// Status is a snapshot of the running service.
+type Status struct {
+	Release  string           `json:"release"`
+	Commit   string           `json:"commit"`
+	Started  time.Time        `json:"started"`
+	Uptime   float64          `json:"uptime_seconds"`
+	LogLevel string           `json:"log_level"`
+	Sinks    []sink.Target    `json:"sinks"`
+	Filter   FilterStatus     `json:"filter"`
+	Queue    QueueStatus      `json:"queue"`
+	GeoIP    []geoip.Database `json:"geoip"`
+}
+
+// FilterStatus describes the loaded rule set.
+type FilterStatus struct {
+	Default string       `json:"default"`
+	OnError string       `json:"on_error"`
+	Rules   []RuleStatus `json:"rules"`
+}
+
+// RuleStatus describes a rule and its number of matched events and
+// evaluation errors.
+type RuleStatus struct {
+	Name    string `json:"name"`
+	Source  string `json:"source"`
+	Action  string `json:"action"`
+	Expr    string `json:"expr"`
+	Matches uint64 `json:"matches"`
+	Errors  uint64 `json:"errors"`
+}
+
+// QueueStatus describes the events received but not yet processed: events
+// waiting in the queue of the given capacity and events being processed.
+type QueueStatus struct {
+	Length     int   `json:"length"`
+	Capacity   int   `json:"capacity"`
+	Processing int64 `json:"processing"`
+}
+
+// Status returns a snapshot of the service.
+func (s *Service) Status() Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	status := Status{
+		Release:  version.Release(),
+		Commit:   version.Commit(),
+		Started:  s.started,
+		LogLevel: logger.Level().String(),
+		Sinks:    []sink.Target{},
+		Filter: FilterStatus{
+			Default: s.Filter.Default(),
+			OnError: string(s.Filter.OnError()),
+			Rules:   []RuleStatus{},
+		},
+		Queue: QueueStatus{
+			Length:     len(s.events),
+			Capacity:   cap(s.events),
+			Processing: s.processing.Load(),
+		},
+		GeoIP: s.GeoIP.Databases(),
+	}
+	if !s.started.IsZero() {
+		status.Uptime = time.Since(s.started).Seconds()
+	}
+	if s.Sink != nil {
+		status.Sinks = s.Sink.Targets()
+	}
+
+	matches, failures := s.Filter.Matches(), s.Filter.Errors()
+	for _, rule := range s.Filter.Rules() {
+		status.Filter.Rules = append(status.Filter.Rules, RuleStatus{
+			Name:    rule.Name,
+			Source:  rule.Source,
+			Action:  rule.Action,
+			Expr:    rule.Expr,
+			Matches: matches[rule.Name],
+			Errors:  failures[rule.Name],
+		})
+	}
+
+	return status
+}
+
+// SetSink enables or disables the named sink target. The configured state
+// is restored on Reload.
+func (s *Service) SetSink(name string, enabled bool) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.Sink == nil {
+		return errors.New("no sink available")
+	}
+	return s.Sink.SetEnabled(name, enabled)
+}
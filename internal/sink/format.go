@@ -0,0 +1,306 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package sink
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	slogcommon "github.com/samber/slog-common"
+	"github.com/tschaefer/conntrackd/internal/version"
+)
+
+// Available record formats
+var Formats = []string{"json", "logfmt", "text", "cef", "leef"}
+
+const (
+	formatVendor  = "tschaefer"
+	formatProduct = "conntrackd"
+)
+
+// encoder renders a log record with its attributes into a single line.
+type encoder func(record slog.Record, attrs []slog.Attr) string
+
+// framer wraps an encoded line for the transport, e.g. a syslog header.
+type framer func(record slog.Record, line string) string
+
+// encoders maps the non-JSON record formats to their encoder.
+var encoders = map[string]encoder{
+	"logfmt": encodeLogfmt,
+	"text":   encodeText,
+	"cef":    encodeCEF,
+	"leef":   encodeLEEF,
+}
+
+// Standard CEF extension keys for record attributes.
+var cefKeys = map[string]string{
+	"src_addr": "src",
+	"dst_addr": "dst",
+	"src_port": "spt",
+	"dst_port": "dpt",
+	"prot":     "proto",
+	"type":     "act",
+	"flow":     "externalId",
+	"src_lat":  "slat",
+	"src_lon":  "slong",
+	"dst_lat":  "dlat",
+	"dst_lon":  "dlong",
+}
+
+// Standard LEEF attribute keys for record attributes.
+var leefKeys = map[string]string{
+	"src_addr": "src",
+	"dst_addr": "dst",
+	"src_port": "srcPort",
+	"dst_port": "dstPort",
+	"prot":     "proto",
+	"type":     "act",
+}
+
+// Attributes already contained in the record message.
+var messageAttrs = []string{
+	"type", "prot",
+	"src_addr", "src_port", "dst_addr", "dst_port",
+}
+
+// lineHandler is a slog.Handler writing one encoded line per record.
+type lineHandler struct {
+	mu     *sync.Mutex
+	writer io.Writer
+	level  slog.Leveler
+	encode encoder
+	frame  framer
+	attrs  []slog.Attr
+	groups []string
+}
+
+// newLineHandler creates a handler for the given non-JSON format.
+func newLineHandler(format string, writer io.Writer, options *slog.HandlerOptions, frame framer) (slog.Handler, error) {
+	encode, ok := encoders[format]
+	if !ok {
+		return nil, fmt.Errorf("invalid format specified: %q", format)
+	}
+
+	var level slog.Leveler = slog.LevelInfo
+	if options != nil && options.Level != nil {
+		level = options.Level
+	}
+
+	return &lineHandler{
+		mu:     &sync.Mutex{},
+		writer: writer,
+		level:  level,
+		encode: encode,
+		frame:  frame,
+	}, nil
+}
+
+func (h *lineHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *lineHandler) Handle(_ context.Context, record slog.Record) error {
+	attrs := slogcommon.AppendRecordAttrsToAttrs(h.attrs, h.groups, &record)
+	attrs = flattenAttrs("", attrs)
+
+	line := h.encode(record, attrs)
+	if h.frame != nil {
+		line = h.frame(record, line)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.writer, line+"\n")
+	return err
+}
+
+func (h *lineHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = slogcommon.AppendAttrsToGroup(h.groups, h.attrs, attrs...)
+	return &c
+}
+
+func (h *lineHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.groups = append(slices.Clone(h.groups), name)
+	return &c
+}
+
+// flattenAttrs resolves attribute values and flattens groups into dotted keys.
+func flattenAttrs(prefix string, attrs []slog.Attr) []slog.Attr {
+	var flat []slog.Attr
+	for _, attr := range attrs {
+		attr.Value = attr.Value.Resolve()
+		key := attr.Key
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if attr.Value.Kind() == slog.KindGroup {
+			flat = append(flat, flattenAttrs(key, attr.Value.Group())...)
+			continue
+		}
+		if attr.Equal(slog.Attr{}) {
+			continue
+		}
+		flat = append(flat, slog.Attr{Key: key, Value: attr.Value})
+	}
+	return flat
+}
+
+// valueString returns the plain string representation of an attribute value.
+func valueString(v slog.Value) string {
+	switch v.Kind() {
+	case slog.KindTime:
+		return v.Time().Format(time.RFC3339Nano)
+	case slog.KindFloat64:
+		return strconv.FormatFloat(v.Float64(), 'f', -1, 64)
+	default:
+		return v.String()
+	}
+}
+
+// encodeLogfmt encodes a record as logfmt key=value pairs.
+func encodeLogfmt(record slog.Record, attrs []slog.Attr) string {
+	pairs := []string{
+		"time=" + logfmtValue(record.Time.Format(time.RFC3339Nano)),
+		"level=" + logfmtValue(record.Level.String()),
+		"msg=" + logfmtValue(record.Message),
+	}
+	for _, attr := range attrs {
+		pairs = append(pairs, attr.Key+"="+logfmtValue(valueString(attr.Value)))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// logfmtValue quotes a logfmt value if required.
+func logfmtValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\\\t\r\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// encodeText encodes a record as a compact human-readable line.
+func encodeText(record slog.Record, attrs []slog.Attr) string {
+	var b strings.Builder
+	b.WriteString(record.Time.Format(time.RFC3339))
+	fmt.Fprintf(&b, " %-5s %s", record.Level.String(), record.Message)
+	for _, attr := range attrs {
+		if slices.Contains(messageAttrs, attr.Key) {
+			continue
+		}
+		b.WriteString(" ")
+		b.WriteString(attr.Key + "=" + logfmtValue(valueString(attr.Value)))
+	}
+	return b.String()
+}
+
+// encodeCEF encodes a record in ArcSight Common Event Format.
+func encodeCEF(record slog.Record, attrs []slog.Attr) string {
+	signature := eventType(attrs)
+	header := []string{
+		"CEF:0",
+		cefHeader(formatVendor),
+		cefHeader(formatProduct),
+		cefHeader(version.Release()),
+		cefHeader(signature),
+		cefHeader(record.Message),
+		strconv.Itoa(severity(record.Level)),
+	}
+
+	extension := []string{
+		"rt=" + strconv.FormatInt(record.Time.UnixMilli(), 10),
+	}
+	for _, attr := range attrs {
+		key := attr.Key
+		if k, ok := cefKeys[key]; ok {
+			key = k
+		}
+		extension = append(extension, key+"="+cefExtension(valueString(attr.Value)))
+	}
+
+	return strings.Join(header, "|") + "|" + strings.Join(extension, " ")
+}
+
+// cefHeader escapes a CEF header field.
+func cefHeader(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "|", `\|`, "\n", " ", "\r", " ").Replace(s)
+}
+
+// cefExtension escapes a CEF extension value.
+func cefExtension(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "=", `\=`, "\n", `\n`, "\r", `\r`).Replace(s)
+}
+
+// encodeLEEF encodes a record in IBM QRadar Log Event Extended Format 1.0.
+func encodeLEEF(record slog.Record, attrs []slog.Attr) string {
+	signature := eventType(attrs)
+	header := []string{
+		"LEEF:1.0",
+		leefHeader(formatVendor),
+		leefHeader(formatProduct),
+		leefHeader(version.Release()),
+		leefHeader(signature),
+	}
+
+	extension := []string{
+		"devTime=" + strconv.FormatInt(record.Time.UnixMilli(), 10),
+		"sev=" + strconv.Itoa(severity(record.Level)),
+		"msg=" + leefAttribute(record.Message),
+	}
+	for _, attr := range attrs {
+		key := attr.Key
+		if k, ok := leefKeys[key]; ok {
+			key = k
+		}
+		extension = append(extension, key+"="+leefAttribute(valueString(attr.Value)))
+	}
+
+	return strings.Join(header, "|") + "|" + strings.Join(extension, "\t")
+}
+
+// leefHeader escapes a LEEF header field.
+func leefHeader(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "|", `\|`, "\n", " ", "\r", " ").Replace(s)
+}
+
+// leefAttribute escapes a LEEF attribute value.
+func leefAttribute(s string) string {
+	return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(s)
+}
+
+// eventType returns the record event type, used as CEF/LEEF event id.
+func eventType(attrs []slog.Attr) string {
+	for _, attr := range attrs {
+		if attr.Key == "type" {
+			return attr.Value.String()
+		}
+	}
+	return "EVENT"
+}
+
+// severity maps a log level to a CEF/LEEF severity (0-10).
+func severity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 8
+	case level >= slog.LevelWarn:
+		return 6
+	case level >= slog.LevelInfo:
+		return 3
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package sink
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func __createRecord() slog.Record {
+	record := slog.NewRecord(
+		time.Date(2025, 11, 25, 12, 35, 11, 0, time.UTC),
+		slog.LevelInfo,
+		"NEW TCP connection from 10.0.0.1:4711 to 8.8.8.8:443",
+		0,
+	)
+	record.AddAttrs(
+		slog.String("type", "NEW"),
+		slog.Uint64("flow", 1234),
+		slog.String("prot", "TCP"),
+		slog.String("src_addr", "10.0.0.1"),
+		slog.String("dst_addr", "8.8.8.8"),
+		slog.Uint64("src_port", 4711),
+		slog.Uint64("dst_port", 443),
+		slog.String("tcp_state", "SYN_SENT"),
+		slog.String("dst_city", "Mountain View"),
+	)
+	return record
+}
+
+func __handle(t *testing.T, format string) string {
+	var buf bytes.Buffer
+	handler, err := newLineHandler(format, &buf, &slog.HandlerOptions{}, nil)
+	assert.NoError(t, err)
+
+	err = handler.Handle(context.Background(), __createRecord())
+	assert.NoError(t, err)
+
+	return buf.String()
+}
+
+func newLineHandlerReturnsErrorIfFormatIsInvalid(t *testing.T) {
+	handler, err := newLineHandler("invalid-format", &bytes.Buffer{}, &slog.HandlerOptions{}, nil)
+	assert.Nil(t, handler)
+	assert.EqualError(t, err, "invalid format specified: \"invalid-format\"")
+}
+
+func encodeLogfmtWritesKeyValuePairs(t *testing.T) {
+	line := __handle(t, "logfmt")
+	assert.True(t, strings.HasPrefix(line, "time=2025-11-25T12:35:11Z level=INFO msg=\"NEW TCP connection"))
+	assert.Contains(t, line, " type=NEW flow=1234 prot=TCP src_addr=10.0.0.1 dst_addr=8.8.8.8")
+	assert.Contains(t, line, " dst_city=\"Mountain View\"")
+	assert.True(t, strings.HasSuffix(line, "\n"))
+}
+
+func encodeTextWritesCompactLine(t *testing.T) {
+	line := __handle(t, "text")
+	assert.Equal(t,
+		"2025-11-25T12:35:11Z INFO  NEW TCP connection from 10.0.0.1:4711 to 8.8.8.8:443 flow=1234 tcp_state=SYN_SENT dst_city=\"Mountain View\"\n",
+		line,
+	)
+}
+
+func encodeCEFMapsStandardKeys(t *testing.T) {
+	line := __handle(t, "cef")
+	assert.True(t, strings.HasPrefix(line, "CEF:0|tschaefer|conntrackd|"))
+	assert.Contains(t, line, "|NEW|NEW TCP connection from 10.0.0.1:4711 to 8.8.8.8:443|3|")
+	for _, field := range []string{"rt=1764074111000", "act=NEW", "externalId=1234", "proto=TCP", "src=10.0.0.1", "dst=8.8.8.8", "spt=4711", "dpt=443", "dst_city=Mountain View"} {
+		assert.Contains(t, line, field)
+	}
+}
+
+func encodeCEFEscapesValues(t *testing.T) {
+	assert.Equal(t, `a\|b\\c`, cefHeader(`a|b\c`))
+	assert.Equal(t, `a\=b\\c\n`, cefExtension("a=b\\c\n"))
+}
+
+func encodeLEEFMapsStandardKeys(t *testing.T) {
+	line := __handle(t, "leef")
+	assert.True(t, strings.HasPrefix(line, "LEEF:1.0|tschaefer|conntrackd|"))
+	assert.Contains(t, line, "|NEW|devTime=1764074111000\tsev=3\t")
+	for _, field := range []string{"act=NEW", "proto=TCP", "src=10.0.0.1", "dst=8.8.8.8", "srcPort=4711", "dstPort=443"} {
+		assert.Contains(t, line, "\t"+field)
+	}
+}
+
+func lineHandlerAppliesFrame(t *testing.T) {
+	var buf bytes.Buffer
+	frame := func(record slog.Record, line string) string {
+		return "<frame> " + line
+	}
+	handler, err := newLineHandler("text", &buf, &slog.HandlerOptions{}, frame)
+	assert.NoError(t, err)
+
+	err = handler.Handle(context.Background(), __createRecord())
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), "<frame> 2025-11-25T12:35:11Z"))
+}
+
+func lineHandlerFlattensGroups(t *testing.T) {
+	var buf bytes.Buffer
+	handler, err := newLineHandler("logfmt", &buf, &slog.HandlerOptions{}, nil)
+	assert.NoError(t, err)
+
+	logger := slog.New(handler).WithGroup("event")
+	logger.Info("message", "type", "NEW")
+	assert.Contains(t, buf.String(), " event.type=NEW")
+}
+
+func lineHandlerRespectsLevel(t *testing.T) {
+	handler, err := newLineHandler("text", &bytes.Buffer{}, &slog.HandlerOptions{Level: slog.LevelWarn}, nil)
+	assert.NoError(t, err)
+	assert.False(t, handler.Enabled(context.Background(), slog.LevelInfo))
+	assert.True(t, handler.Enabled(context.Background(), slog.LevelWarn))
+}
+
+func TestSinkFormat(t *testing.T) {
+	t.Run("format.newLineHandler returns error if format is invalid", newLineHandlerReturnsErrorIfFormatIsInvalid)
+	t.Run("format.encodeLogfmt writes key value pairs", encodeLogfmtWritesKeyValuePairs)
+	t.Run("format.encodeText writes compact line", encodeTextWritesCompactLine)
+	t.Run("format.encodeCEF maps standard keys", encodeCEFMapsStandardKeys)
+	t.Run("format.encodeCEF escapes values", encodeCEFEscapesValues)
+	t.Run("format.encodeLEEF maps standard keys", encodeLEEFMapsStandardKeys)
+	t.Run("format.lineHandler applies frame", lineHandlerAppliesFrame)
+	t.Run("format.lineHandler flattens groups", lineHandlerFlattensGroups)
+	t.Run("format.lineHandler respects level", lineHandlerRespectsLevel)
+}
@@ -6,7 +6,10 @@ package sink
 
 import (
 	"log/slog"
+	"strings"
 
+	"github.com/coreos/go-systemd/v22/journal"
+	slogcommon "github.com/samber/slog-common"
 	slogjournal "github.com/tschaefer/slog-journal"
 )
 
@@ -17,9 +20,28 @@ type Journal struct {
 
 // TargetJournal creates a sink target for systemd journal logging.
 func (j *Journal) TargetJournal(options *slog.HandlerOptions) (slog.Handler, error) {
-	slogjournal.FieldPrefix = "EVENT"
 	o := &slogjournal.Option{
-		Level: options.Level,
+		Level:     options.Level,
+		Converter: journalConverter,
 	}
 	return o.NewJournalHandler(), nil
 }
+
+// journalConverter converts a record into journal fields named like the
+// record fields, upper-cased as required by journald, without prefix.
+func journalConverter(_ bool, _ func([]string, slog.Attr) slog.Attr, loggerAttr []slog.Attr, groups []string, record *slog.Record) (string, journal.Priority, map[string]string) {
+	attrs := slogcommon.AppendRecordAttrsToAttrs(loggerAttr, groups, record)
+
+	fields := make(map[string]string, len(attrs))
+	for _, attr := range flattenAttrs("", attrs) {
+		name := strings.ToUpper(strings.ReplaceAll(attr.Key, ".", "_"))
+		fields[name] = valueString(attr.Value)
+	}
+
+	priority, ok := slogjournal.LogLevelToPriority[record.Level.String()]
+	if !ok {
+		priority = journal.PriDebug
+	}
+
+	return record.Message, priority, fields
+}
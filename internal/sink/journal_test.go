@@ -7,7 +7,9 @@ package sink
 import (
 	"log/slog"
 	"testing"
+	"time"
 
+	"github.com/coreos/go-systemd/v22/journal"
 	"github.com/stretchr/testify/assert"
 	slogjournal "github.com/tschaefer/slog-journal"
 )
@@ -22,6 +24,25 @@ func targetJournalReturnsHandler(t *testing.T) {
 	assert.IsType(t, &slogjournal.JournalHandler{}, handler)
 }
 
+func journalConverterReturnsRecordFields(t *testing.T) {
+	record := slog.NewRecord(time.Now(), slog.LevelWarn, "NEW TCP connection", 0)
+	record.AddAttrs(
+		slog.Int("schema_version", 2),
+		slog.String("src_addr", "10.19.80.100"),
+		slog.Float64("src_lat", 47.4906),
+	)
+
+	message, priority, fields := journalConverter(false, nil, nil, nil, &record)
+	assert.Equal(t, "NEW TCP connection", message)
+	assert.Equal(t, journal.PriWarning, priority)
+	assert.Equal(t, map[string]string{
+		"SCHEMA_VERSION": "2",
+		"SRC_ADDR":       "10.19.80.100",
+		"SRC_LAT":        "47.4906",
+	}, fields)
+}
+
 func TestSinkTargetJournal(t *testing.T) {
 	t.Run("journal.TargetJournal returns handler", targetJournalReturnsHandler)
+	t.Run("journal.journalConverter returns record fields", journalConverterReturnsRecordFields)
 }
@@ -10,7 +10,6 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"slices"
 	"strings"
 
 	kitlog "github.com/go-kit/log"
@@ -18,7 +17,6 @@ import (
 	"github.com/grafana/loki-client-go/loki"
 	"github.com/grafana/loki-client-go/pkg/labelutil"
 	"github.com/prometheus/common/model"
-	slogcommon "github.com/samber/slog-common"
 	slogloki "github.com/samber/slog-loki/v3"
 	"github.com/tschaefer/conntrackd/internal/logger"
 )
@@ -33,18 +31,13 @@ type Loki struct {
 	Enable  bool
 	Address string
 	Labels  []string
+
+	client *loki.Client
 }
 
 // Supported Loki protocols.
 var LokiProtocols = []string{"http", "https"}
 
-// Attributes to be used as labels in Loki payload.
-var labelAttrs = []string{
-	"flow", "type", "prot",
-	"src_addr", "src_port", "dst_addr", "dst_port",
-	"tcp_state",
-}
-
 // TargetLoki creates a sink target for Loki.
 func (l *Loki) TargetLoki(options *slog.HandlerOptions) (slog.Handler, error) {
 	url, err := url.Parse(l.Address)
@@ -73,16 +66,25 @@ func (l *Loki) TargetLoki(options *slog.HandlerOptions) (slog.Handler, error) {
 	if err != nil {
 		return nil, err
 	}
+	l.client = client
 
 	o := &slogloki.Option{
 		Client:                    client,
 		Level:                     options.Level,
 		HandleRecordsWithMetadata: true,
-		Converter:                 attrsToMetadata,
+		Converter:                 recordLabels,
 	}
 	return o.NewLokiHandler(), nil
 }
 
+// close sends the pending log lines and stops the Loki client.
+func (l *Loki) close() error {
+	if l.client != nil {
+		l.client.Stop()
+	}
+	return nil
+}
+
 // isReady checks if Loki server is ready to accept requests.
 func (l *Loki) isReady(url url.URL) error {
 	url.Path = url.Path + readyPath
@@ -140,16 +142,9 @@ func (l *Loki) createLogger() kitlog.Logger {
 	return klogger
 }
 
-// attrsToMetadata converts slog attributes to Loki metadata labels.
-func attrsToMetadata(addSource bool, replaceAttr func(groups []string, a slog.Attr) slog.Attr, loggerAttr []slog.Attr, groups []string, record *slog.Record) model.LabelSet {
-	attrs := slogcommon.AppendRecordAttrsToAttrs(loggerAttr, groups, record)
-
-	newRecord := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
-	for _, attr := range attrs {
-		if slices.Contains(labelAttrs, attr.Key) {
-			newRecord.AddAttrs(attr)
-		}
-	}
-
-	return slogloki.DefaultConverter(addSource, replaceAttr, loggerAttr, groups, &newRecord)
+// recordLabels returns the stream labels of a record, its level only. The
+// record fields are attached as structured metadata with their record field
+// names, as labels they would exceed the label limit of Loki.
+func recordLabels(addSource bool, replaceAttr func(groups []string, a slog.Attr) slog.Attr, loggerAttr []slog.Attr, groups []string, record *slog.Record) model.LabelSet {
+	return slogloki.RemoveAttrsConverter(addSource, replaceAttr, loggerAttr, groups, record)
 }
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/prometheus/common/model"
 	slogloki "github.com/samber/slog-loki/v3"
@@ -83,50 +84,17 @@ func targetLokiReturnsHandler(t *testing.T) {
 	assert.IsType(t, &slogloki.LokiHandler{}, handler)
 }
 
-func attrsToMetadataReturnsCleanedLabels(t *testing.T) {
-	labels := map[string]string{
-		"flow":      "1234567890",
-		"prot":      "TCP",
-		"src_addr":  "2003:cf:1716:7b64:da80:83ff:fecd:da51",
-		"dst_addr":  "2a01:4f8:160:5372::2",
-		"src_port":  "41756",
-		"dst_port":  "443",
-		"tcp_state": "SYN_SENT",
-	}
-
-	fields := map[string]string{
-		"src_city":    "Garmisch-Partenkirchen",
-		"src_country": "Germany",
-		"src_lat":     "47.4906",
-		"src_lon":     "11.1026",
-		"dst_city":    "Falkenstein",
-		"dst_country": "Germany",
-		"dst_lat":     "50.4777",
-		"dst_lon":     "12.3649",
-	}
+func recordLabelsReturnsLevelOnly(t *testing.T) {
+	record := slog.NewRecord(time.Now(), slog.LevelWarn, "Test log message", 0)
+	record.AddAttrs(
+		slog.Uint64("flow", 1234567890),
+		slog.String("prot", "TCP"),
+		slog.String("src_addr", "2003:cf:1716:7b64:da80:83ff:fecd:da51"),
+		slog.String("dst_city", "Falkenstein"),
+	)
 
-	addSource := false
-	replaceAttr := func(groups []string, a slog.Attr) slog.Attr { return a }
-	loggerAttrs := []slog.Attr{}
-	record := slog.Record{
-		Message: "Test log message",
-	}
-	for k, v := range labels {
-		record.AddAttrs(slog.String(k, v))
-	}
-	for k, v := range fields {
-		record.AddAttrs(slog.String(k, v))
-	}
-
-	recordLabels := attrsToMetadata(addSource, replaceAttr, loggerAttrs, []string{}, &record)
-	assert.NotNil(t, recordLabels)
-	assert.IsType(t, recordLabels, model.LabelSet{})
-	for k, v := range labels {
-		assert.Contains(t, recordLabels.String(), k+"=\""+v+"\"")
-	}
-	for k, v := range fields {
-		assert.NotContains(t, recordLabels.String(), k+"=\""+v+"\"")
-	}
+	labels := recordLabels(false, nil, nil, []string{}, &record)
+	assert.Equal(t, model.LabelSet{"level": "WARN"}, labels)
 }
 
 func TestSinkTargetLoki(t *testing.T) {
@@ -135,5 +103,5 @@ func TestSinkTargetLoki(t *testing.T) {
 	t.Run("loki.TargetLoki returns error if Loki is not ready", targetLokiReturnsErrorIfLokiIsNotReady)
 	t.Run("loki.TargetLoki returns handler if address is reachable and ready", targetLokiReturnsHandler)
 	t.Run("loki.setLabels ignores invalid Loki labels", setLabelsIgnoresInvalidInput)
-	t.Run("loki.attrsToMetadata returns cleaned Loki labels", attrsToMetadataReturnsCleanedLabels)
+	t.Run("loki.recordLabels returns level only", recordLabelsReturnsLevelOnly)
 }
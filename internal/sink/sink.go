@@ -5,10 +5,15 @@ Licensed under the MIT License, see LICENSE file in the project root for details
 package sink
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	slogmulti "github.com/samber/slog-multi"
 )
@@ -20,6 +25,18 @@ const (
 // Sink represents a multi logger sink.
 type Sink struct {
 	Logger *slog.Logger
+
+	handlers map[string]slog.Handler
+	names    []string
+	disabled map[string]*atomic.Bool
+	routes   sync.Map
+	closers  []func() error
+}
+
+// Target is an available sink target and whether it is written to.
+type Target struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
 }
 
 // Config holds the configuration for different logging sinks.
@@ -33,8 +50,54 @@ type Config struct {
 // SinkTarget defines a function type for initializing a sink target.
 type SinkTarget func(*slog.HandlerOptions) (slog.Handler, error)
 
+// Targets returns the names of the enabled sink targets.
+func (c *Config) Targets() []string {
+	var names []string
+	for _, t := range c.targets() {
+		if t.enabled {
+			names = append(names, t.name)
+		}
+	}
+
+	return names
+}
+
+// target is a named sink target. close releases the resources of an
+// initialized target, if any.
+type target struct {
+	name    string
+	enabled bool
+	init    SinkTarget
+	close   func() error
+}
+
+// targets returns the sink targets of the configuration.
+func (c *Config) targets() []target {
+	return []target{
+		{"journal", c.Journal.Enable, c.Journal.TargetJournal, nil},
+		{"syslog", c.Syslog.Enable, c.Syslog.TargetSyslog, c.Syslog.close},
+		{"loki", c.Loki.Enable, c.Loki.TargetLoki, c.Loki.close},
+		{"stream", c.Stream.Enable, c.Stream.TargetStream, nil},
+	}
+}
+
 // NewSink creates a new multi logger sink based on the provided configuration.
+// Targets failing to initialize are skipped with a warning, the process exits
+// instead if ExitOnWarningEnv is set.
 func NewSink(config *Config) (*Sink, error) {
+	return newSink(config, false)
+}
+
+// NewSinkOnReload creates a new sink like NewSink to replace the sink of the
+// running service. If ExitOnWarningEnv is set, a target failing to initialize
+// is returned as error instead of exiting, so the current sink is kept.
+func NewSinkOnReload(config *Config) (*Sink, error) {
+	return newSink(config, true)
+}
+
+// newSink creates the sink, failing instead of exiting on warnings if reload
+// is set.
+func newSink(config *Config, reload bool) (*Sink, error) {
 	options := &slog.HandlerOptions{
 		Level: slog.LevelInfo,
 	}
@@ -46,36 +109,121 @@ func NewSink(config *Config) (*Sink, error) {
 	}
 
 	var handlers []slog.Handler
+	var names []string
+	var closers []func() error
+	named := make(map[string]slog.Handler)
+	disabled := make(map[string]*atomic.Bool)
 
-	targets := []struct {
-		name    string
-		enabled bool
-		init    SinkTarget
-	}{
-		{"journal", config.Journal.Enable, config.Journal.TargetJournal},
-		{"syslog", config.Syslog.Enable, config.Syslog.TargetSyslog},
-		{"loki", config.Loki.Enable, config.Loki.TargetLoki},
-		{"stream", config.Stream.Enable, config.Stream.TargetStream},
-	}
-
-	for _, t := range targets {
+	for _, t := range config.targets() {
 		if !t.enabled {
 			continue
 		}
 		handler, err := t.init(options)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Warning: Failed to initialize sink %q: %v\n", t.name, err)
+			if exitOnWarning && reload {
+				for _, closer := range closers {
+					_ = closer()
+				}
+				return nil, fmt.Errorf("target %q: %w", t.name, err)
+			}
 			if exitOnWarning {
 				os.Exit(1)
 			}
 			continue
 		}
+		disabled[t.name] = &atomic.Bool{}
+		handler = &toggle{Handler: handler, disabled: disabled[t.name]}
 		handlers = append(handlers, handler)
+		names = append(names, t.name)
+		named[t.name] = handler
+		if t.close != nil {
+			closers = append(closers, t.close)
+		}
 	}
 
 	if len(handlers) == 0 {
 		return nil, errors.New("no target sink available")
 	}
 
-	return &Sink{Logger: slog.New(slogmulti.Fanout(handlers...))}, nil
+	return &Sink{
+		Logger:   slog.New(slogmulti.Fanout(handlers...)),
+		handlers: named,
+		names:    names,
+		disabled: disabled,
+		closers:  closers,
+	}, nil
+}
+
+// Close releases the connections of the sink targets, e.g. on reload. The
+// sink must not be written to afterwards.
+func (s *Sink) Close() error {
+	var errs []error
+	for _, closer := range s.closers {
+		errs = append(errs, closer())
+	}
+
+	return errors.Join(errs...)
+}
+
+// Targets returns the available sink targets in configuration order.
+func (s *Sink) Targets() []Target {
+	targets := make([]Target, 0, len(s.names))
+	for _, name := range s.names {
+		targets = append(targets, Target{Name: name, Enabled: !s.disabled[name].Load()})
+	}
+
+	return targets
+}
+
+// SetEnabled enables or disables the named sink target at runtime. Records
+// for a disabled target are dropped.
+func (s *Sink) SetEnabled(name string, enabled bool) error {
+	if !slices.Contains(s.names, name) {
+		return fmt.Errorf("unknown sink %q", name)
+	}
+	s.disabled[name].Store(!enabled)
+
+	return nil
+}
+
+// toggle is a handler discarding all records while disabled.
+type toggle struct {
+	slog.Handler
+	disabled *atomic.Bool
+}
+
+func (t *toggle) Enabled(ctx context.Context, level slog.Level) bool {
+	return !t.disabled.Load() && t.Handler.Enabled(ctx, level)
+}
+
+func (t *toggle) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &toggle{Handler: t.Handler.WithAttrs(attrs), disabled: t.disabled}
+}
+
+func (t *toggle) WithGroup(name string) slog.Handler {
+	return &toggle{Handler: t.Handler.WithGroup(name), disabled: t.disabled}
+}
+
+// Route returns a logger writing to the named sink targets only, the logger
+// of all targets if no names are given. Targets not available are skipped.
+func (s *Sink) Route(names []string) *slog.Logger {
+	if len(names) == 0 {
+		return s.Logger
+	}
+
+	key := strings.Join(names, ",")
+	if logger, ok := s.routes.Load(key); ok {
+		return logger.(*slog.Logger)
+	}
+
+	var handlers []slog.Handler
+	for _, name := range names {
+		if handler, ok := s.handlers[name]; ok {
+			handlers = append(handlers, handler)
+		}
+	}
+
+	logger, _ := s.routes.LoadOrStore(key, slog.New(slogmulti.Fanout(handlers...)))
+	return logger.(*slog.Logger)
 }
@@ -6,12 +6,17 @@ package sink
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"log/slog"
+	"net"
 	"os"
 	"os/exec"
 	"testing"
 
+	slogmulti "github.com/samber/slog-multi"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func capture(f func()) string {
@@ -85,10 +90,103 @@ func newPrintsWarningIfTargetInitFails(t *testing.T) {
 	assert.Contains(t, warning, "Warning: Failed to initialize sink \"loki\"")
 }
 
+func routeWritesToNamedTargetsOnly(t *testing.T) {
+	var journal, loki bytes.Buffer
+	handlers := map[string]slog.Handler{
+		"journal": slog.NewTextHandler(&journal, nil),
+		"loki":    slog.NewTextHandler(&loki, nil),
+	}
+	sink := &Sink{Logger: slog.New(slogmulti.Fanout(handlers["journal"], handlers["loki"])), handlers: handlers}
+
+	assert.Same(t, sink.Logger, sink.Route(nil))
+
+	logger := sink.Route([]string{"loki", "syslog"})
+	assert.Same(t, logger, sink.Route([]string{"loki", "syslog"}))
+	logger.Info("routed")
+	assert.Empty(t, journal.String())
+	assert.Contains(t, loki.String(), "msg=routed")
+}
+
+func targetsReturnsEnabledTargets(t *testing.T) {
+	config := &Config{
+		Syslog: Syslog{Enable: true},
+		Stream: Stream{Enable: true},
+	}
+	assert.Equal(t, []string{"syslog", "stream"}, config.Targets())
+}
+
+func setEnabledTogglesTarget(t *testing.T) {
+	sink, err := NewSink(&Config{
+		Syslog: Syslog{Enable: true, Address: "udp://localhost:514", Format: "json"},
+		Stream: Stream{Enable: true, Writer: "discard"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Target{{"syslog", true}, {"stream", true}}, sink.Targets())
+
+	assert.NoError(t, sink.SetEnabled("stream", false))
+	assert.Equal(t, []Target{{"syslog", true}, {"stream", false}}, sink.Targets())
+	assert.False(t, sink.handlers["stream"].Enabled(context.Background(), slog.LevelInfo))
+	assert.False(t, sink.handlers["stream"].WithAttrs(nil).Enabled(context.Background(), slog.LevelInfo))
+	assert.True(t, sink.handlers["syslog"].Enabled(context.Background(), slog.LevelInfo))
+
+	assert.NoError(t, sink.SetEnabled("stream", true))
+	assert.True(t, sink.handlers["stream"].Enabled(context.Background(), slog.LevelInfo))
+
+	assert.EqualError(t, sink.SetEnabled("loki", true), `unknown sink "loki"`)
+}
+
+func newSinkOnReloadFailsIfTargetInitFailsAndEnvExitOnWarningIsSet(t *testing.T) {
+	config := &Config{
+		Loki:   Loki{Enable: true, Address: "://invalid-address"},
+		Stream: Stream{Enable: true, Writer: "discard"},
+	}
+
+	t.Setenv(ExitOnWarningEnv, "")
+	var sink *Sink
+	var err error
+	_ = capture(func() {
+		sink, err = NewSinkOnReload(config)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Target{{"stream", true}}, sink.Targets())
+
+	t.Setenv(ExitOnWarningEnv, "1")
+	_ = capture(func() {
+		sink, err = NewSinkOnReload(config)
+	})
+	assert.Nil(t, sink)
+	assert.EqualError(t, err, `target "loki": parse "://invalid-address": missing protocol scheme`)
+}
+
+func closeClosesTargetConnections(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		_ = server.Close()
+	}()
+
+	config := &Config{
+		Syslog: Syslog{Enable: true, Address: "udp://" + server.LocalAddr().String()},
+		Stream: Stream{Enable: true, Writer: "discard"},
+	}
+	sink, err := NewSink(config)
+	require.NoError(t, err)
+	require.NotNil(t, config.Syslog.conn)
+
+	assert.NoError(t, sink.Close())
+	_, err = config.Syslog.conn.Write([]byte("record"))
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
+
 func TestSink(t *testing.T) {
 	t.Run("sink.NewSink returns error if no targets are enabled", newReturnsErrorIfNoTargetsAreEnabled)
 	t.Run("sink.NewSink returns sink if targets enabled", newReturnsSinkIfTargetsEnabled)
 	t.Run("sink.NewSink prints warning if target init fails", newPrintsWarningIfTargetInitFails)
+	t.Run("sink.NewSinkOnReload fails if target init fails and exit on warning is set", newSinkOnReloadFailsIfTargetInitFailsAndEnvExitOnWarningIsSet)
+	t.Run("sink.Close closes target connections", closeClosesTargetConnections)
+	t.Run("sink.Route writes to named targets only", routeWritesToNamedTargetsOnly)
+	t.Run("sink.Config.Targets returns enabled targets", targetsReturnsEnabledTargets)
+	t.Run("sink.SetEnabled toggles target", setEnabledTogglesTarget)
 }
 
 func Test_NewExitsIfTargetInitFailsAndEnvExitOnWarningIsSet(t *testing.T) {
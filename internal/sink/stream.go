@@ -15,6 +15,7 @@ import (
 type Stream struct {
 	Enable bool
 	Writer string
+	Format string
 }
 
 // Available stream writers
@@ -28,9 +29,19 @@ func (s *Stream) TargetStream(options *slog.HandlerOptions) (slog.Handler, error
 		"discard": io.Discard,
 	}
 
-	if w, ok := writer[s.Writer]; ok {
-		return slog.NewJSONHandler(w, options), nil
+	w, ok := writer[s.Writer]
+	if !ok {
+		return nil, fmt.Errorf("invalid stream writer specified: %q", s.Writer)
 	}
 
-	return nil, fmt.Errorf("invalid stream writer specified: %q", s.Writer)
+	switch s.Format {
+	case "", "json":
+		return slog.NewJSONHandler(w, options), nil
+	default:
+		handler, err := newLineHandler(s.Format, w, options, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stream format specified: %q", s.Format)
+		}
+		return handler, nil
+	}
 }
@@ -35,7 +35,39 @@ func targetStreamReturnsErrorIfWriterIsInvalid(t *testing.T) {
 	assert.EqualError(t, err, "invalid stream writer specified: \"invalid-writer\"")
 }
 
+func targetStreamReturnsHandlerIfFormatIsValid(t *testing.T) {
+	for _, format := range Formats {
+		stream := &Stream{
+			Enable: true,
+			Writer: "discard",
+			Format: format,
+		}
+		handler, err := stream.TargetStream(&slog.HandlerOptions{})
+		assert.Nil(t, err)
+		assert.NotNil(t, handler)
+		if format == "json" {
+			assert.IsType(t, &slog.JSONHandler{}, handler)
+		} else {
+			assert.IsType(t, &lineHandler{}, handler)
+		}
+	}
+}
+
+func targetStreamReturnsErrorIfFormatIsInvalid(t *testing.T) {
+	stream := &Stream{
+		Enable: true,
+		Writer: "discard",
+		Format: "invalid-format",
+	}
+	handler, err := stream.TargetStream(&slog.HandlerOptions{})
+	assert.NotNil(t, err)
+	assert.Nil(t, handler)
+	assert.EqualError(t, err, "invalid stream format specified: \"invalid-format\"")
+}
+
 func TestSinkTargetStream(t *testing.T) {
 	t.Run("stream.TargetStream returns handler if writer is valid", targetStreamReturnsHandleIfWriterIsValid)
 	t.Run("stream.TargetStream returns error if writer is invalid", targetStreamReturnsErrorIfWriterIsInvalid)
+	t.Run("stream.TargetStream returns handler if format is valid", targetStreamReturnsHandlerIfFormatIsValid)
+	t.Run("stream.TargetStream returns error if format is invalid", targetStreamReturnsErrorIfFormatIsInvalid)
 }
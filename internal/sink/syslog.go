@@ -5,11 +5,15 @@ Licensed under the MIT License, see LICENSE file in the project root for details
 package sink
 
 import (
+	"fmt"
 	"log/slog"
 	"net"
 	"net/url"
+	"os"
 	"strings"
+	"time"
 
+	slogcommon "github.com/samber/slog-common"
 	slogsyslog "github.com/samber/slog-syslog/v2"
 )
 
@@ -17,6 +21,9 @@ import (
 type Syslog struct {
 	Enable  bool
 	Address string
+	Format  string
+
+	conn net.Conn
 }
 
 // SyslogProtocols lists the supported syslog protocols.
@@ -35,15 +42,82 @@ func (s *Syslog) TargetSyslog(options *slog.HandlerOptions) (slog.Handler, error
 		address = url.Path
 	}
 
+	if s.Format != "" && s.Format != "json" {
+		if _, ok := encoders[s.Format]; !ok {
+			return nil, fmt.Errorf("invalid syslog format specified: %q", s.Format)
+		}
+	}
+
 	writer, err := net.Dial(network, address)
 	if err != nil {
 		return nil, err
 	}
+	s.conn = writer
+
+	if s.Format != "" && s.Format != "json" {
+		return newLineHandler(s.Format, writer, options, syslogFrame())
+	}
 
-	slogsyslog.ContextKey = "event"
 	o := &slogsyslog.Option{
-		Writer: writer,
-		Level:  options.Level,
+		Writer:    writer,
+		Level:     options.Level,
+		Converter: syslogConverter,
 	}
 	return o.NewSyslogHandler(), nil
 }
+
+// close closes the connection to the syslog server.
+func (s *Syslog) close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// syslogConverter converts a record into a JSON object with the record fields
+// at top level, like the JSON format of the stream sink.
+func syslogConverter(_ bool, _ func([]string, slog.Attr) slog.Attr, loggerAttr []slog.Attr, groups []string, record *slog.Record) map[string]any {
+	attrs := slogcommon.AppendRecordAttrsToAttrs(loggerAttr, groups, record)
+
+	log := slogcommon.AttrsToMap(attrs...)
+	log["time"] = record.Time.UTC()
+	log["level"] = record.Level.String()
+	log["msg"] = record.Message
+
+	return log
+}
+
+// syslogFrame returns a framer prefixing lines with a RFC 5424 header.
+func syslogFrame() framer {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	pid := os.Getpid()
+
+	return func(record slog.Record, line string) string {
+		return fmt.Sprintf("<%d>1 %s %s conntrackd %d - - %s",
+			syslogPriority(record.Level),
+			record.Time.UTC().Format(time.RFC3339Nano),
+			hostname, pid, line,
+		)
+	}
+}
+
+// syslogPriority returns the syslog priority for the user facility and the
+// severity matching the given log level.
+func syslogPriority(level slog.Level) int {
+	const facilityUser = 1
+
+	severity := 6
+	switch {
+	case level >= slog.LevelError:
+		severity = 3
+	case level >= slog.LevelWarn:
+		severity = 4
+	case level < slog.LevelInfo:
+		severity = 7
+	}
+
+	return facilityUser*8 + severity
+}
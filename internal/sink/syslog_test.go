@@ -7,6 +7,7 @@ package sink
 import (
 	"log/slog"
 	"testing"
+	"time"
 
 	slogsyslog "github.com/samber/slog-syslog/v2"
 	"github.com/stretchr/testify/assert"
@@ -54,7 +55,55 @@ func targetSyslogReturnsErrorIfAddressIsInvalid(t *testing.T) {
 	}
 }
 
+func targetSyslogReturnsLineHandlerIfFormatIsNotJSON(t *testing.T) {
+	syslog := &Syslog{
+		Enable:  true,
+		Address: "udp://localhost:514",
+		Format:  "cef",
+	}
+	handler, err := syslog.TargetSyslog(&slog.HandlerOptions{})
+	assert.Nil(t, err)
+	assert.NotNil(t, handler)
+	assert.IsType(t, &lineHandler{}, handler)
+}
+
+func targetSyslogReturnsErrorIfFormatIsInvalid(t *testing.T) {
+	syslog := &Syslog{
+		Enable:  true,
+		Address: "udp://localhost:514",
+		Format:  "invalid-format",
+	}
+	handler, err := syslog.TargetSyslog(&slog.HandlerOptions{})
+	assert.Nil(t, handler)
+	assert.EqualError(t, err, "invalid syslog format specified: \"invalid-format\"")
+}
+
+func syslogFramePrefixesHeader(t *testing.T) {
+	record := slog.NewRecord(time.Date(2025, 11, 25, 12, 35, 11, 0, time.UTC), slog.LevelWarn, "message", 0)
+	line := syslogFrame()(record, "CEF:0|...")
+	assert.Regexp(t, `^<12>1 2025-11-25T12:35:11Z \S+ conntrackd \d+ - - CEF:0\|\.\.\.$`, line)
+}
+
+func syslogConverterReturnsFlatRecord(t *testing.T) {
+	now := time.Date(2025, 11, 25, 12, 35, 11, 0, time.UTC)
+	record := slog.NewRecord(now, slog.LevelInfo, "NEW TCP connection", 0)
+	record.AddAttrs(slog.Int("schema_version", 2), slog.String("src_addr", "10.19.80.100"))
+
+	log := syslogConverter(false, nil, nil, nil, &record)
+	assert.Equal(t, map[string]any{
+		"time":           now,
+		"level":          "INFO",
+		"msg":            "NEW TCP connection",
+		"schema_version": int64(2),
+		"src_addr":       "10.19.80.100",
+	}, log)
+}
+
 func TestSinkTargetSyslog(t *testing.T) {
 	t.Run("syslog.TargetSyslog returns handler if address is valid", targetSyslogReturnsHandlerIfAddressIsValid)
 	t.Run("syslog.TargetSyslog returns error if address is invalid", targetSyslogReturnsErrorIfAddressIsInvalid)
+	t.Run("syslog.TargetSyslog returns line handler if format is not JSON", targetSyslogReturnsLineHandlerIfFormatIsNotJSON)
+	t.Run("syslog.TargetSyslog returns error if format is invalid", targetSyslogReturnsErrorIfFormatIsInvalid)
+	t.Run("syslog.syslogFrame prefixes RFC 5424 header", syslogFramePrefixesHeader)
+	t.Run("syslog.syslogConverter returns flat record", syslogConverterReturnsFlatRecord)
 }
@@ -0,0 +1,54 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package tail
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats are the output formats of records.
+var Formats = []string{"json", "text"}
+
+// Write writes the record as JSON line like the stream sink or as text line:
+// time, type, protocol, addresses, TCP state, destination country, tags and
+// the dropping rule.
+func Write(w io.Writer, format string, r *Record) error {
+	switch format {
+	case "json":
+		return json.NewEncoder(w).Encode(r)
+	case "text":
+		fields := []string{
+			r.Time.Local().Format(time.TimeOnly + ".000"),
+			fmt.Sprintf("%-7s", r.Type),
+			r.Protocol,
+			net.JoinHostPort(r.SrcAddr, strconv.Itoa(int(r.SrcPort))),
+			"->",
+			net.JoinHostPort(r.DstAddr, strconv.Itoa(int(r.DstPort))),
+		}
+		if r.TCPState != "" {
+			fields = append(fields, r.TCPState)
+		}
+		if r.DstCountry != "" {
+			fields = append(fields, r.DstCountry)
+		}
+		if len(r.Tags) > 0 {
+			fields = append(fields, "tags="+strings.Join(r.Tags, ","))
+		}
+		if r.DroppedBy != "" {
+			fields = append(fields, "dropped by "+r.DroppedBy)
+		}
+
+		_, err := fmt.Fprintln(w, strings.Join(fields, " "))
+		return err
+	default:
+		return fmt.Errorf("invalid output format %q, expected one of %s", format, strings.Join(Formats, ", "))
+	}
+}
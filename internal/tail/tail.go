@@ -0,0 +1,166 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package tail
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ti-mo/conntrack"
+	"github.com/tschaefer/conntrackd/internal/filter"
+	"github.com/tschaefer/conntrackd/internal/geoip"
+	"github.com/tschaefer/conntrackd/internal/record"
+)
+
+// Buffer is the number of records buffered per subscriber. A subscriber
+// falling further behind is disconnected.
+const Buffer = 256
+
+// DefaultAction is the dropping rule of events dropped by the default action
+// of the filter.
+const DefaultAction = "filter.default"
+
+// ErrSlowSubscriber ends the subscription of a subscriber not keeping up with
+// the records.
+var ErrSlowSubscriber = errors.New("subscriber too slow, disconnected")
+
+// Record is a record delivered to subscribers. DroppedBy is the rule that
+// dropped the event, empty for recorded events.
+type Record struct {
+	Time time.Time `json:"time"`
+	*record.Event
+	DroppedBy string `json:"dropped_by,omitempty"`
+}
+
+// Subscription delivers the records passing its filter until it ends.
+type Subscription struct {
+	records chan *Record
+	filter  *filter.Filter
+	dropped bool
+	err     error
+}
+
+// Records returns the channel of records. It is closed when the subscription
+// ends, Err returns the reason then.
+func (s *Subscription) Records() <-chan *Record {
+	return s.records
+}
+
+// Err returns why the subscription ended, nil if unsubscribed. It is valid
+// after the channel of records is closed.
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+// Hub delivers records to subscribers. The zero value is a hub without
+// subscribers.
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+	count       atomic.Int32
+	dropped     atomic.Int32
+}
+
+// Subscribe subscribes to the records of events matching the CEL expression,
+// all records if empty. The expression may look up the given sets. Events
+// dropped by the filter of the service are included if dropped is set.
+func (h *Hub) Subscribe(expr string, dropped bool, sets map[string]*filter.Set) (*Subscription, error) {
+	sub := &Subscription{records: make(chan *Record, Buffer), dropped: dropped}
+
+	if expr = strings.TrimSpace(expr); expr != "" {
+		f, err := filter.NewFilterFromRules(
+			[]filter.Rule{{Name: "tail", Action: "drop", Expr: "!(" + expr + ")", Source: "tail"}},
+			filter.Options{Sets: sets},
+		)
+		if err != nil {
+			return nil, err
+		}
+		sub.filter = f
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subscribers == nil {
+		h.subscribers = make(map[*Subscription]struct{})
+	}
+	h.subscribers[sub] = struct{}{}
+	h.count.Add(1)
+	if dropped {
+		h.dropped.Add(1)
+	}
+
+	return sub, nil
+}
+
+// Unsubscribe ends the subscription.
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.end(sub, nil)
+}
+
+// end removes the subscriber and closes its channel of records. The caller
+// must hold the lock.
+func (h *Hub) end(sub *Subscription, err error) {
+	if _, ok := h.subscribers[sub]; !ok {
+		return
+	}
+
+	delete(h.subscribers, sub)
+	h.count.Add(-1)
+	if sub.dropped {
+		h.dropped.Add(-1)
+	}
+	sub.err = err
+	close(sub.records)
+}
+
+// Active reports whether there are subscribers.
+func (h *Hub) Active() bool {
+	return h.count.Load() > 0
+}
+
+// Dropped reports whether there are subscribers of dropped events.
+func (h *Hub) Dropped() bool {
+	return h.dropped.Load() > 0
+}
+
+// Publish delivers the record of the event to the subscribers whose filter
+// the event passes. The record is created by newRecord once if delivered.
+// Events dropped by the filter of the service, given by the dropping rule,
+// are delivered to subscribers of dropped events only. Subscribers with a
+// full buffer are disconnected.
+func (h *Hub) Publish(event conntrack.Event, geo *geoip.Lookup, newRecord func() *record.Event, droppedBy string) {
+	if !h.Active() {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var r *Record
+	for sub := range h.subscribers {
+		if droppedBy != "" && !sub.dropped {
+			continue
+		}
+		if sub.filter != nil && !sub.filter.Decide(event, geo).Log {
+			continue
+		}
+
+		if r == nil {
+			r = &Record{Time: time.Now(), Event: newRecord(), DroppedBy: droppedBy}
+		}
+		select {
+		case sub.records <- r:
+		default:
+			h.end(sub, ErrSlowSubscriber)
+		}
+	}
+}
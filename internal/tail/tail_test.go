@@ -0,0 +1,136 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package tail
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/netip"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ti-mo/conntrack"
+	"github.com/tschaefer/conntrackd/internal/filter"
+	"github.com/tschaefer/conntrackd/internal/record"
+)
+
+func __createEvent(dport uint16) conntrack.Event {
+	flow := conntrack.NewFlow(syscall.IPPROTO_TCP, 0, netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2"), 4711, dport, 60, 0)
+	return conntrack.Event{Type: conntrack.EventNew, Flow: &flow}
+}
+
+func __publish(hub *Hub, event conntrack.Event, droppedBy string) {
+	hub.Publish(event, nil, func() *record.Event { return record.NewEvent(event, nil) }, droppedBy)
+}
+
+func publishDeliversMatchingRecords(t *testing.T) {
+	var hub Hub
+	assert.False(t, hub.Active())
+
+	all, err := hub.Subscribe("", false, nil)
+	require.NoError(t, err)
+	https, err := hub.Subscribe("destination.port == 443", true, nil)
+	require.NoError(t, err)
+	assert.True(t, hub.Active())
+
+	__publish(&hub, __createEvent(443), "")
+	__publish(&hub, __createEvent(22), "")
+	__publish(&hub, __createEvent(443), "ssh")
+
+	assert.Len(t, all.Records(), 2)
+	require.Len(t, https.Records(), 2)
+	assert.Same(t, <-all.Records(), <-https.Records(), "record created once")
+	assert.Equal(t, "ssh", (<-https.Records()).DroppedBy)
+
+	hub.Unsubscribe(all)
+	hub.Unsubscribe(all)
+	for range all.Records() {
+	}
+	assert.NoError(t, all.Err())
+	assert.True(t, hub.Active())
+}
+
+func publishDisconnectsSlowSubscriber(t *testing.T) {
+	var hub Hub
+	sub, err := hub.Subscribe("", false, nil)
+	require.NoError(t, err)
+
+	for range Buffer + 1 {
+		__publish(&hub, __createEvent(443), "")
+	}
+
+	assert.False(t, hub.Active())
+	n := 0
+	for range sub.Records() {
+		n++
+	}
+	assert.Equal(t, Buffer, n)
+	assert.ErrorIs(t, sub.Err(), ErrSlowSubscriber)
+}
+
+func unsubscribeEndsDroppedSubscription(t *testing.T) {
+	var hub Hub
+	recorded, err := hub.Subscribe("", false, nil)
+	require.NoError(t, err)
+	assert.False(t, hub.Dropped())
+
+	dropped, err := hub.Subscribe("", true, nil)
+	require.NoError(t, err)
+	assert.True(t, hub.Dropped())
+
+	hub.Unsubscribe(dropped)
+	hub.Unsubscribe(dropped)
+	assert.False(t, hub.Dropped())
+	assert.True(t, hub.Active())
+
+	hub.Unsubscribe(recorded)
+	assert.False(t, hub.Active())
+}
+
+func subscribeFailsOnInvalidExpression(t *testing.T) {
+	var hub Hub
+	_, err := hub.Subscribe("destination.port ==", false, nil)
+	assert.Error(t, err)
+
+	set, err := filter.NewSet("web", []string{"10.0.0.2"})
+	require.NoError(t, err)
+	_, err = hub.Subscribe(`in_set(destination.ip, "web")`, false, map[string]*filter.Set{"web": set})
+	assert.NoError(t, err)
+}
+
+func writeFormatsRecord(t *testing.T) {
+	r := &Record{
+		Time:      time.Date(2026, 1, 2, 3, 4, 5, 0, time.Local),
+		Event:     record.NewEvent(__createEvent(22), nil),
+		DroppedBy: "ssh",
+	}
+	r.Tags = []string{"admin"}
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, "text", r))
+	assert.Equal(t, "03:04:05.000 NEW     TCP 10.0.0.1:4711 -> 10.0.0.2:22 tags=admin dropped by ssh\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, Write(&buf, "json", r))
+	var fields map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &fields))
+	assert.Equal(t, "10.0.0.2", fields["dst_addr"])
+	assert.Equal(t, "ssh", fields["dropped_by"])
+	assert.True(t, strings.HasPrefix(buf.String(), `{"time":`))
+
+	assert.Error(t, Write(&buf, "csv", r))
+}
+
+func TestTail(t *testing.T) {
+	t.Run("tail.Publish delivers matching records", publishDeliversMatchingRecords)
+	t.Run("tail.Publish disconnects slow subscriber", publishDisconnectsSlowSubscriber)
+	t.Run("tail.Unsubscribe ends dropped subscription", unsubscribeEndsDroppedSubscription)
+	t.Run("tail.Subscribe fails on invalid expression", subscribeFailsOnInvalidExpression)
+	t.Run("tail.Write formats record", writeFormatsRecord)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package top
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// keyTitles are the titles of the key column of the views.
+var keyTitles = []string{"SOURCE", "DESTINATION", "PORT", "COUNTRY"}
+
+// help is shown in the status line without status message.
+const help = "1-4/tab view  </> sort  / filter  q quit"
+
+// columnWidth is the width of the number columns.
+const columnWidth = 10
+
+const (
+	reverse = "\x1b[7m"
+	reset   = "\x1b[0m"
+)
+
+// Render returns the lines of the screen for a terminal of the given size:
+// rates, the interactive filter, the views, the rows of the current view and
+// a status line. The current view and the sort column are highlighted.
+func (t *Top) Render(width, height int, now time.Time) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := []string{
+		fit(fmt.Sprintf("conntrackd top - %s  events/s %.1f  new/s %.1f  flows %d",
+			now.Format(time.TimeOnly), t.eventRate, t.rate, t.flows), width),
+	}
+
+	switch {
+	case t.input != nil:
+		lines = append(lines, fit("filter: "+*t.input+"_", width))
+	case t.expr != "":
+		lines = append(lines, fit("filter: "+t.expr, width))
+	default:
+		lines = append(lines, "filter: none")
+	}
+
+	var views strings.Builder
+	for i, view := range Views {
+		label := fmt.Sprintf(" %d %s ", i+1, view)
+		if i == t.view {
+			label = reverse + label + reset
+		}
+		views.WriteString(label)
+	}
+	lines = append(lines, views.String(), "")
+
+	keyWidth := max(width-len(Columns)*(columnWidth+1), 15)
+	header := fmt.Sprintf("%-*s", keyWidth, keyTitles[t.view])
+	for i, column := range Columns {
+		title := fmt.Sprintf(" %*s", columnWidth, column)
+		if i == t.column {
+			title = " " + reverse + title[1:] + reset
+		}
+		header += title
+	}
+	lines = append(lines, header)
+
+	status := help
+	if t.status != "" {
+		status = t.status
+	}
+
+	rows := t.rows()
+	for _, row := range rows[:min(len(rows), max(height-len(lines)-1, 0))] {
+		lines = append(lines, fit(fmt.Sprintf("%-*s %*.1f %*d %*d %*s",
+			keyWidth, fit(row.Key, keyWidth),
+			columnWidth, row.Rate,
+			columnWidth, row.Flows,
+			columnWidth, row.Packets,
+			columnWidth, formatBytes(row.Bytes),
+		), width))
+	}
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+
+	return append(lines, fit(status, width))
+}
+
+// fit truncates the line to the width.
+func fit(line string, width int) string {
+	runes := []rune(line)
+	if width <= 0 || len(runes) <= width {
+		return line
+	}
+	return string(runes[:width])
+}
+
+// formatBytes formats bytes with binary unit prefixes.
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
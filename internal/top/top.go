@@ -0,0 +1,373 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package top
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ti-mo/conntrack"
+	"github.com/tschaefer/conntrackd/internal/filter"
+	"github.com/tschaefer/conntrackd/internal/geoip"
+)
+
+// Views are the views of the busiest keys: source address, destination
+// address, destination port and destination country.
+var Views = []string{"sources", "destinations", "ports", "countries"}
+
+// Columns are the sortable columns, all sorted descending.
+var Columns = []string{"NEW/S", "FLOWS", "PACKETS", "BYTES"}
+
+// unknown is the key of flows without value, e.g. without country.
+const unknown = "-"
+
+// Config is the configuration of the view.
+type Config struct {
+	// Filter decides the counted events and flows, nil counts all.
+	Filter *filter.Filter
+	// GeoIP is the database for the countries view and GeoIP variables.
+	GeoIP *geoip.GeoIP
+	// Sets are the named sets available to the interactive filter.
+	Sets map[string]*filter.Set
+}
+
+// stat are the numbers of a key.
+type stat struct {
+	new     uint64
+	rate    float64
+	flows   int
+	packets uint64
+	bytes   uint64
+}
+
+// Row is a line of the current view.
+type Row struct {
+	Key     string
+	Rate    float64
+	Flows   int
+	Packets uint64
+	Bytes   uint64
+}
+
+// Top counts new connections from events and active flows from table dumps
+// by key. New connections per second are computed on each tick.
+type Top struct {
+	config Config
+
+	mu     sync.Mutex
+	filter *filter.Filter
+	expr   string
+	stats  [4]map[string]*stat
+
+	events, new     uint64
+	eventRate, rate float64
+	flows           int
+	ticked          time.Time
+	view, column    int
+	input           *string
+	status          string
+}
+
+// New returns an empty view.
+func New(config Config) *Top {
+	t := &Top{config: config, ticked: time.Now()}
+	for i := range t.stats {
+		t.stats[i] = make(map[string]*stat)
+	}
+
+	return t
+}
+
+// SetFilter applies the CEL expression as interactive filter, only events
+// and flows matching it are counted. An empty expression removes the filter.
+// Counters are reset.
+func (t *Top) SetFilter(expr string) error {
+	f, err := t.compile(expr)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.apply(f, expr)
+	return nil
+}
+
+// compile compiles the expression of the interactive filter, nil if empty.
+func (t *Top) compile(expr string) (*filter.Filter, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, nil
+	}
+
+	return filter.NewFilterFromRules(
+		[]filter.Rule{{Name: "top", Action: "drop", Expr: "!(" + expr + ")", Source: "top"}},
+		filter.Options{Sets: t.config.Sets},
+	)
+}
+
+// apply applies the interactive filter and resets the counters.
+func (t *Top) apply(f *filter.Filter, expr string) {
+	t.filter, t.expr = f, strings.TrimSpace(expr)
+	for i := range t.stats {
+		clear(t.stats[i])
+	}
+	t.events, t.new, t.flows = 0, 0, 0
+}
+
+// SetStatus sets the message shown in the status line.
+func (t *Top) SetStatus(status string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status = status
+}
+
+// keys returns the key of the flow for each view.
+func keys(flow *conntrack.Flow, geo *geoip.Lookup) [4]string {
+	country := unknown
+	if loc := geo.Location(flow.TupleOrig.IP.DestinationAddress); loc != nil && loc.Country != "" {
+		country = loc.Country
+	}
+
+	protocol := "udp"
+	if flow.TupleOrig.Proto.Protocol == syscall.IPPROTO_TCP {
+		protocol = "tcp"
+	}
+
+	return [4]string{
+		flow.TupleOrig.IP.SourceAddress.String(),
+		flow.TupleOrig.IP.DestinationAddress.String(),
+		strconv.Itoa(int(flow.TupleOrig.Proto.DestinationPort)) + "/" + protocol,
+		country,
+	}
+}
+
+// counts reports whether the event passes the configured and the interactive
+// filter. Only TCP and UDP events are counted.
+func (t *Top) counts(event conntrack.Event, geo *geoip.Lookup) bool {
+	protocol := event.Flow.TupleOrig.Proto.Protocol
+	if protocol != syscall.IPPROTO_TCP && protocol != syscall.IPPROTO_UDP {
+		return false
+	}
+
+	if t.config.Filter != nil && !t.config.Filter.Decide(event, geo).Log {
+		return false
+	}
+	return t.filter == nil || t.filter.Decide(event, geo).Log
+}
+
+// Event counts the event, NEW events by key.
+func (t *Top) Event(event conntrack.Event) {
+	geo := t.config.GeoIP.Lookup()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.counts(event, geo) {
+		return
+	}
+
+	t.events++
+	if event.Type != conntrack.EventNew {
+		return
+	}
+
+	t.new++
+	for i, key := range keys(event.Flow, geo) {
+		s, ok := t.stats[i][key]
+		if !ok {
+			s = &stat{}
+			t.stats[i][key] = s
+		}
+		s.new++
+	}
+}
+
+// Snapshot replaces the active flows, packets and bytes by the flows of a
+// table dump.
+func (t *Top) Snapshot(flows []conntrack.Flow) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := range t.stats {
+		for _, s := range t.stats[i] {
+			s.flows, s.packets, s.bytes = 0, 0, 0
+		}
+	}
+	t.flows = 0
+
+	for i := range flows {
+		event := conntrack.Event{Type: conntrack.EventUpdate, Flow: &flows[i]}
+		geo := t.config.GeoIP.Lookup()
+		if !t.counts(event, geo) {
+			continue
+		}
+
+		t.flows++
+		packets := flows[i].CountersOrig.Packets + flows[i].CountersReply.Packets
+		bytes := flows[i].CountersOrig.Bytes + flows[i].CountersReply.Bytes
+		for v, key := range keys(&flows[i], geo) {
+			s, ok := t.stats[v][key]
+			if !ok {
+				s = &stat{}
+				t.stats[v][key] = s
+			}
+			s.flows++
+			s.packets += packets
+			s.bytes += bytes
+		}
+	}
+}
+
+// Tick computes the rates since the last tick. Keys without new connections
+// and active flows are removed.
+func (t *Top) Tick(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	elapsed := now.Sub(t.ticked).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	t.ticked = now
+
+	t.eventRate, t.rate = float64(t.events)/elapsed, float64(t.new)/elapsed
+	t.events, t.new = 0, 0
+
+	for i := range t.stats {
+		for key, s := range t.stats[i] {
+			s.rate, s.new = float64(s.new)/elapsed, 0
+			if s.rate == 0 && s.flows == 0 {
+				delete(t.stats[i], key)
+			}
+		}
+	}
+}
+
+// Rows returns the rows of the current view sorted by the current column.
+func (t *Top) Rows() []Row {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.rows()
+}
+
+func (t *Top) rows() []Row {
+	rows := make([]Row, 0, len(t.stats[t.view]))
+	for key, s := range t.stats[t.view] {
+		rows = append(rows, Row{Key: key, Rate: s.rate, Flows: s.flows, Packets: s.packets, Bytes: s.bytes})
+	}
+
+	slices.SortFunc(rows, func(a, b Row) int {
+		var c int
+		switch t.column {
+		case 0:
+			c = cmp.Compare(b.Rate, a.Rate)
+		case 1:
+			c = cmp.Compare(b.Flows, a.Flows)
+		case 2:
+			c = cmp.Compare(b.Packets, a.Packets)
+		case 3:
+			c = cmp.Compare(b.Bytes, a.Bytes)
+		}
+		return cmp.Or(c, strings.Compare(a.Key, b.Key))
+	})
+
+	return rows
+}
+
+// Input handles keys typed in the terminal. Views are selected by 1 to 4 or
+// tab, sort columns by the left and right arrow keys, / edits the
+// interactive filter and q quits. Returns false to quit.
+func (t *Top) Input(data []byte) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, key := range splitKeys(data) {
+		if t.input != nil {
+			t.edit(key)
+			continue
+		}
+
+		switch {
+		case key == "q" || key == "Q" || key == "\x03":
+			return false
+		case len(key) == 1 && key[0] >= '1' && key[0] < '1'+byte(len(Views)):
+			t.view = int(key[0] - '1')
+		case key == "\t":
+			t.view = (t.view + 1) % len(Views)
+		case key == "\x1b[Z":
+			t.view = (t.view + len(Views) - 1) % len(Views)
+		case key == "\x1b[C" || key == ">":
+			t.column = (t.column + 1) % len(Columns)
+		case key == "\x1b[D" || key == "<":
+			t.column = (t.column + len(Columns) - 1) % len(Columns)
+		case key == "/":
+			input := t.expr
+			t.input = &input
+		}
+	}
+
+	return true
+}
+
+// splitKeys splits terminal input into keys: escape sequences of cursor and
+// function keys, single characters otherwise.
+func splitKeys(data []byte) []string {
+	var keys []string
+	for len(data) > 0 {
+		n := 1
+		if data[0] == 0x1b && len(data) > 2 && (data[1] == '[' || data[1] == 'O') {
+			n = 2
+			for n < len(data) && (data[n] < 0x40 || data[n] > 0x7e) {
+				n++
+			}
+			n = min(n+1, len(data))
+		} else if _, size := utf8.DecodeRune(data); size > 1 {
+			n = size
+		}
+
+		keys = append(keys, string(data[:n]))
+		data = data[n:]
+	}
+
+	return keys
+}
+
+// edit edits the interactive filter by a key. Enter applies it, escape
+// cancels and ctrl-u clears the input.
+func (t *Top) edit(s string) {
+	switch {
+	case s == "\r" || s == "\n":
+		f, err := t.compile(*t.input)
+		if err != nil {
+			t.status = fmt.Sprintf("Invalid filter: %v", err)
+			return
+		}
+		t.apply(f, *t.input)
+		t.input, t.status = nil, ""
+	case s == "\x1b" || s == "\x03":
+		t.input, t.status = nil, ""
+	case s == "\x7f" || s == "\b":
+		runes := []rune(*t.input)
+		if len(runes) > 0 {
+			*t.input = string(runes[:len(runes)-1])
+		}
+	case s == "\x15":
+		*t.input = ""
+	case s != "" && strings.IndexFunc(s, unicode.IsControl) < 0:
+		*t.input += s
+	}
+}
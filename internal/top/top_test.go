@@ -0,0 +1,193 @@
+/*
+Copyright (c) Tobias Schäfer. All rights reserved.
+Licensed under the MIT License, see LICENSE file in the project root for details.
+*/
+package top
+
+import (
+	"net/netip"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ti-mo/conntrack"
+	"github.com/tschaefer/conntrackd/internal/filter"
+)
+
+func __createFlow(proto uint8, src, dst string, dport uint16) conntrack.Flow {
+	flow := conntrack.NewFlow(proto, 0, netip.MustParseAddr(src), netip.MustParseAddr(dst), 4711, dport, 60, 0)
+	flow.CountersOrig = conntrack.Counter{Packets: 2, Bytes: 2048}
+	return flow
+}
+
+func __createEvent(proto uint8, src, dst string, dport uint16) conntrack.Event {
+	flow := __createFlow(proto, src, dst, dport)
+	return conntrack.Event{Type: conntrack.EventNew, Flow: &flow}
+}
+
+// __createTop returns a view of 3 NEW events to 10.0.0.2:443, 1 to
+// 10.0.0.3:53 and an UPDATE event, ticked after 2 seconds
+func __createTop(t *testing.T, config Config) (*Top, time.Time) {
+	top := New(config)
+	for range 3 {
+		top.Event(__createEvent(syscall.IPPROTO_TCP, "10.0.0.1", "10.0.0.2", 443))
+	}
+	top.Event(__createEvent(syscall.IPPROTO_UDP, "10.0.0.1", "10.0.0.3", 53))
+	top.Event(__createEvent(syscall.IPPROTO_ICMP, "10.0.0.1", "10.0.0.4", 0))
+
+	update := __createEvent(syscall.IPPROTO_TCP, "10.0.0.1", "10.0.0.2", 443)
+	update.Type = conntrack.EventUpdate
+	top.Event(update)
+
+	now := top.ticked.Add(2 * time.Second)
+	top.Tick(now)
+	return top, now
+}
+
+func tickComputesRates(t *testing.T) {
+	top, now := __createTop(t, Config{})
+	assert.Equal(t, 2.5, top.eventRate)
+	assert.Equal(t, 2.0, top.rate)
+
+	assert.Equal(t, []Row{{Key: "10.0.0.1", Rate: 2}}, top.Rows())
+
+	top.Input([]byte("2"))
+	assert.Equal(t, []Row{{Key: "10.0.0.2", Rate: 1.5}, {Key: "10.0.0.3", Rate: 0.5}}, top.Rows())
+
+	top.Input([]byte("3"))
+	assert.Equal(t, "443/tcp", top.Rows()[0].Key)
+	top.Input([]byte("4"))
+	assert.Equal(t, []Row{{Key: "-", Rate: 2}}, top.Rows())
+
+	top.Tick(now.Add(time.Second))
+	assert.Empty(t, top.Rows())
+}
+
+func snapshotCountsFlows(t *testing.T) {
+	f, err := filter.NewFilter([]string{"drop destination.port == 22"})
+	require.NoError(t, err)
+
+	top := New(Config{Filter: f})
+	top.Snapshot([]conntrack.Flow{
+		__createFlow(syscall.IPPROTO_TCP, "10.0.0.1", "10.0.0.2", 443),
+		__createFlow(syscall.IPPROTO_TCP, "10.0.0.5", "10.0.0.2", 443),
+		__createFlow(syscall.IPPROTO_TCP, "10.0.0.1", "10.0.0.2", 22),
+	})
+	top.Input([]byte("2"))
+	assert.Equal(t, []Row{{Key: "10.0.0.2", Flows: 2, Packets: 4, Bytes: 4096}}, top.Rows())
+	assert.Equal(t, 2, top.flows)
+
+	top.Snapshot(nil)
+	top.Tick(time.Now().Add(time.Second))
+	assert.Empty(t, top.Rows())
+}
+
+func inputSortsColumns(t *testing.T) {
+	top, _ := __createTop(t, Config{})
+	top.Snapshot([]conntrack.Flow{
+		__createFlow(syscall.IPPROTO_TCP, "10.0.0.1", "10.0.0.3", 53),
+		__createFlow(syscall.IPPROTO_TCP, "10.0.0.1", "10.0.0.3", 53),
+	})
+
+	top.Input([]byte("\t"))
+	assert.Equal(t, "10.0.0.2", top.Rows()[0].Key)
+	top.Input([]byte("\x1b[C"))
+	assert.Equal(t, "10.0.0.3", top.Rows()[0].Key)
+	top.Input([]byte("\x1b[D"))
+	assert.Equal(t, "10.0.0.2", top.Rows()[0].Key)
+
+	assert.True(t, top.Input([]byte("x")))
+	assert.False(t, top.Input([]byte("q")))
+}
+
+func inputEditsFilter(t *testing.T) {
+	top, _ := __createTop(t, Config{})
+
+	top.Input([]byte("/"))
+	top.Input([]byte("destination.port == 5"))
+	top.Input([]byte("x"))
+	top.Input([]byte("\x7f"))
+	top.Input([]byte("3"))
+	assert.Equal(t, "destination.port == 53", *top.input)
+	assert.True(t, top.Input([]byte("q")), "typed in filter")
+	top.Input([]byte("\x7f"))
+	top.Input([]byte("\r"))
+	assert.Nil(t, top.input)
+	assert.Equal(t, "destination.port == 53", top.expr)
+	assert.Empty(t, top.Rows(), "counters reset")
+
+	top.Event(__createEvent(syscall.IPPROTO_TCP, "10.0.0.1", "10.0.0.2", 443))
+	top.Event(__createEvent(syscall.IPPROTO_UDP, "10.0.0.1", "10.0.0.3", 53))
+	top.Tick(top.ticked.Add(time.Second))
+	assert.Equal(t, []Row{{Key: "10.0.0.1", Rate: 1}}, top.Rows())
+
+	top.Input([]byte("/"))
+	top.Input([]byte(" &&"))
+	top.Input([]byte("\r"))
+	assert.NotNil(t, top.input)
+	assert.True(t, strings.HasPrefix(top.status, "Invalid filter:"))
+	top.Input([]byte("\x1b"))
+	assert.Nil(t, top.input)
+	assert.Empty(t, top.status)
+	assert.Equal(t, "destination.port == 53", top.expr)
+
+	top.Input([]byte("/\x15destination.port == 443\r2"))
+	assert.Nil(t, top.input)
+	assert.Equal(t, "destination.port == 443", top.expr)
+	assert.Equal(t, 1, top.view)
+
+	require.NoError(t, top.SetFilter(""))
+	assert.Nil(t, top.filter)
+}
+
+func splitKeysSplitsInput(t *testing.T) {
+	assert.Equal(t, []string{"a", "\x1b[C", "\x1b", "q", "ü", "\x1b[1;5D", "\r"}, splitKeys([]byte("a\x1b[C\x1bqü\x1b[1;5D\r")))
+	assert.Equal(t, []string{"\x1b"}, splitKeys([]byte("\x1b")))
+	assert.Empty(t, splitKeys(nil))
+}
+
+func renderFitsTerminal(t *testing.T) {
+	top, now := __createTop(t, Config{})
+	top.Input([]byte("2"))
+
+	lines := top.Render(80, 10, now)
+	require.Len(t, lines, 10)
+	assert.True(t, strings.HasPrefix(lines[0], "conntrackd top - "))
+	assert.Contains(t, lines[0], "events/s 2.5  new/s 2.0  flows 0")
+	assert.Equal(t, "filter: none", lines[1])
+	assert.Contains(t, lines[2], reverse+" 2 destinations "+reset)
+	assert.True(t, strings.HasPrefix(lines[4], "DESTINATION"))
+	assert.Len(t, lines[5], 80)
+	assert.Equal(t, []string{"10.0.0.2", "1.5", "0", "0", "0B"}, strings.Fields(lines[5]))
+	assert.Equal(t, "10.0.0.3", strings.Fields(lines[6])[0])
+	assert.Equal(t, help, lines[9])
+
+	lines = top.Render(40, 7, now)
+	require.Len(t, lines, 7)
+	assert.Len(t, []rune(lines[0]), 40)
+	assert.Equal(t, "10.0.0.2", strings.Fields(lines[5])[0], "rows cut, status line kept")
+	assert.Equal(t, help, lines[6])
+
+	top.SetStatus("Listener failed.")
+	lines = top.Render(80, 6, now)
+	assert.Equal(t, "Listener failed.", lines[5])
+}
+
+func formatBytesUsesUnits(t *testing.T) {
+	assert.Equal(t, "512B", formatBytes(512))
+	assert.Equal(t, "1.5KiB", formatBytes(1536))
+	assert.Equal(t, "2.0GiB", formatBytes(2<<30))
+}
+
+func TestTop(t *testing.T) {
+	t.Run("top.Tick computes rates", tickComputesRates)
+	t.Run("top.Snapshot counts flows", snapshotCountsFlows)
+	t.Run("top.Input sorts columns", inputSortsColumns)
+	t.Run("top.Input edits filter", inputEditsFilter)
+	t.Run("top.splitKeys splits input", splitKeysSplitsInput)
+	t.Run("top.Render fits terminal", renderFitsTerminal)
+	t.Run("top.formatBytes uses units", formatBytesUsesUnits)
+}